@@ -0,0 +1,457 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrGeoInvalidCoord = errors.New("invalid longitude,latitude pair")
+	ErrGeoInvalidUnit  = errors.New("unsupported unit provided. please use M, KM, FT, MI")
+	ErrGeoInvalidShape = errors.New("invalid geo search shape, radius or width and height must be positive")
+	ErrGeoNXAndXX      = errors.New("XX and NX options at the same time are not compatible")
+)
+
+// geo limits and earth radius same as redis geohash.h, geohash_helper.c
+const (
+	GeoStepMax = 26
+
+	geoLonMin      = -180.0
+	geoLonMax      = 180.0
+	geoLatMin      = -85.05112878
+	geoLatMax      = 85.05112878
+	geoEarthRadius = 6372797.560856
+
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// geoUnitFactor meters of unit
+func geoUnitFactor(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "", GeoUnitM:
+		return 1, nil
+	case GeoUnitKM:
+		return 1000, nil
+	case GeoUnitMI:
+		return 1609.34, nil
+	case GeoUnitFT:
+		return 0.3048, nil
+	}
+	return 0, ErrGeoInvalidUnit
+}
+
+func geoValid(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+func interleave64(xlo, ylo uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+	x, y := uint64(xlo), uint64(ylo)
+	for i := 4; i >= 0; i-- {
+		x = (x | (x << s[i])) & b[i]
+		y = (y | (y << s[i])) & b[i]
+	}
+	return x | (y << 1)
+}
+
+func deinterleave64(interleaved uint64) (x, y uint32) {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	xx, yy := interleaved, interleaved>>1
+	for i := range b {
+		xx = (xx | (xx >> s[i])) & b[i]
+		yy = (yy | (yy >> s[i])) & b[i]
+	}
+	return uint32(xx), uint32(yy)
+}
+
+// geoCellIndex index of v in [min, max] split to 1<<step cells
+func geoCellIndex(v, min, max float64, step uint) uint32 {
+	n := float64(uint64(1) << step)
+	i := (v - min) / (max - min) * n
+	if i < 0 {
+		i = 0
+	}
+	if i >= n {
+		i = n - 1
+	}
+	return uint32(i)
+}
+
+// geohashEncode interleave latitude (even bits) and longitude (odd bits) cell index
+func geohashEncode(lon, lat, latMin, latMax float64, step uint) uint64 {
+	return interleave64(geoCellIndex(lat, latMin, latMax, step), geoCellIndex(lon, geoLonMin, geoLonMax, step))
+}
+
+// GeoEncode encode location to 52-bit geohash score
+func GeoEncode(lon, lat float64) int64 {
+	return int64(geohashEncode(lon, lat, geoLatMin, geoLatMax, GeoStepMax))
+}
+
+// GeoDecode decode 52-bit geohash score to the center of its cell
+func GeoDecode(hash int64) (lon, lat float64) {
+	ilat, ilon := deinterleave64(uint64(hash))
+	n := float64(uint64(1) << GeoStepMax)
+	lat = geoLatMin + (float64(ilat)+0.5)/n*(geoLatMax-geoLatMin)
+	lon = geoLonMin + (float64(ilon)+0.5)/n*(geoLonMax-geoLonMin)
+	return math.Max(geoLonMin, math.Min(geoLonMax, lon)), math.Max(geoLatMin, math.Min(geoLatMax, lat))
+}
+
+// GeoHashString standard 11 characters geohash string of 52-bit geohash score
+func GeoHashString(hash int64) []byte {
+	lon, lat := GeoDecode(hash)
+	bits := geohashEncode(lon, lat, -90, 90, GeoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return buf
+}
+
+func geoRad(deg float64) float64 { return deg * math.Pi / 180 }
+func geoDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// GeoDistance haversine distance in meters
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := geoRad(lat1), geoRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(geoRad(lon2-lon1) / 2)
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// GeoStoreDistScale GeoSearchStore stores distance * GeoStoreDistScale as integer score,
+// it keeps the 4 decimals of distance reply, use GeoStoreDist to decode the score
+const GeoStoreDistScale = 10000
+
+// GeoStoreDist decode the distance in query unit from score stored by GeoSearchStore with storeDist
+func GeoStoreDist(score int64) float64 {
+	return float64(score) / GeoStoreDistScale
+}
+
+// geoRound round distance to 4 decimals like redis reply
+func geoRound(d float64) float64 {
+	return math.Round(d*GeoStoreDistScale) / GeoStoreDistScale
+}
+
+// GeoCmd IGeoCmd over IZsetCmd, location is stored as 52-bit geohash score of member like redis
+type GeoCmd struct {
+	ICommonCmd
+	zset IZsetCmd
+}
+
+func NewGeoCmd(zset IZsetCmd) *GeoCmd {
+	return &GeoCmd{ICommonCmd: zset, zset: zset}
+}
+
+// score return false if member not exists
+func (c *GeoCmd) score(ctx context.Context, key []byte, member []byte) (int64, bool, error) {
+	score, err := c.zset.ZScore(ctx, key, member)
+	if errors.Is(err, ErrScoreMiss) {
+		return 0, false, nil
+	}
+	return score, err == nil, err
+}
+
+func (c *GeoCmd) GeoAdd(ctx context.Context, key []byte, args GeoAddArgs, locations ...GeoLocation) (int64, error) {
+	if args.NX && args.XX {
+		return 0, ErrGeoNXAndXX
+	}
+	pairs := make([]ScorePair, 0, len(locations))
+	for _, l := range locations {
+		if !geoValid(l.Longitude, l.Latitude) {
+			return 0, ErrGeoInvalidCoord
+		}
+		pairs = append(pairs, ScorePair{Score: GeoEncode(l.Longitude, l.Latitude), Member: l.Member})
+	}
+	if !args.NX && !args.XX && !args.CH {
+		return c.zset.ZAdd(ctx, key, pairs...)
+	}
+
+	n, added := int64(0), pairs[:0]
+	for _, p := range pairs {
+		old, ok, err := c.score(ctx, key, p.Member)
+		if err != nil {
+			return 0, err
+		}
+		if (args.NX && ok) || (args.XX && !ok) || (ok && old == p.Score) {
+			continue
+		}
+		if !ok || args.CH {
+			n++
+		}
+		added = append(added, p)
+	}
+	if len(added) > 0 {
+		if _, err := c.zset.ZAdd(ctx, key, added...); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (c *GeoCmd) GeoPos(ctx context.Context, key []byte, members ...[]byte) ([]*GeoLocation, error) {
+	res := make([]*GeoLocation, len(members))
+	for i, m := range members {
+		score, ok, err := c.score(ctx, key, m)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		lon, lat := GeoDecode(score)
+		res[i] = &GeoLocation{Member: m, Longitude: lon, Latitude: lat, Hash: score}
+	}
+	return res, nil
+}
+
+func (c *GeoCmd) GeoDist(ctx context.Context, key []byte, member1, member2 []byte, unit string) (float64, error) {
+	f, err := geoUnitFactor(unit)
+	if err != nil {
+		return 0, err
+	}
+	score1, err := c.zset.ZScore(ctx, key, member1)
+	if err != nil {
+		return 0, err
+	}
+	score2, err := c.zset.ZScore(ctx, key, member2)
+	if err != nil {
+		return 0, err
+	}
+
+	lon1, lat1 := GeoDecode(score1)
+	lon2, lat2 := GeoDecode(score2)
+	return geoRound(GeoDistance(lon1, lat1, lon2, lat2) / f), nil
+}
+
+func (c *GeoCmd) GeoHash(ctx context.Context, key []byte, members ...[]byte) ([][]byte, error) {
+	res := make([][]byte, len(members))
+	for i, m := range members {
+		score, ok, err := c.score(ctx, key, m)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res[i] = GeoHashString(score)
+		}
+	}
+	return res, nil
+}
+
+// geoShape search shape in meters
+type geoShape struct {
+	lon, lat              float64
+	radius, width, height float64
+}
+
+// contains return distance in meters and true if location is in shape,
+// box is checked like redis geohashGetDistanceIfInRectangle
+func (s *geoShape) contains(lon, lat float64) (float64, bool) {
+	if s.radius > 0 {
+		d := GeoDistance(s.lon, s.lat, lon, lat)
+		return d, d <= s.radius
+	}
+	if geoEarthRadius*math.Abs(geoRad(lat-s.lat)) > s.height/2 {
+		return 0, false
+	}
+	if GeoDistance(lon, lat, s.lon, lat) > s.width/2 {
+		return 0, false
+	}
+	return GeoDistance(s.lon, s.lat, lon, lat), true
+}
+
+// bounds half of the latitude and longitude span in degrees which covers the shape
+func (s *geoShape) bounds() (latDelta, lonDelta float64) {
+	const margin = 1e-9
+	if s.radius > 0 {
+		latDelta = geoDeg(s.radius/geoEarthRadius) + margin
+		if math.Abs(s.lat)+latDelta >= 90 {
+			return latDelta, 180
+		}
+		x := math.Sin(s.radius/geoEarthRadius) / math.Cos(geoRad(s.lat))
+		if x >= 1 {
+			return latDelta, 180
+		}
+		return latDelta, geoDeg(math.Asin(x)) + margin
+	}
+
+	latDelta = geoDeg(s.height/2/geoEarthRadius) + margin
+	maxLat := math.Abs(s.lat) + latDelta
+	if maxLat >= 90 {
+		return latDelta, 180
+	}
+	x := math.Sin(s.width/4/geoEarthRadius) / math.Cos(geoRad(maxLat))
+	if x >= 1 {
+		return latDelta, 180
+	}
+	return latDelta, geoDeg(2*math.Asin(x)) + margin
+}
+
+// geoIndexRange cell index range [lo, hi]
+type geoIndexRange struct {
+	lo, hi uint32
+}
+
+// scoreRanges score ranges of the cells which cover the shape,
+// the cells are at the largest step whose cell is not smaller than the span of shape,
+// so there are at most 2 cells in each dimension.
+func (s *geoShape) scoreRanges() [][2]int64 {
+	latDelta, lonDelta := s.bounds()
+	step := uint(GeoStepMax)
+	for step > 0 {
+		n := float64(uint64(1) << step)
+		if (geoLatMax-geoLatMin)/n >= 2*latDelta && (geoLonMax-geoLonMin)/n >= 2*lonDelta {
+			break
+		}
+		step--
+	}
+	n := uint32(1<<step) - 1
+
+	lats := geoIndexRange{
+		geoCellIndex(s.lat-latDelta, geoLatMin, geoLatMax, step),
+		geoCellIndex(s.lat+latDelta, geoLatMin, geoLatMax, step),
+	}
+	lons := []geoIndexRange{}
+	lo, hi := s.lon-lonDelta, s.lon+lonDelta
+	switch {
+	case lonDelta >= 180:
+		lons = append(lons, geoIndexRange{0, n})
+	case lo < geoLonMin:
+		lons = append(lons, geoIndexRange{geoCellIndex(lo+360, geoLonMin, geoLonMax, step), n},
+			geoIndexRange{0, geoCellIndex(hi, geoLonMin, geoLonMax, step)})
+	case hi > geoLonMax:
+		lons = append(lons, geoIndexRange{geoCellIndex(lo, geoLonMin, geoLonMax, step), n},
+			geoIndexRange{0, geoCellIndex(hi-360, geoLonMin, geoLonMax, step)})
+	default:
+		lons = append(lons, geoIndexRange{geoCellIndex(lo, geoLonMin, geoLonMax, step), geoCellIndex(hi, geoLonMin, geoLonMax, step)})
+	}
+
+	shift := 2 * (GeoStepMax - step)
+	seen := map[uint64]struct{}{}
+	ranges := [][2]int64{}
+	for ilat := lats.lo; ilat <= lats.hi; ilat++ {
+		for _, r := range lons {
+			for ilon := r.lo; ilon <= r.hi; ilon++ {
+				bits := interleave64(ilat, ilon)
+				if _, ok := seen[bits]; ok {
+					continue
+				}
+				seen[bits] = struct{}{}
+				ranges = append(ranges, [2]int64{int64(bits << shift), int64((bits+1)<<shift) - 1})
+			}
+		}
+	}
+	return ranges
+}
+
+func (c *GeoCmd) GeoSearch(ctx context.Context, key []byte, query GeoSearchQuery) ([]GeoLocation, error) {
+	res, err := c.search(ctx, key, &query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		if !query.WithDist {
+			res[i].Dist = 0
+		}
+		if !query.WithCoord {
+			res[i].Longitude, res[i].Latitude = 0, 0
+		}
+		if !query.WithHash {
+			res[i].Hash = 0
+		}
+	}
+	return res, nil
+}
+
+func (c *GeoCmd) GeoSearchStore(ctx context.Context, destKey []byte, srcKey []byte, query GeoSearchQuery, storeDist bool) (int64, error) {
+	res, err := c.search(ctx, srcKey, &query)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.zset.Del(ctx, destKey); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+
+	pairs := make([]ScorePair, len(res))
+	for i, l := range res {
+		pairs[i] = ScorePair{Score: l.Hash, Member: l.Member}
+		if storeDist {
+			pairs[i].Score = int64(math.Round(l.Dist * GeoStoreDistScale))
+		}
+	}
+	if _, err := c.zset.ZAdd(ctx, destKey, pairs...); err != nil {
+		return 0, err
+	}
+	return int64(len(pairs)), nil
+}
+
+// search return the locations in shape of query with all fields
+func (c *GeoCmd) search(ctx context.Context, key []byte, query *GeoSearchQuery) ([]GeoLocation, error) {
+	f, err := geoUnitFactor(query.Unit)
+	if err != nil {
+		return nil, err
+	}
+	shape := &geoShape{lon: query.Longitude, lat: query.Latitude,
+		radius: query.Radius * f, width: query.Width * f, height: query.Height * f}
+	if shape.radius <= 0 && (shape.width <= 0 || shape.height <= 0) {
+		return nil, ErrGeoInvalidShape
+	}
+	if query.FromMember != nil {
+		score, err := c.zset.ZScore(ctx, key, query.FromMember)
+		if err != nil {
+			return nil, err
+		}
+		shape.lon, shape.lat = GeoDecode(score)
+	} else if !geoValid(shape.lon, shape.lat) {
+		return nil, ErrGeoInvalidCoord
+	}
+
+	sortType := strings.ToUpper(query.Sort)
+	if sortType == "" && query.Count > 0 && !query.Any {
+		sortType = GeoSortAsc
+	}
+
+	res := []GeoLocation{}
+	for _, r := range shape.scoreRanges() {
+		pairs, err := c.zset.ZRangeByScoreGeneric(ctx, key, r[0], r[1], 0, -1, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pairs {
+			lon, lat := GeoDecode(p.Score)
+			d, ok := shape.contains(lon, lat)
+			if !ok {
+				continue
+			}
+			res = append(res, GeoLocation{Member: p.Member, Longitude: lon, Latitude: lat, Dist: geoRound(d / f), Hash: p.Score})
+			if query.Any && query.Count > 0 && len(res) >= query.Count {
+				break
+			}
+		}
+		if query.Any && query.Count > 0 && len(res) >= query.Count {
+			break
+		}
+	}
+
+	switch sortType {
+	case GeoSortAsc:
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Dist < res[j].Dist })
+	case GeoSortDesc:
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Dist > res[j].Dist })
+	}
+	if query.Count > 0 && len(res) > query.Count {
+		res = res[:query.Count]
+	}
+	return res, nil
+}
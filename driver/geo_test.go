@@ -0,0 +1,191 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// geoZSet fake zset of the ops used by GeoCmd, ZRangeByScoreGeneric returns all pairs in score order
+type geoZSet struct {
+	IZsetCmd
+	keys map[string]map[string]int64
+}
+
+func newGeoZSet() *geoZSet {
+	return &geoZSet{keys: map[string]map[string]int64{}}
+}
+
+func (z *geoZSet) ZAdd(ctx context.Context, key []byte, args ...ScorePair) (n int64, err error) {
+	if z.keys[string(key)] == nil {
+		z.keys[string(key)] = map[string]int64{}
+	}
+	for _, p := range args {
+		if _, ok := z.keys[string(key)][string(p.Member)]; !ok {
+			n++
+		}
+		z.keys[string(key)][string(p.Member)] = p.Score
+	}
+	return n, nil
+}
+
+func (z *geoZSet) ZScore(ctx context.Context, key []byte, member []byte) (int64, error) {
+	score, ok := z.keys[string(key)][string(member)]
+	if !ok {
+		return 0, ErrScoreMiss
+	}
+	return score, nil
+}
+
+func (z *geoZSet) ZRangeByScoreGeneric(ctx context.Context, key []byte, min int64, max int64, offset int, count int, reverse bool) ([]ScorePair, error) {
+	pairs := []ScorePair{}
+	for m, score := range z.keys[string(key)] {
+		if score >= min && score <= max {
+			pairs = append(pairs, ScorePair{Score: score, Member: []byte(m)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score < pairs[j].Score
+		}
+		return string(pairs[i].Member) < string(pairs[j].Member)
+	})
+	return pairs, nil
+}
+
+func (z *geoZSet) Del(ctx context.Context, keys ...[]byte) (n int64, err error) {
+	for _, k := range keys {
+		if _, ok := z.keys[string(k)]; ok {
+			delete(z.keys, string(k))
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newSicily(t *testing.T) *GeoCmd {
+	geo := NewGeoCmd(newGeoZSet())
+	n, err := geo.GeoAdd(context.Background(), []byte("Sicily"), GeoAddArgs{},
+		GeoLocation{Longitude: 13.361389, Latitude: 38.115556, Member: []byte("Palermo")},
+		GeoLocation{Longitude: 15.087269, Latitude: 37.502669, Member: []byte("Catania")},
+		GeoLocation{Longitude: 12.758489, Latitude: 38.788135, Member: []byte("edge1")},
+		GeoLocation{Longitude: 17.241510, Latitude: 38.788135, Member: []byte("edge2")},
+	)
+	if err != nil || n != 4 {
+		t.Fatalf("Got %d, %v expected %d", n, err, 4)
+	}
+	return geo
+}
+
+func geoMembers(locs []GeoLocation) string {
+	s := ""
+	for _, l := range locs {
+		s += fmt.Sprintf("%s:%v ", l.Member, l.Dist)
+	}
+	return s
+}
+
+func TestGeoCmd(t *testing.T) {
+	ctx := context.Background()
+	geo := newSicily(t)
+	key := []byte("Sicily")
+
+	// values are the replies of redis
+	score, _ := geo.zset.ZScore(ctx, key, []byte("Palermo"))
+	if score != 3479099956230698 {
+		t.Errorf("Got %d expected %d", score, int64(3479099956230698))
+	}
+	dist, err := geo.GeoDist(ctx, key, []byte("Palermo"), []byte("Catania"), "km")
+	if err != nil || dist != 166.2742 {
+		t.Errorf("Got %v, %v expected %v", dist, err, 166.2742)
+	}
+	if _, err := geo.GeoDist(ctx, key, []byte("Palermo"), []byte("none"), ""); err != ErrScoreMiss {
+		t.Errorf("Got %v expected %v", err, ErrScoreMiss)
+	}
+	hashes, _ := geo.GeoHash(ctx, key, []byte("Palermo"), []byte("Catania"), []byte("none"))
+	if fmt.Sprintf("%q", hashes) != `["sqc8b49rny0" "sqdtr74hyu0" ""]` || hashes[2] != nil {
+		t.Errorf("Got %q", hashes)
+	}
+	pos, _ := geo.GeoPos(ctx, key, []byte("Palermo"), []byte("none"))
+	if pos[1] != nil || fmt.Sprintf("%.6f,%.6f", pos[0].Longitude, pos[0].Latitude) != "13.361389,38.115556" {
+		t.Errorf("Got %+v", pos)
+	}
+
+	res, err := geo.GeoSearch(ctx, key, GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Sort: GeoSortAsc, WithDist: true})
+	if got, expected := geoMembers(res), "Catania:56.4413 Palermo:190.4424 "; err != nil || got != expected {
+		t.Errorf("radius Got %s, %v expected %s", got, err, expected)
+	}
+	res, err = geo.GeoSearch(ctx, key, GeoSearchQuery{Longitude: 15, Latitude: 37, Width: 400, Height: 400, Unit: "km", Sort: GeoSortDesc, WithDist: true})
+	if got, expected := geoMembers(res), "edge1:279.7405 edge2:279.7403 Palermo:190.4424 Catania:56.4413 "; err != nil || got != expected {
+		t.Errorf("box Got %s, %v expected %s", got, err, expected)
+	}
+	res, err = geo.GeoSearch(ctx, key, GeoSearchQuery{FromMember: []byte("Palermo"), Radius: 200, Unit: "km", Count: 1})
+	if got, expected := geoMembers(res), "Palermo:0 "; err != nil || got != expected {
+		t.Errorf("count Got %s, %v expected %s", got, err, expected)
+	}
+	if res[0].Longitude != 0 || res[0].Hash != 0 {
+		t.Errorf("Got %+v without coord and hash", res[0])
+	}
+
+	n, err := geo.GeoSearchStore(ctx, []byte("dst"), key, GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km"}, true)
+	if err != nil || n != 2 {
+		t.Errorf("Got %d, %v expected %d", n, err, 2)
+	}
+	// distance 56.4413 km is kept in 4 decimals
+	if d, _ := geo.zset.ZScore(ctx, []byte("dst"), []byte("Catania")); d != 564413 || GeoStoreDist(d) != 56.4413 {
+		t.Errorf("Got %d expected %d", d, 564413)
+	}
+	n, _ = geo.GeoSearchStore(ctx, []byte("dst"), key, GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 100, Unit: "m"}, true)
+	if d, err := geo.zset.ZScore(ctx, []byte("dst"), []byte("Catania")); n != 0 || err != ErrScoreMiss {
+		t.Errorf("Got %d, %d, %v expected dst is removed", n, d, err)
+	}
+}
+
+func TestGeoAddArgs(t *testing.T) {
+	ctx := context.Background()
+	geo := newSicily(t)
+	key := []byte("Sicily")
+
+	for _, c := range []struct {
+		args     GeoAddArgs
+		expected int64
+	}{
+		{GeoAddArgs{NX: true}, 1},
+		{GeoAddArgs{XX: true, CH: true}, 1},
+		{GeoAddArgs{CH: true}, 1},
+		{GeoAddArgs{NX: true, CH: true}, 0},
+	} {
+		n, err := geo.GeoAdd(ctx, key, c.args,
+			GeoLocation{Longitude: 13.5, Latitude: 38, Member: []byte("Palermo")},
+			GeoLocation{Longitude: 14, Latitude: 38, Member: []byte(fmt.Sprintf("new%v", c.args.NX))},
+		)
+		if err != nil || n != c.expected {
+			t.Errorf("%+v Got %d, %v expected %d", c.args, n, err, c.expected)
+		}
+	}
+	if _, err := geo.GeoAdd(ctx, key, GeoAddArgs{}, GeoLocation{Longitude: 0, Latitude: 86}); err != ErrGeoInvalidCoord {
+		t.Errorf("Got %v expected %v", err, ErrGeoInvalidCoord)
+	}
+}
+
+func TestGeoSearchWrapAround(t *testing.T) {
+	ctx := context.Background()
+	geo := NewGeoCmd(newGeoZSet())
+	key := []byte("geo")
+	geo.GeoAdd(ctx, key, GeoAddArgs{},
+		GeoLocation{Longitude: 179.99, Latitude: 0, Member: []byte("east")},
+		GeoLocation{Longitude: -179.99, Latitude: 0, Member: []byte("west")},
+		GeoLocation{Longitude: 0, Latitude: 85, Member: []byte("north")},
+		GeoLocation{Longitude: 180, Latitude: 85, Member: []byte("north180")},
+	)
+
+	res, _ := geo.GeoSearch(ctx, key, GeoSearchQuery{Longitude: 180, Latitude: 0, Radius: 10, Unit: "km", Sort: GeoSortAsc})
+	if got, expected := geoMembers(res), "east:0 west:0 "; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	res, _ = geo.GeoSearch(ctx, key, GeoSearchQuery{Longitude: 90, Latitude: 85, Radius: 1200, Unit: "km", Sort: GeoSortAsc})
+	if got, expected := geoMembers(res), "north:0 north180:0 "; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+}
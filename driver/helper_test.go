@@ -0,0 +1,294 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// memStorager fake storager which implements the commands used by RDB export and import
+type memStorager struct {
+	dbs map[int]*memDB
+}
+
+func newMemStorager() *memStorager {
+	return &memStorager{dbs: map[int]*memDB{}}
+}
+
+func (s *memStorager) Select(ctx context.Context, index int) (IDB, error) {
+	if s.dbs[index] == nil {
+		s.dbs[index] = &memDB{data: map[string]*memValue{}}
+	}
+	return s.dbs[index], nil
+}
+
+func (s *memStorager) FlushAll(ctx context.Context) error { return nil }
+func (s *memStorager) Open(ctx context.Context) error     { return nil }
+func (s *memStorager) Close() error                       { return nil }
+func (s *memStorager) Name() string                       { return "mem" }
+
+type memValue struct {
+	dataType string
+	str      []byte
+	list     [][]byte
+	hash     map[string][]byte
+	set      map[string]struct{}
+	zset     map[string]int64
+	expireAt int64
+}
+
+type memDB struct {
+	IDB
+	data map[string]*memValue
+}
+
+func (db *memDB) value(key []byte, dataType string) *memValue {
+	v := db.data[string(key)]
+	if v == nil {
+		v = &memValue{dataType: dataType, hash: map[string][]byte{}, set: map[string]struct{}{}, zset: map[string]int64{}}
+		db.data[string(key)] = v
+	}
+	return v
+}
+
+func (db *memDB) DBString() IStringCmd { return memString{db: db} }
+func (db *memDB) DBList() IListCmd     { return memList{db: db} }
+func (db *memDB) DBHash() IHashCmd     { return memHash{db: db} }
+func (db *memDB) DBSet() ISetCmd       { return memSet{db: db} }
+func (db *memDB) DBZSet() IZsetCmd     { return memZSet{db: db} }
+
+type memCommon struct {
+	ICommonCmd
+	db *memDB
+}
+
+func (c memCommon) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	c.db.data[string(key)].expireAt = when * 1000
+	return 1, nil
+}
+
+type memString struct {
+	IStringCmd
+	db *memDB
+}
+
+func (c memString) Set(ctx context.Context, key []byte, value []byte) error {
+	c.db.value(key, CmdTypeString).str = value
+	return nil
+}
+
+func (c memString) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	return memCommon{db: c.db}.ExpireAt(ctx, key, when)
+}
+
+type memList struct {
+	IListCmd
+	db *memDB
+}
+
+func (c memList) RPush(ctx context.Context, key []byte, args ...[]byte) (int64, error) {
+	v := c.db.value(key, CmdTypeList)
+	v.list = append(v.list, args...)
+	return int64(len(v.list)), nil
+}
+
+func (c memList) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	return memCommon{db: c.db}.ExpireAt(ctx, key, when)
+}
+
+type memHash struct {
+	IHashCmd
+	db *memDB
+}
+
+func (c memHash) HMset(ctx context.Context, key []byte, args ...FVPair) error {
+	v := c.db.value(key, CmdTypeHash)
+	for _, fv := range args {
+		v.hash[string(fv.Field)] = fv.Value
+	}
+	return nil
+}
+
+func (c memHash) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	return memCommon{db: c.db}.ExpireAt(ctx, key, when)
+}
+
+type memSet struct {
+	ISetCmd
+	db *memDB
+}
+
+func (c memSet) SAdd(ctx context.Context, key []byte, args ...[]byte) (int64, error) {
+	v := c.db.value(key, CmdTypeSet)
+	for _, m := range args {
+		v.set[string(m)] = struct{}{}
+	}
+	return int64(len(args)), nil
+}
+
+func (c memSet) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	return memCommon{db: c.db}.ExpireAt(ctx, key, when)
+}
+
+type memZSet struct {
+	IZsetCmd
+	db *memDB
+}
+
+func (c memZSet) ZAdd(ctx context.Context, key []byte, args ...ScorePair) (int64, error) {
+	v := c.db.value(key, CmdTypeZset)
+	for _, p := range args {
+		v.zset[string(p.Member)] = p.Score
+	}
+	return int64(len(args)), nil
+}
+
+func (c memZSet) ExpireAt(ctx context.Context, key []byte, when int64) (int64, error) {
+	return memCommon{db: c.db}.ExpireAt(ctx, key, when)
+}
+
+func (db *memDB) Snapshot(ctx context.Context) (IDBSnapshot, error) {
+	return memSnapshot{db}, nil
+}
+
+type memSnapshot struct {
+	db *memDB
+}
+
+func (s memSnapshot) Walk(ctx context.Context, fn func(key []byte, dataType string, expireAt int64) error) error {
+	keys := []string{}
+	for k := range s.db.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn([]byte(k), s.db.data[k].dataType, s.db.data[k].expireAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s memSnapshot) String(ctx context.Context, key []byte) ([]byte, error) {
+	return s.db.data[string(key)].str, nil
+}
+
+func (s memSnapshot) List(ctx context.Context, key []byte) ([][]byte, error) {
+	return s.db.data[string(key)].list, nil
+}
+
+func (s memSnapshot) Hash(ctx context.Context, key []byte) (fvs []FVPair, err error) {
+	for f, v := range s.db.data[string(key)].hash {
+		fvs = append(fvs, FVPair{Field: []byte(f), Value: v})
+	}
+	return
+}
+
+func (s memSnapshot) Set(ctx context.Context, key []byte) (members [][]byte, err error) {
+	for m := range s.db.data[string(key)].set {
+		members = append(members, []byte(m))
+	}
+	return
+}
+
+func (s memSnapshot) ZSet(ctx context.Context, key []byte) (pairs []ScorePair, err error) {
+	for m, score := range s.db.data[string(key)].zset {
+		pairs = append(pairs, ScorePair{Member: []byte(m), Score: score})
+	}
+	return
+}
+
+func (s memSnapshot) Close() {}
+
+func (db *memDB) dump() string {
+	keys := []string{}
+	for k := range db.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	for _, k := range keys {
+		v := db.data[k]
+		fmt.Fprintf(buf, "%s:%s:%d:%s%q%v%v%v;", k, v.dataType, v.expireAt, v.str, v.list, v.hash, v.set, v.zset)
+	}
+	return buf.String()
+}
+
+func (c memString) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, ok := c.db.data[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return v.str, nil
+}
+
+func (c memString) SetRange(ctx context.Context, key []byte, offset int, value []byte) (int64, error) {
+	v := c.db.value(key, CmdTypeString)
+	if n := offset + len(value); n > len(v.str) {
+		v.str = append(v.str, make([]byte, n-len(v.str))...)
+	}
+	copy(v.str[offset:], value)
+	return int64(len(v.str)), nil
+}
+
+func (c memString) TTL(ctx context.Context, key []byte) (int64, error) {
+	if v, ok := c.db.data[string(key)]; ok && v.expireAt > 0 {
+		return v.expireAt/1000 - time.Now().Unix(), nil
+	}
+	return -1, nil
+}
+
+func (c memString) Expire(ctx context.Context, key []byte, duration int64) (int64, error) {
+	return c.ExpireAt(ctx, key, time.Now().Unix()+duration)
+}
+
+func (db *memDB) DBStream() IStreamCmd { return nil }
+
+// scan keys of data type in key order, like a sorted keyspace of storager
+func (db *memDB) scan(dataType string, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	match, err := ScanMatcher(args.Match)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := []string{}
+	for k, v := range db.data {
+		if v.dataType == dataType && k > string(cursor) {
+			all = append(all, k)
+		}
+	}
+	sort.Strings(all)
+
+	keys := [][]byte{}
+	for i, k := range all {
+		if i == args.Count {
+			return []byte(all[i-1]), keys, nil
+		}
+		if match([]byte(k)) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	return nil, keys, nil
+}
+
+func (c memString) Scan(ctx context.Context, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	return c.db.scan(CmdTypeString, cursor, args)
+}
+
+func (c memHash) Scan(ctx context.Context, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	return c.db.scan(CmdTypeHash, cursor, args)
+}
+
+func (c memSet) Scan(ctx context.Context, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	return c.db.scan(CmdTypeSet, cursor, args)
+}
+
+func (c memList) Scan(ctx context.Context, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	return c.db.scan(CmdTypeList, cursor, args)
+}
+
+func (c memZSet) Scan(ctx context.Context, cursor []byte, args ScanArgs) ([]byte, [][]byte, error) {
+	return c.db.scan(CmdTypeZset, cursor, args)
+}
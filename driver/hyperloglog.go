@@ -0,0 +1,460 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	ErrHLLWrongType = errors.New("key is not a valid HyperLogLog string value")
+	ErrHLLCorrupted = errors.New("corrupted HLL object detected")
+)
+
+// hyperloglog layout same as redis hyperloglog.c:
+// header: "HYLL" | encoding(1) | unused(3) | cached cardinality(8, little endian, msb of last byte set if invalid)
+// dense: 16384 registers of 6 bits
+// sparse: ZERO 00xxxxxx, XZERO 01xxxxxx yyyyyyyy, VAL 1vvvvvxx opcodes
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllPMask      = hllRegisters - 1
+	hllBits       = 6
+	hllRegMax     = 1<<hllBits - 1
+	hllHdrSize    = 16
+	hllDenseSize  = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense      = 0
+	hllSparse     = 1
+	hllAlphaInf   = 0.721347520444481703680
+	hllHashSeed   = 0xadc83b19
+	hllMagic      = "HYLL"
+	hllSparseVMax = 32
+
+	hllZeroMaxLen  = 64
+	hllXZeroMaxLen = 16384
+	hllValMaxLen   = 4
+)
+
+// HLLSparseMaxBytes sparse is promoted to dense if larger, same as redis hll-sparse-max-bytes default
+var HLLSparseMaxBytes = 3000
+
+// murmurHash64A same as redis MurmurHash64A
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+
+	n := len(key) - len(key)&7
+	for i := 0; i < n; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if tail := key[n:]; len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen register index and run length of zeros + 1 of element
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, hllHashSeed)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// hll decoded hyperloglog
+type hll struct {
+	sparse    bool
+	regs      []uint8
+	card      uint64
+	cardValid bool
+}
+
+func newHLL() *hll {
+	return &hll{sparse: true, regs: make([]uint8, hllRegisters), cardValid: true}
+}
+
+func decodeHLL(v []byte) (*hll, error) {
+	if len(v) < hllHdrSize || string(v[:4]) != hllMagic || v[4] > hllSparse ||
+		(v[4] == hllDense && len(v) != hllDenseSize) {
+		return nil, ErrHLLWrongType
+	}
+
+	h := &hll{sparse: v[4] == hllSparse, regs: make([]uint8, hllRegisters)}
+	h.cardValid = v[15]&(1<<7) == 0
+	h.card = binary.LittleEndian.Uint64(v[8:16])
+	if !h.sparse {
+		for i := range h.regs {
+			h.regs[i] = hllDenseGet(v[hllHdrSize:], i)
+		}
+		return h, nil
+	}
+
+	idx := 0
+	for p := hllHdrSize; p < len(v); p++ {
+		op, runlen, val := v[p], 0, uint8(0)
+		switch {
+		case op&0xc0 == 0x00:
+			runlen = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 >= len(v) {
+				return nil, ErrHLLCorrupted
+			}
+			p++
+			runlen = (int(op&0x3f)<<8 | int(v[p])) + 1
+		default:
+			runlen, val = int(op&0x3)+1, (op>>2)&0x1f+1
+		}
+		if idx+runlen > hllRegisters {
+			return nil, ErrHLLCorrupted
+		}
+		for ; runlen > 0; runlen-- {
+			h.regs[idx] = val
+			idx++
+		}
+	}
+	if idx != hllRegisters {
+		return nil, ErrHLLCorrupted
+	}
+	return h, nil
+}
+
+func hllDenseGet(p []byte, i int) uint8 {
+	byt, fb := i*hllBits/8, uint(i*hllBits&7)
+	b0, b1 := uint(p[byt]), uint(0)
+	if byt+1 < len(p) {
+		b1 = uint(p[byt+1])
+	}
+	return uint8((b0>>fb | b1<<(8-fb)) & hllRegMax)
+}
+
+func hllDenseSet(p []byte, i int, v uint8) {
+	byt, fb := i*hllBits/8, uint(i*hllBits&7)
+	p[byt] &^= hllRegMax << fb
+	p[byt] |= v << fb
+	if byt+1 < len(p) {
+		p[byt+1] &^= hllRegMax >> (8 - fb)
+		p[byt+1] |= v >> (8 - fb)
+	}
+}
+
+// encode encode to sparse if it is sparse and fits, else to dense
+func (h *hll) encode() []byte {
+	if h.sparse {
+		if v := h.encodeSparse(); v != nil {
+			return v
+		}
+		h.sparse = false
+	}
+
+	v := append(h.header(hllDense), make([]byte, hllDenseSize-hllHdrSize)...)
+	for i, r := range h.regs {
+		hllDenseSet(v[hllHdrSize:], i, r)
+	}
+	return v
+}
+
+func (h *hll) header(encoding byte) []byte {
+	v := make([]byte, hllHdrSize)
+	copy(v, hllMagic)
+	v[4] = encoding
+	binary.LittleEndian.PutUint64(v[8:], h.card)
+	if !h.cardValid {
+		v[15] |= 1 << 7
+	}
+	return v
+}
+
+// encodeSparse return nil if a register can't be represented or the size exceeds HLLSparseMaxBytes
+func (h *hll) encodeSparse() []byte {
+	v := h.header(hllSparse)
+	for i := 0; i < hllRegisters; {
+		val, j := h.regs[i], i+1
+		for j < hllRegisters && h.regs[j] == val {
+			j++
+		}
+		runlen := j - i
+		i = j
+
+		if val > hllSparseVMax {
+			return nil
+		}
+		for runlen > 0 {
+			switch {
+			case val > 0:
+				n := runlen
+				if n > hllValMaxLen {
+					n = hllValMaxLen
+				}
+				v = append(v, 0x80|(val-1)<<2|byte(n-1))
+				runlen -= n
+			case runlen > hllZeroMaxLen:
+				n := runlen
+				if n > hllXZeroMaxLen {
+					n = hllXZeroMaxLen
+				}
+				v = append(v, 0x40|byte((n-1)>>8), byte(n-1))
+				runlen -= n
+			default:
+				v = append(v, byte(runlen-1))
+				runlen = 0
+			}
+		}
+		if len(v) > HLLSparseMaxBytes {
+			return nil
+		}
+	}
+	return v
+}
+
+// add return true if a register is updated
+func (h *hll) add(element []byte) bool {
+	index, count := hllPatLen(element)
+	if h.regs[index] >= count {
+		return false
+	}
+	h.regs[index] = count
+	h.cardValid = false
+	return true
+}
+
+func (h *hll) merge(o *hll) {
+	for i, r := range o.regs {
+		if r > h.regs[i] {
+			h.regs[i] = r
+		}
+	}
+	h.sparse = h.sparse && o.sparse
+	h.cardValid = false
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// count estimate cardinality like redis hllCount (Ertl's improved estimator)
+func (h *hll) count() uint64 {
+	var histo [64]int
+	for _, r := range h.regs {
+		histo[r]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// HyperLogLogCmd IHyperLogLogCmd over IStringCmd, the value is redis compatible hyperloglog string,
+// so it is round-tripped with redis by GET/SET, DUMP/RESTORE and RDB.
+// read-modify-write of key is not atomic, the caller must serialize writes of key.
+type HyperLogLogCmd struct {
+	ICommonCmd
+	str IStringCmd
+}
+
+func NewHyperLogLogCmd(str IStringCmd) *HyperLogLogCmd {
+	return &HyperLogLogCmd{ICommonCmd: str, str: str}
+}
+
+func (c *HyperLogLogCmd) get(ctx context.Context, key []byte) ([]byte, *hll, error) {
+	v, err := c.str.Get(ctx, key)
+	if err != nil || v == nil {
+		return nil, nil, err
+	}
+	h, err := decodeHLL(v)
+	return v, h, err
+}
+
+// set write value of key and keep its ttl
+func (c *HyperLogLogCmd) set(ctx context.Context, key []byte, old, value []byte) error {
+	if old == nil {
+		return c.str.Set(ctx, key, value)
+	}
+	if len(value) >= len(old) {
+		_, err := c.str.SetRange(ctx, key, 0, value)
+		return err
+	}
+
+	ttl, err := c.str.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := c.str.Set(ctx, key, value); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		_, err = c.str.Expire(ctx, key, ttl)
+	}
+	return err
+}
+
+func (c *HyperLogLogCmd) PFAdd(ctx context.Context, key []byte, elements ...[]byte) (int64, error) {
+	v, err := c.str.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == hllDenseSize && string(v[:4]) == hllMagic && v[4] == hllDense {
+		return c.pfAddDense(ctx, key, v, elements)
+	}
+
+	var h *hll
+	if v != nil {
+		if h, err = decodeHLL(v); err != nil {
+			return 0, err
+		}
+	}
+	updated := h == nil
+	if h == nil {
+		h = newHLL()
+	}
+	for _, e := range elements {
+		if h.add(e) {
+			updated = true
+		}
+	}
+	if !updated {
+		return 0, nil
+	}
+
+	return 1, c.set(ctx, key, v, h.encode())
+}
+
+// pfAddDense update registers of dense in place, only the changed bytes are written
+func (c *HyperLogLogCmd) pfAddDense(ctx context.Context, key []byte, v []byte, elements [][]byte) (int64, error) {
+	regs := append([]byte{}, v[hllHdrSize:]...)
+	lo, hi := len(regs), -1
+	for _, e := range elements {
+		index, count := hllPatLen(e)
+		if hllDenseGet(regs, index) >= count {
+			continue
+		}
+		hllDenseSet(regs, index, count)
+		if byt := index * hllBits / 8; byt < lo {
+			lo = byt
+		}
+		if byt := (index*hllBits + hllBits - 1) / 8; byt > hi {
+			hi = byt
+		}
+	}
+	if hi < 0 {
+		return 0, nil
+	}
+
+	if v[15]&(1<<7) == 0 {
+		if _, err := c.str.SetRange(ctx, key, 15, []byte{v[15] | 1<<7}); err != nil {
+			return 0, err
+		}
+	}
+	_, err := c.str.SetRange(ctx, key, hllHdrSize+lo, regs[lo:hi+1])
+	return 1, err
+}
+
+// PFCount cardinality of the union of keys, the cached cardinality of single key is updated
+func (c *HyperLogLogCmd) PFCount(ctx context.Context, keys ...[]byte) (int64, error) {
+	if len(keys) == 1 {
+		_, h, err := c.get(ctx, keys[0])
+		if err != nil || h == nil {
+			return 0, err
+		}
+		if h.cardValid {
+			return int64(h.card), nil
+		}
+
+		card := binary.LittleEndian.AppendUint64(nil, h.count())
+		if _, err := c.str.SetRange(ctx, keys[0], 8, card); err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(card)), nil
+	}
+
+	u := newHLL()
+	for _, key := range keys {
+		_, h, err := c.get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if h != nil {
+			u.merge(h)
+		}
+	}
+	return int64(u.count()), nil
+}
+
+// PFMerge merge srcKeys into destKey, the result is dense if any hyperloglog is dense
+func (c *HyperLogLogCmd) PFMerge(ctx context.Context, destKey []byte, srcKeys ...[]byte) error {
+	old, u, err := c.get(ctx, destKey)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		u = newHLL()
+	}
+	for _, key := range srcKeys {
+		if bytes.Equal(key, destKey) {
+			continue
+		}
+		_, h, err := c.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			u.merge(h)
+		}
+	}
+	u.cardValid = false
+
+	return c.set(ctx, destKey, old, u.encode())
+}
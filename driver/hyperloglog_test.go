@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func newTestHLL() (*HyperLogLogCmd, *memDB) {
+	db := &memDB{data: map[string]*memValue{}}
+	return NewHyperLogLogCmd(db.DBString()), db
+}
+
+func TestHyperLogLog(t *testing.T) {
+	ctx := context.Background()
+	c, db := newTestHLL()
+	key := []byte("hll")
+
+	if n, err := c.PFAdd(ctx, key); err != nil || n != 1 {
+		t.Errorf("create Got %d, %v expected %d", n, err, 1)
+	}
+	// redis: PFADD hll creates an empty sparse hll
+	if v := db.data["hll"].str; !bytes.Equal(v, []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")) {
+		t.Errorf("Got %q", v)
+	}
+	n, err := c.PFAdd(ctx, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f"), []byte("g"))
+	if err != nil || n != 1 {
+		t.Errorf("Got %d, %v expected %d", n, err, 1)
+	}
+	if n, _ := c.PFAdd(ctx, key, []byte("a")); n != 0 {
+		t.Errorf("Got %d expected %d", n, 0)
+	}
+	if db.data["hll"].str[15]&0x80 == 0 {
+		t.Errorf("cache should be invalid after PFAdd")
+	}
+	db.DBString().Expire(ctx, key, 100)
+	for i := 0; i < 100; i++ {
+		c.PFAdd(ctx, key, []byte(fmt.Sprint(i)))
+	}
+	if ttl, _ := db.DBString().TTL(ctx, key); ttl <= 0 {
+		t.Errorf("Got ttl %d expected kept", ttl)
+	}
+	if n, err := c.PFCount(ctx, key); err != nil || n != 107 {
+		t.Errorf("Got %d, %v expected %d", n, err, 107)
+	}
+	if v := db.data["hll"].str; v[15]&0x80 != 0 || v[8] != 107 {
+		t.Errorf("cache Got %q", v[:16])
+	}
+	if n, _ := c.PFCount(ctx, []byte("none")); n != 0 {
+		t.Errorf("Got %d expected %d", n, 0)
+	}
+
+	db.DBString().Set(ctx, []byte("str"), []byte("value"))
+	if _, err := c.PFAdd(ctx, []byte("str"), []byte("a")); err != ErrHLLWrongType {
+		t.Errorf("Got %v expected %v", err, ErrHLLWrongType)
+	}
+	db.DBString().Set(ctx, []byte("bad"), []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xfe"))
+	if _, err := c.PFCount(ctx, []byte("bad")); err != ErrHLLCorrupted {
+		t.Errorf("Got %v expected %v", err, ErrHLLCorrupted)
+	}
+}
+
+func TestHyperLogLogDenseAndMerge(t *testing.T) {
+	ctx := context.Background()
+	c, db := newTestHLL()
+
+	for i := 0; i < 100000; i++ {
+		key := []byte("hll1")
+		if i%2 == 1 {
+			key = []byte("hll2")
+		}
+		c.PFAdd(ctx, key, []byte(fmt.Sprintf("element:%d", i)))
+	}
+	if v := db.data["hll1"].str; v[4] != hllDense || len(v) != hllDenseSize {
+		t.Errorf("Got encoding %d len %d expected dense", v[4], len(v))
+	}
+
+	for _, c := range []struct {
+		keys     []string
+		expected float64
+	}{
+		{[]string{"hll1"}, 50000},
+		{[]string{"hll1", "hll2"}, 100000},
+		{[]string{"hll1", "hll1", "none"}, 50000},
+	} {
+		keys := [][]byte{}
+		for _, k := range c.keys {
+			keys = append(keys, []byte(k))
+		}
+		n, err := NewHyperLogLogCmd(db.DBString()).PFCount(ctx, keys...)
+		if err != nil || math.Abs(float64(n)-c.expected)/c.expected > 0.02 {
+			t.Errorf("%v Got %d, %v expected about %v", c.keys, n, err, c.expected)
+		}
+	}
+
+	if err := c.PFMerge(ctx, []byte("merged"), []byte("hll1"), []byte("hll2")); err != nil {
+		t.Fatal(err)
+	}
+	union, _ := c.PFCount(ctx, []byte("hll1"), []byte("hll2"))
+	if n, _ := c.PFCount(ctx, []byte("merged")); n != union {
+		t.Errorf("Got %d expected %d", n, union)
+	}
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	h := newHLL()
+	for i := 0; i < 300; i++ {
+		h.add([]byte(fmt.Sprint(i)))
+	}
+	sparse := h.encode()
+	if sparse[4] != hllSparse {
+		t.Fatalf("Got encoding %d expected sparse", sparse[4])
+	}
+	h.sparse = false
+	dense := h.encode()
+
+	for _, v := range [][]byte{sparse, dense} {
+		d, err := decodeHLL(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d.regs, h.regs) {
+			t.Errorf("encoding %d registers mismatch", v[4])
+		}
+		if d.count() != h.count() {
+			t.Errorf("Got %d expected %d", d.count(), h.count())
+		}
+	}
+}
@@ -3,6 +3,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -18,6 +19,10 @@ const (
 	CmdTypeList    = "list"
 	CmdTypeSet     = "set"
 	CmdTypeZset    = "zset"
+	CmdTypeGeo     = "geo"
+	CmdTypeHLL     = "hyperloglog"
+	CmdTypePubSub  = "pubsub"
+	CmdTypeStream  = "stream"
 	CmdTypeSlot    = "slot"
 )
 
@@ -144,6 +149,8 @@ type IHashCmd interface {
 	HGetAll(ctx context.Context, key []byte) ([]FVPair, error)
 	HKeys(ctx context.Context, key []byte) ([][]byte, error)
 	HValues(ctx context.Context, key []byte) ([][]byte, error)
+	// HScan scan fields of key after cursor, see ScanArgs
+	HScan(ctx context.Context, key []byte, cursor []byte, args ScanArgs) (next []byte, fvs []FVPair, err error)
 
 	ICommonCmd
 }
@@ -161,10 +168,15 @@ type ISetCmd interface {
 	SRem(ctx context.Context, key []byte, args ...[]byte) (int64, error)
 	SUnion(ctx context.Context, keys ...[]byte) ([][]byte, error)
 	SUnionStore(ctx context.Context, dstKey []byte, keys ...[]byte) (int64, error)
+	// SScan scan members of key after cursor, see ScanArgs
+	SScan(ctx context.Context, key []byte, cursor []byte, args ScanArgs) (next []byte, members [][]byte, err error)
 
 	ICommonCmd
 }
 
+// ErrScoreMiss ZScore return it if key or member not exists
+var ErrScoreMiss = errors.New("zset score miss")
+
 type ScorePair struct {
 	Score  int64
 	Member []byte
@@ -189,6 +201,7 @@ const (
 type IZsetCmd interface {
 	ZAdd(ctx context.Context, key []byte, args ...ScorePair) (int64, error)
 	ZCard(ctx context.Context, key []byte) (int64, error)
+	// ZScore return ErrScoreMiss if key or member not exists
 	ZScore(ctx context.Context, key []byte, member []byte) (int64, error)
 	ZRem(ctx context.Context, key []byte, members ...[]byte) (int64, error)
 	ZIncrBy(ctx context.Context, key []byte, delta int64, member []byte) (int64, error)
@@ -206,6 +219,85 @@ type IZsetCmd interface {
 	ZRangeByLex(ctx context.Context, key []byte, min []byte, max []byte, rangeType RangeType, offset int, count int) ([][]byte, error)
 	ZRemRangeByLex(ctx context.Context, key []byte, min []byte, max []byte, rangeType RangeType) (int64, error)
 	ZLexCount(ctx context.Context, key []byte, min []byte, max []byte, rangeType RangeType) (int64, error)
+	// ZScan scan members of key after cursor in member order, see ScanArgs
+	ZScan(ctx context.Context, key []byte, cursor []byte, args ScanArgs) (next []byte, pairs []ScorePair, err error)
+
+	ICommonCmd
+}
+
+const (
+	GeoUnitM  = "m"
+	GeoUnitKM = "km"
+	GeoUnitMI = "mi"
+	GeoUnitFT = "ft"
+
+	GeoSortAsc  = "ASC"
+	GeoSortDesc = "DESC"
+)
+
+// GeoLocation member location, Hash is the 52-bit geohash score in zset,
+// Dist is the distance from search center in query unit
+type GeoLocation struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+	Dist      float64
+	Hash      int64
+}
+
+// GeoAddArgs GEOADD key [NX | XX] [CH]
+type GeoAddArgs struct {
+	NX, XX, CH bool
+}
+
+// GeoSearchQuery GEOSEARCH key FROMMEMBER member | FROMLONLAT lon lat
+// BYRADIUS radius unit | BYBOX width height unit [ASC | DESC] [COUNT count [ANY]]
+type GeoSearchQuery struct {
+	// FromMember search from the location of member, from Longitude, Latitude if nil
+	FromMember []byte
+	Longitude  float64
+	Latitude   float64
+	// Radius search by radius if > 0, else by box Width x Height
+	Radius float64
+	Width  float64
+	Height float64
+	// Unit GeoUnit*, empty is m
+	Unit string
+	// Sort GeoSortAsc or GeoSortDesc, empty is unsorted (ASC if Count > 0 and not Any)
+	Sort string
+	// Count limit results if > 0, Any return the first Count matches instead of the nearest ones
+	Count int
+	Any   bool
+	// WithDist, WithCoord, WithHash fill the fields of result, for GEOSEARCH reply
+	WithDist  bool
+	WithCoord bool
+	WithHash  bool
+}
+
+// adapt https://redis.io/commands/?group=geo
+type IGeoCmd interface {
+	GeoAdd(ctx context.Context, key []byte, args GeoAddArgs, locations ...GeoLocation) (int64, error)
+	// GeoPos return nil location if member not exists
+	GeoPos(ctx context.Context, key []byte, members ...[]byte) ([]*GeoLocation, error)
+	// GeoDist return ErrScoreMiss if member not exists
+	GeoDist(ctx context.Context, key []byte, member1, member2 []byte, unit string) (float64, error)
+	// GeoHash return 11 characters geohash string, nil if member not exists
+	GeoHash(ctx context.Context, key []byte, members ...[]byte) ([][]byte, error)
+	GeoSearch(ctx context.Context, key []byte, query GeoSearchQuery) ([]GeoLocation, error)
+	// GeoSearchStore store results to destKey with geohash scores like redis,
+	// if storeDist, scores are distances in query unit * GeoStoreDistScale, decode them by GeoStoreDist,
+	// it deviates from redis STOREDIST (float score) deliberately, as zset scores are int64.
+	GeoSearchStore(ctx context.Context, destKey []byte, srcKey []byte, query GeoSearchQuery, storeDist bool) (int64, error)
+
+	ICommonCmd
+}
+
+// adapt https://redis.io/commands/?group=hyperloglog
+type IHyperLogLogCmd interface {
+	// PFAdd return 1 if key is created or a register is updated
+	PFAdd(ctx context.Context, key []byte, elements ...[]byte) (int64, error)
+	PFCount(ctx context.Context, keys ...[]byte) (int64, error)
+	PFMerge(ctx context.Context, destKey []byte, srcKeys ...[]byte) error
 
 	ICommonCmd
 }
@@ -221,6 +313,181 @@ type IBitmapCmd interface {
 	//ICommonCmd
 }
 
+// StreamEntry stream entry, ID is <ms>-<seq>
+type StreamEntry struct {
+	ID     []byte
+	Fields []FVPair
+}
+
+// StreamEntries the entries of stream key, for XREAD/XREADGROUP
+type StreamEntries struct {
+	Key     []byte
+	Entries []StreamEntry
+}
+
+const (
+	XTrimStrategyMaxLen = "MAXLEN"
+	XTrimStrategyMinID  = "MINID"
+)
+
+// XTrimArgs XADD/XTRIM trim args: MAXLEN|MINID [=|~] threshold [LIMIT count]
+type XTrimArgs struct {
+	// Strategy XTrimStrategyMaxLen or XTrimStrategyMinID, empty is no trim
+	Strategy string
+	// Approx ~, trim lazily, at least Threshold entries are kept
+	Approx bool
+	// Threshold max length for MAXLEN, min id for MINID
+	Threshold []byte
+	// Limit max evicted entries with Approx, 0 is default
+	Limit int64
+}
+
+// XAddArgs XADD args: [NOMKSTREAM] [trim] *|id
+type XAddArgs struct {
+	NoMkStream bool
+	// ID "*" is auto generated, or explicit id <ms>-<seq>|<ms>-*
+	ID   []byte
+	Trim XTrimArgs
+}
+
+// XReadArgs XREAD args: [COUNT count] [BLOCK ms] STREAMS key ... id ...
+type XReadArgs struct {
+	Keys [][]byte
+	// IDs read entries after id of keys, "$" is the last id of stream,
+	// ">" is the entries never delivered to other consumers for XREADGROUP
+	IDs   [][]byte
+	Count int64
+	// Block wait for entries until BlockTimeout, 0 timeout is forever
+	Block        bool
+	BlockTimeout time.Duration
+}
+
+// XReadGroupArgs XREADGROUP args: GROUP group consumer [NOACK] + XREAD args
+type XReadGroupArgs struct {
+	Group    []byte
+	Consumer []byte
+	NoAck    bool
+	XReadArgs
+}
+
+// XPendingConsumer pending count of consumer
+type XPendingConsumer struct {
+	Name  []byte
+	Count int64
+}
+
+// XPendingSummary XPENDING key group summary
+type XPendingSummary struct {
+	Count         int64
+	Lower, Higher []byte
+	Consumers     []XPendingConsumer
+}
+
+// XPendingArgs XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+type XPendingArgs struct {
+	Idle       time.Duration
+	Start, End []byte
+	Count      int64
+	Consumer   []byte
+}
+
+// XPendingEntry pending entry detail
+type XPendingEntry struct {
+	ID            []byte
+	Consumer      []byte
+	Idle          time.Duration
+	DeliveryCount int64
+}
+
+// XClaimArgs XCLAIM options: [IDLE ms] [TIME unix-time-ms] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id]
+type XClaimArgs struct {
+	Idle       time.Duration
+	Time       int64
+	RetryCount int64
+	Force      bool
+	JustID     bool
+	LastID     []byte
+}
+
+// XInfoStreamReply XINFO STREAM key
+type XInfoStreamReply struct {
+	Length               int64
+	Groups               int64
+	LastGeneratedID      []byte
+	MaxDeletedEntryID    []byte
+	EntriesAdded         int64
+	RecordedFirstEntryID []byte
+	FirstEntry           *StreamEntry
+	LastEntry            *StreamEntry
+}
+
+// XInfoGroupReply XINFO GROUPS key
+type XInfoGroupReply struct {
+	Name            []byte
+	Consumers       int64
+	Pending         int64
+	LastDeliveredID []byte
+	EntriesRead     int64
+	// Lag -1 is unknown
+	Lag int64
+}
+
+// XInfoConsumerReply XINFO CONSUMERS key group
+type XInfoConsumerReply struct {
+	Name     []byte
+	Pending  int64
+	Idle     time.Duration
+	Inactive time.Duration
+}
+
+// adapt https://redis.io/commands/?group=stream
+type IStreamCmd interface {
+	XAdd(ctx context.Context, key []byte, args XAddArgs, fields ...FVPair) (id []byte, err error)
+	// XRange entries in [start, end], "-" and "+" are the min and max id, "(" prefix is exclusive, count <= 0 is all
+	XRange(ctx context.Context, key []byte, start, end []byte, count int64) ([]StreamEntry, error)
+	XRevRange(ctx context.Context, key []byte, end, start []byte, count int64) ([]StreamEntry, error)
+	XLen(ctx context.Context, key []byte) (int64, error)
+	XDel(ctx context.Context, key []byte, ids ...[]byte) (int64, error)
+	XTrim(ctx context.Context, key []byte, args XTrimArgs) (int64, error)
+	// XRead return nil if block timeout
+	XRead(ctx context.Context, args XReadArgs) ([]StreamEntries, error)
+
+	// XGroupCreate XGROUP CREATE key group id|$ [MKSTREAM]
+	XGroupCreate(ctx context.Context, key []byte, group []byte, id []byte, mkStream bool) error
+	XGroupSetID(ctx context.Context, key []byte, group []byte, id []byte) error
+	XGroupDestroy(ctx context.Context, key []byte, group []byte) (int64, error)
+	XGroupCreateConsumer(ctx context.Context, key []byte, group []byte, consumer []byte) (int64, error)
+	// XGroupDelConsumer return the pending count of the deleted consumer
+	XGroupDelConsumer(ctx context.Context, key []byte, group []byte, consumer []byte) (int64, error)
+	XReadGroup(ctx context.Context, args XReadGroupArgs) ([]StreamEntries, error)
+	XAck(ctx context.Context, key []byte, group []byte, ids ...[]byte) (int64, error)
+	XPending(ctx context.Context, key []byte, group []byte) (*XPendingSummary, error)
+	XPendingExt(ctx context.Context, key []byte, group []byte, args XPendingArgs) ([]XPendingEntry, error)
+	// XClaim claim pending entries idle >= minIdle to consumer, entries has only ID if args.JustID
+	XClaim(ctx context.Context, key []byte, group []byte, consumer []byte, minIdle time.Duration, ids [][]byte, args XClaimArgs) ([]StreamEntry, error)
+	// XAutoClaim scan pending entries from start and claim the idle ones,
+	// return the next start id, the claimed entries and the ids which are deleted from stream
+	XAutoClaim(ctx context.Context, key []byte, group []byte, consumer []byte, minIdle time.Duration, start []byte, count int64, justID bool) (next []byte, entries []StreamEntry, deleted [][]byte, err error)
+
+	XInfoStream(ctx context.Context, key []byte) (*XInfoStreamReply, error)
+	XInfoGroups(ctx context.Context, key []byte) ([]XInfoGroupReply, error)
+	XInfoConsumers(ctx context.Context, key []byte, group []byte) ([]XInfoConsumerReply, error)
+
+	ICommonCmd
+}
+
+// ScanArgs SCAN/HSCAN/SSCAN/ZSCAN cursor [MATCH pattern] [COUNT count] [TYPE type],
+// the cursor is the last examined element (exclusive), nil is from start,
+// the next cursor is nil if scan is done, so it is stable across concurrent writes.
+type ScanArgs struct {
+	// Match glob-style pattern, empty matches all, see ScanMatcher
+	Match string
+	// Count elements examined in one call, <= 0 is DefaultScanCount
+	Count int
+	// Type filter keys by CmdType*, empty is all, only for ScanDB
+	Type string
+}
+
 // adapt https://redis.io/commands/?group=generic
 // some common key op cmd
 type ICommonCmd interface {
@@ -231,6 +498,8 @@ type ICommonCmd interface {
 	ExpireAt(ctx context.Context, key []byte, when int64) (int64, error)
 	TTL(ctx context.Context, key []byte) (int64, error)
 	Persist(ctx context.Context, key []byte) (int64, error)
+	// Scan scan keys of the data type after cursor, see ScanArgs
+	Scan(ctx context.Context, cursor []byte, args ScanArgs) (next []byte, keys [][]byte, err error)
 }
 
 type IDB interface {
@@ -242,6 +511,9 @@ type IDB interface {
 	DBSet() ISetCmd
 	DBZSet() IZsetCmd
 	DBBitmap() IBitmapCmd
+	DBStream() IStreamCmd
+	DBGeo() IGeoCmd
+	DBHyperLogLog() IHyperLogLogCmd
 }
 
 type IDBSlots interface {
@@ -18,6 +18,13 @@ type IRespConn interface {
 	Close() error
 }
 
+// IRespPubSubConn resp conn which supports pub/sub,
+// the server pushes the messages of client outbound queue to conn
+type IRespPubSubConn interface {
+	IRespConn
+	PubSubClient() *PubSubClient
+}
+
 type CmdHandle func(ctx context.Context, c IRespConn, cmdParams [][]byte) (interface{}, error)
 
 var RegisteredCmdHandles = map[string]CmdHandle{}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 
+	openkvdriver "github.com/weedge/pkg/driver/openkv"
 	"github.com/weedge/pkg/utils"
 )
 
@@ -49,3 +50,165 @@ func RegisterDumpHandler(name DumpSrvInfoName, handler DumpHandler) {
 	}
 	RegisteredDumpHandlers[name] = handler
 }
+
+const DumpSrvInfoNameStorage DumpSrvInfoName = "storage"
+
+// StorageMetricsInfoPairs openkv metrics stats to info pairs, like:
+//
+//	storage_get:calls=10,errors=0,bytes=200,usec=30,usec_per_call=3.00
+//	storage_latency_get:p50=10,p99=50,p99.9=100
+func StorageMetricsInfoPairs(rec *openkvdriver.StatsRecorder) []InfoPair {
+	pairs := []InfoPair{}
+	stats := rec.Stats()
+	for i := range stats {
+		s := &stats[i]
+		pairs = append(pairs, InfoPair{
+			Key: "storage_" + s.Op.String(),
+			Value: fmt.Sprintf("calls=%d,errors=%d,bytes=%d,usec=%d,usec_per_call=%.2f",
+				s.Calls, s.Errors, s.Bytes, s.Usec, s.UsecPerCall()),
+		})
+	}
+	for i := range stats {
+		s := &stats[i]
+		ps := []string{}
+		for _, p := range []float64{50, 99, 99.9} {
+			us := "+inf"
+			if v := s.Percentile(p); v >= 0 {
+				us = fmt.Sprintf("%d", v)
+			}
+			ps = append(ps, fmt.Sprintf("p%v=%s", p, us))
+		}
+		pairs = append(pairs, InfoPair{Key: "storage_latency_" + s.Op.String(), Value: strings.Join(ps, ",")})
+	}
+
+	return pairs
+}
+
+// RegisterStorageDumpHandler register openkv metrics to INFO # Storage section,
+// the recorder is used by openkvdriver.WithMetrics
+func RegisterStorageDumpHandler(rec *openkvdriver.StatsRecorder) {
+	RegisterDumpHandler(DumpSrvInfoNameStorage, func(w io.Writer) {
+		for _, pair := range StorageMetricsInfoPairs(rec) {
+			w.Write(pair.RespDumpInfo())
+		}
+	})
+}
+
+const DumpSrvInfoNameCompaction DumpSrvInfoName = "compaction"
+
+// CompactionInfoPairs openkv compaction scheduler progress to info pairs
+func CompactionInfoPairs(s *openkvdriver.CompactionScheduler) []InfoPair {
+	stats := s.Stats()
+	running, progress := 0, 0.0
+	if stats.Running {
+		running = 1
+	}
+	if stats.CurrentTotalBytes > 0 {
+		progress = float64(stats.CurrentDoneBytes) * 100 / float64(stats.CurrentTotalBytes)
+	}
+	lastErr := ""
+	if stats.LastError != nil {
+		lastErr = stats.LastError.Error()
+	}
+
+	return []InfoPair{
+		{Key: "compaction_running", Value: running},
+		{Key: "compaction_pending", Value: stats.Pending},
+		{Key: "compaction_runs", Value: stats.Runs},
+		{Key: "compaction_density_triggers", Value: stats.DensityTriggers},
+		{Key: "compaction_triggers", Value: stats.Triggers},
+		{Key: "compaction_compacted_bytes", Value: stats.CompactedBytes},
+		{Key: "compaction_current_bytes", Value: fmt.Sprintf("%d/%d", stats.CurrentDoneBytes, stats.CurrentTotalBytes)},
+		{Key: "compaction_current_progress", Value: fmt.Sprintf("%.2f%%", progress)},
+		{Key: "compaction_last_duration_ms", Value: stats.LastDuration.Milliseconds()},
+		{Key: "compaction_last_error", Value: lastErr},
+	}
+}
+
+// RegisterCompactionDumpHandler register openkv compaction progress to INFO # Compaction section
+func RegisterCompactionDumpHandler(s *openkvdriver.CompactionScheduler) {
+	RegisterDumpHandler(DumpSrvInfoNameCompaction, func(w io.Writer) {
+		for _, pair := range CompactionInfoPairs(s) {
+			w.Write(pair.RespDumpInfo())
+		}
+	})
+}
+
+const DumpSrvInfoNameIO DumpSrvInfoName = "io"
+
+// IOSchedulerInfoPairs openkv io scheduler stats of classes to info pairs
+func IOSchedulerInfoPairs(s *openkvdriver.IOScheduler) []InfoPair {
+	pairs := []InfoPair{}
+	for _, st := range s.Stats() {
+		pairs = append(pairs, InfoPair{
+			Key: "io_" + st.Priority.String(),
+			Value: fmt.Sprintf("ops=%d,bytes=%d,throttled=%d,wait_ms=%d,limit_bytes_per_sec=%d,limit_ops_per_sec=%d",
+				st.Ops, st.Bytes, st.Throttled, st.Wait.Milliseconds(), st.Limit.BytesPerSec, st.Limit.OpsPerSec),
+		})
+	}
+
+	return pairs
+}
+
+// RegisterIOSchedulerDumpHandler register openkv io scheduler stats to INFO # Io section
+func RegisterIOSchedulerDumpHandler(s *openkvdriver.IOScheduler) {
+	RegisterDumpHandler(DumpSrvInfoNameIO, func(w io.Writer) {
+		for _, pair := range IOSchedulerInfoPairs(s) {
+			w.Write(pair.RespDumpInfo())
+		}
+	})
+}
+
+const DumpSrvInfoNameTTL DumpSrvInfoName = "ttl"
+
+// TTLInfoPairs openkv ttl subsystem stats to info pairs
+func TTLInfoPairs(t *openkvdriver.TTL) []InfoPair {
+	stats := t.Stats()
+	lastErr := ""
+	if stats.LastError != nil {
+		lastErr = stats.LastError.Error()
+	}
+
+	return []InfoPair{
+		{Key: "expired_keys", Value: stats.ExpiredKeys + stats.LazyExpiredKeys},
+		{Key: "expired_keys_active", Value: stats.ExpiredKeys},
+		{Key: "expired_keys_lazy", Value: stats.LazyExpiredKeys},
+		{Key: "expire_cycles", Value: stats.Cycles},
+		{Key: "expire_cycle_budget_hits", Value: stats.BudgetHits},
+		{Key: "expire_last_cycle_us", Value: stats.LastCycleDuration.Microseconds()},
+		{Key: "expire_last_error", Value: lastErr},
+	}
+}
+
+// RegisterTTLDumpHandler register openkv ttl stats to INFO # Ttl section
+func RegisterTTLDumpHandler(t *openkvdriver.TTL) {
+	RegisterDumpHandler(DumpSrvInfoNameTTL, func(w io.Writer) {
+		for _, pair := range TTLInfoPairs(t) {
+			w.Write(pair.RespDumpInfo())
+		}
+	})
+}
+
+const DumpSrvInfoNamePubSub DumpSrvInfoName = "pubsub"
+
+// PubSubInfoPairs pub/sub hub stats to info pairs
+func PubSubInfoPairs(h *PubSub) []InfoPair {
+	stats := h.Stats()
+	return []InfoPair{
+		{Key: "pubsub_channels", Value: stats.Channels},
+		{Key: "pubsub_patterns", Value: stats.Patterns},
+		{Key: "pubsubshard_channels", Value: stats.ShardChannels},
+		{Key: "pubsub_clients", Value: stats.Clients},
+		{Key: "pubsub_published_messages", Value: stats.Published},
+		{Key: "pubsub_output_buffer_disconnected_clients", Value: stats.Disconnected},
+	}
+}
+
+// RegisterPubSubDumpHandler register pub/sub hub stats to INFO # Pubsub section
+func RegisterPubSubDumpHandler(h *PubSub) {
+	RegisterDumpHandler(DumpSrvInfoNamePubSub, func(w io.Writer) {
+		for _, pair := range PubSubInfoPairs(h) {
+			w.Write(pair.RespDumpInfo())
+		}
+	})
+}
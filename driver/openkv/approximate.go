@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Range key range [Start, Limit), nil or empty Start/Limit is unbounded
+type Range struct {
+	Start, Limit []byte
+}
+
+// property names, engine may support part of them and its own ones
+const (
+	// PropertyNumLevels number of levels
+	PropertyNumLevels = "openkv.num-levels"
+	// PropertyMemtableUsage bytes of all memtables
+	PropertyMemtableUsage = "openkv.cur-size-all-mem-tables"
+	// PropertyPendingCompactionBytes estimated bytes which compaction would rewrite or drop
+	PropertyPendingCompactionBytes = "openkv.estimate-pending-compaction-bytes"
+	// PropertyEstimateNumKeys estimated number of live keys
+	PropertyEstimateNumKeys = "openkv.estimate-num-keys"
+	// PropertyEstimateLiveDataSize estimated bytes of live keys and values
+	PropertyEstimateLiveDataSize = "openkv.estimate-live-data-size"
+	// PropertyNumDeletes number of tombstones and stale versions which are not compacted
+	PropertyNumDeletes = "openkv.num-deletes"
+)
+
+var ErrUnknownProperty = errors.New("unknown property")
+
+// IApproximator interface for engine which can estimate data volume of ranges cheaply
+type IApproximator interface {
+	// ApproximateSizes estimated bytes of keys and values in each range
+	ApproximateSizes(ranges []Range) ([]uint64, error)
+	// ApproximateCount estimated number of keys in range
+	ApproximateCount(r Range) (uint64, error)
+}
+
+// ITotalApproximator interface for engine which can estimate data volume of ranges
+// including tombstones and stale versions, which compaction rewrites or drops
+type ITotalApproximator interface {
+	// ApproximateTotalSizes estimated bytes of all versions of keys and values in each range
+	ApproximateTotalSizes(ranges []Range) ([]uint64, error)
+}
+
+// IPropertyGeter interface for engine which can get property value by name
+type IPropertyGeter interface {
+	Property(name string) (string, error)
+}
+
+// ApproximateSizes estimated bytes of keys and values in each range,
+// use engine native estimation if db is IApproximator,
+// else iterate ranges to sum exact bytes (full scan of the ranges).
+func ApproximateSizes(db IDB, ranges ...Range) ([]uint64, error) {
+	if a, ok := db.(IApproximator); ok {
+		return a.ApproximateSizes(ranges)
+	}
+
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		if err := scanRange(db, r, func(key, value []byte) {
+			sizes[i] += uint64(len(key) + len(value))
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return sizes, nil
+}
+
+// ApproximateTotalSizes estimated bytes of all versions of keys and values in each range,
+// include tombstones and stale versions, use engine native estimation if db is ITotalApproximator,
+// else fallback to ApproximateSizes of live data.
+func ApproximateTotalSizes(db IDB, ranges ...Range) ([]uint64, error) {
+	if a, ok := db.(ITotalApproximator); ok {
+		return a.ApproximateTotalSizes(ranges)
+	}
+
+	return ApproximateSizes(db, ranges...)
+}
+
+// ApproximateCount estimated number of keys in range,
+// use engine native estimation if db is IApproximator,
+// else iterate the range to count exactly.
+func ApproximateCount(db IDB, r Range) (uint64, error) {
+	if a, ok := db.(IApproximator); ok {
+		return a.ApproximateCount(r)
+	}
+
+	var n uint64
+	err := scanRange(db, r, func(key, value []byte) { n++ })
+	return n, err
+}
+
+func scanRange(db IDB, r Range, fn func(key, value []byte)) error {
+	it := NewIteratorWithOptions(db, &IteratorOptions{LowerBound: r.Start, UpperBound: r.Limit, DontFillCache: true})
+	defer it.Close()
+
+	for it.First(); it.Valid(); it.Next() {
+		fn(it.Key(), it.Value())
+	}
+
+	return it.Error()
+}
+
+// Property get property value of db by name,
+// use engine native property if db is IPropertyGeter,
+// PropertyEstimateNumKeys and PropertyEstimateLiveDataSize fallback to
+// ApproximateCount and ApproximateSizes of the whole db,
+// return ErrUnknownProperty if property is not supported.
+func Property(db IDB, name string) (string, error) {
+	if p, ok := db.(IPropertyGeter); ok {
+		v, err := p.Property(name)
+		if !errors.Is(err, ErrUnknownProperty) {
+			return v, err
+		}
+	}
+
+	switch name {
+	case PropertyEstimateNumKeys:
+		n, err := ApproximateCount(db, Range{})
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(n, 10), nil
+	case PropertyEstimateLiveDataSize:
+		sizes, err := ApproximateSizes(db, Range{})
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(sizes[0], 10), nil
+	}
+
+	return "", ErrUnknownProperty
+}
+
+// PropertyUint get uint property value of db by name
+func PropertyUint(db IDB, name string) (uint64, error) {
+	v, err := Property(db, name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(v, 10, 64)
+}
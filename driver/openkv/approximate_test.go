@@ -0,0 +1,68 @@
+package driver_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+func TestApproximate(t *testing.T) {
+	root, err := memkv.NewStore().Open("approximate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	for i := 0; i < 100; i++ {
+		root.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("vv"))
+	}
+	ns := driver.Namespace(root, "ns")
+	for i := 0; i < 10; i++ {
+		ns.Put([]byte(fmt.Sprintf("n%d", i)), []byte("v"))
+	}
+
+	ranges := []driver.Range{{Start: []byte("k000"), Limit: []byte("k010")}, {Start: []byte("k090")}}
+	for _, db := range []driver.IDB{root, &plainDB{root}} {
+		sizes, err := driver.ApproximateSizes(db, ranges...)
+		if err != nil || fmt.Sprint(sizes) != "[60 60]" {
+			t.Errorf("%T sizes Got %v %v expected [60 60]", db, sizes, err)
+		}
+		n, err := driver.ApproximateCount(db, driver.Range{Start: []byte("k050"), Limit: []byte("k060")})
+		if err != nil || n != 10 {
+			t.Errorf("%T count Got %d %v expected %d", db, n, err, 10)
+		}
+		if n, err := driver.PropertyUint(db, driver.PropertyEstimateNumKeys); err != nil || n != 110 {
+			t.Errorf("%T num keys Got %d %v expected %d", db, n, err, 110)
+		}
+	}
+
+	if _, err := driver.Property(&plainDB{root}, driver.PropertyNumLevels); !errors.Is(err, driver.ErrUnknownProperty) {
+		t.Errorf("Got %v expected %v", err, driver.ErrUnknownProperty)
+	}
+	if v, err := driver.Property(root, driver.PropertyNumLevels); err != nil || v != "1" {
+		t.Errorf("Got %s %v expected %s", v, err, "1")
+	}
+
+	// total sizes include tombstones and stale versions, fallback to live sizes
+	root.Delete([]byte("k000"))
+	if sizes, err := driver.ApproximateTotalSizes(root, ranges[0]); err != nil || sizes[0] != 64 {
+		t.Errorf("total sizes Got %v %v expected %d", sizes, err, 64)
+	}
+	if sizes, err := driver.ApproximateTotalSizes(&plainDB{root}, ranges[0]); err != nil || sizes[0] != 54 {
+		t.Errorf("plain total sizes Got %v %v expected %d", sizes, err, 54)
+	}
+
+	// namespace scoped
+	if n, err := driver.PropertyUint(ns, driver.PropertyEstimateNumKeys); err != nil || n != 10 {
+		t.Errorf("ns num keys Got %d %v expected %d", n, err, 10)
+	}
+	if n, _ := driver.ApproximateCount(ns, driver.Range{Limit: []byte("n5")}); n != 5 {
+		t.Errorf("ns count Got %d expected %d", n, 5)
+	}
+	if v, err := driver.Property(ns, driver.PropertyNumLevels); err != nil || v != "1" {
+		t.Errorf("ns Got %s %v expected %s", v, err, "1")
+	}
+}
@@ -0,0 +1,306 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// portable backup archive format, any registered engine can restore it:
+//
+//	| magic "OPENKVBK" | version |
+//	| recordFlagKV | uvarint len(key) | key | uvarint len(value) | value |.....|
+//	| recordFlagEnd | count (uint64 LE) | crc32c of all bytes before (uint32 LE) |
+const (
+	BackupMagic   = "OPENKVBK"
+	BackupVersion = 1
+
+	recordFlagEnd byte = 0
+	recordFlagKV  byte = 1
+)
+
+var (
+	ErrBackupCorrupted       = errors.New("openkv backup archive corrupted")
+	ErrBackupVersion         = errors.New("openkv backup archive version unsupported")
+	ErrCheckpointUnsupported = errors.New("native checkpoint is unsupported")
+)
+
+// max key/value length in archive (512MB like redis proto-max-bulk-len),
+// avoid alloc huge buffer if corrupted
+const maxArchiveBytesLen = 512 << 20
+
+// RestoreBatchSize generic restore commit once per batch size keys
+var RestoreBatchSize = 1024
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checkpoint create an openable checkpoint of db in dir online,
+// use engine native checkpoint if db is ICheckpointer,
+// else (or it returns ErrCheckpointUnsupported, eg: wrapper of engine without it)
+// write all keys of a db snapshot to a new db which is opened by store in dir.
+func Checkpoint(db IDB, store IStore, dir string) error {
+	if c, ok := db.(ICheckpointer); ok {
+		if err := c.Checkpoint(dir); !errors.Is(err, ErrCheckpointUnsupported) {
+			return err
+		}
+	}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	dst, err := store.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	it := snap.NewIterator()
+	defer it.Close()
+
+	wb := dst.NewWriteBatch()
+	defer wb.Close()
+
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		wb.Put(it.Key(), it.Value())
+		if n++; n%RestoreBatchSize == 0 {
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+			wb.Rollback()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return wb.SyncCommit()
+}
+
+// Backup stream a consistent backup of db to w online,
+// use engine native backup if db is IBackuper,
+// else stream a db snapshot to portable archive by BackupSnapshot.
+func Backup(db IDB, w io.Writer) error {
+	if b, ok := db.(IBackuper); ok {
+		return b.Backup(w)
+	}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	return BackupSnapshot(snap, w)
+}
+
+// BackupSnapshot stream all keys of snapshot to w in portable archive format
+func BackupSnapshot(snap ISnapshot, w io.Writer) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	bw.WriteString(BackupMagic)
+	bw.WriteByte(BackupVersion)
+
+	it := snap.NewIterator()
+	defer it.Close()
+
+	var count uint64
+	buf := make([]byte, binary.MaxVarintLen64)
+	for it.First(); it.Valid(); it.Next() {
+		bw.WriteByte(recordFlagKV)
+		writeUvarintBytes(bw, buf, it.Key())
+		writeUvarintBytes(bw, buf, it.Value())
+		count++
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	bw.WriteByte(recordFlagEnd)
+	binary.LittleEndian.PutUint64(buf, count)
+	bw.Write(buf[:8])
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(buf, crc.Sum32())
+	_, err := w.Write(buf[:4])
+	return err
+}
+
+func writeUvarintBytes(bw *bufio.Writer, buf []byte, b []byte) {
+	n := binary.PutUvarint(buf, uint64(len(b)))
+	bw.Write(buf[:n])
+	bw.Write(b)
+}
+
+// Restore restore backup from r to the db of store in path,
+// use store native restore if store is IRestorer,
+// else restore the portable archive by RestoreToDB.
+func Restore(store IStore, r io.Reader, path string) error {
+	if rs, ok := store.(IRestorer); ok {
+		return rs.Restore(r, path)
+	}
+
+	db, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return RestoreToDB(db, r)
+}
+
+// RestoreToDB restore portable archive from r to db with write batches,
+// the archive is verified before any write, so db is untouched if it is corrupted,
+// r is read twice if it is io.Seeker, else it is spooled to a temp file.
+func RestoreToDB(db IDB, r io.Reader) error {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		f, err := os.CreateTemp("", "openkv-restore-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		r, rs = io.TeeReader(r, f), f
+	}
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := readArchive(r, nil); err != nil {
+		return err
+	}
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+
+	var count uint64
+	if err := readArchive(rs, func(key, value []byte) error {
+		wb.Put(key, value)
+		if count++; count%uint64(RestoreBatchSize) == 0 {
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+			wb.Rollback()
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return wb.SyncCommit()
+}
+
+// readArchive read and verify portable archive from r, call fn with each key value if fn is not nil
+func readArchive(r io.Reader, fn func(key, value []byte) error) error {
+	ar := &archiveReader{r: bufio.NewReader(r), crc: crc32.New(crcTable)}
+
+	head := make([]byte, len(BackupMagic)+1)
+	if err := ar.readFull(head); err != nil {
+		return fmt.Errorf("%w: read header %s", ErrBackupCorrupted, err)
+	}
+	if !bytes.Equal(head[:len(BackupMagic)], []byte(BackupMagic)) {
+		return fmt.Errorf("%w: bad magic", ErrBackupCorrupted)
+	}
+	if head[len(BackupMagic)] != BackupVersion {
+		return ErrBackupVersion
+	}
+
+	var count uint64
+	for {
+		flag, err := ar.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: read record %s", ErrBackupCorrupted, err)
+		}
+		if flag == recordFlagEnd {
+			break
+		}
+		if flag != recordFlagKV {
+			return fmt.Errorf("%w: bad record flag %d", ErrBackupCorrupted, flag)
+		}
+
+		key, err := ar.readUvarintBytes()
+		if err != nil {
+			return err
+		}
+		value, err := ar.readUvarintBytes()
+		if err != nil {
+			return err
+		}
+		count++
+		if fn != nil {
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	buf := make([]byte, 8)
+	if err := ar.readFull(buf); err != nil {
+		return fmt.Errorf("%w: read count %s", ErrBackupCorrupted, err)
+	}
+	if binary.LittleEndian.Uint64(buf) != count {
+		return fmt.Errorf("%w: count mismatch", ErrBackupCorrupted)
+	}
+
+	// crc is not in the checksum
+	sum := ar.crc.Sum32()
+	if _, err := io.ReadFull(ar.r, buf[:4]); err != nil {
+		return fmt.Errorf("%w: read crc %s", ErrBackupCorrupted, err)
+	}
+	if binary.LittleEndian.Uint32(buf) != sum {
+		return fmt.Errorf("%w: crc mismatch", ErrBackupCorrupted)
+	}
+	return nil
+}
+
+// archiveReader checksum the read bytes
+type archiveReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (ar *archiveReader) ReadByte() (byte, error) {
+	b, err := ar.r.ReadByte()
+	if err == nil {
+		ar.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (ar *archiveReader) readFull(b []byte) error {
+	n, err := io.ReadFull(ar.r, b)
+	ar.crc.Write(b[:n])
+	return err
+}
+
+func (ar *archiveReader) readUvarintBytes() ([]byte, error) {
+	l, err := binary.ReadUvarint(ar)
+	if err != nil {
+		return nil, fmt.Errorf("%w: read length %s", ErrBackupCorrupted, err)
+	}
+	if l > maxArchiveBytesLen {
+		return nil, fmt.Errorf("%w: bad length %d", ErrBackupCorrupted, l)
+	}
+	b := make([]byte, l)
+	if err := ar.readFull(b); err != nil {
+		return nil, fmt.Errorf("%w: read bytes %s", ErrBackupCorrupted, err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,136 @@
+package driver_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+func TestBackupCorrupted(t *testing.T) {
+	store := memkv.NewStore()
+	db, err := store.Open("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+
+	buf := &bytes.Buffer{}
+	if err := driver.Backup(db, buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", data[:len(data)-3]},
+		{"bad magic", append([]byte("X"), data[1:]...)},
+		{"flip value", func() []byte {
+			b := append([]byte{}, data...)
+			b[bytes.Index(b, []byte("2"))] = '3'
+			return b
+		}()},
+		{"empty", nil},
+		{"huge length", func() []byte {
+			b := append([]byte{}, data[:len(driver.BackupMagic)+1]...)
+			b = append(b, 1)
+			return binary.AppendUvarint(b, 1<<40)
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := driver.Restore(store, bytes.NewReader(tt.data), "dst-"+tt.name)
+			if !errors.Is(err, driver.ErrBackupCorrupted) {
+				t.Errorf("Got %v expected %v", err, driver.ErrBackupCorrupted)
+			}
+		})
+	}
+}
+
+func TestRestoreVerifyFirst(t *testing.T) {
+	defer func(n int) { driver.RestoreBatchSize = n }(driver.RestoreBatchSize)
+	driver.RestoreBatchSize = 2
+
+	store := memkv.NewStore()
+	db, err := store.Open("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		db.Put([]byte(k), []byte("v"+k))
+	}
+	buf := &bytes.Buffer{}
+	if err := driver.Backup(db, buf); err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the record after the first batch
+	data := buf.Bytes()
+	data[bytes.Index(data, []byte("ve"))+1] = 'x'
+
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"seeker", bytes.NewReader(data)},
+		{"spooled", struct{ io.Reader }{bytes.NewReader(data)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, err := store.Open("dst-" + tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+			if err := driver.RestoreToDB(dst, tt.r); !errors.Is(err, driver.ErrBackupCorrupted) {
+				t.Errorf("Got %v expected %v", err, driver.ErrBackupCorrupted)
+			}
+			if keys := iterKeys(dst.NewIterator()); keys != "[]" {
+				t.Errorf("Got %s expected empty db", keys)
+			}
+		})
+	}
+
+	dst, err := store.Open("dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	data[bytes.Index(data, []byte("vx"))+1] = 'e'
+	if err := driver.RestoreToDB(dst, struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := iterKeys(dst.NewIterator()); keys != "[a b c d e]" {
+		t.Errorf("Got %s expected %s", keys, "[a b c d e]")
+	}
+}
+
+func TestCheckpointFallback(t *testing.T) {
+	store := memkv.NewStore()
+	db, err := store.Open("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+
+	if err := driver.Checkpoint(plainDB{db}, store, "dst"); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := store.Open("dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if v, _ := dst.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+}
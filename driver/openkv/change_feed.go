@@ -0,0 +1,550 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils"
+)
+
+var (
+	ErrChangeFeedClosed    = errors.New("change feed is closed")
+	ErrChangeFeedTruncated = errors.New("change feed is truncated, changes from seq are not retained")
+	ErrSubscriptionClosed  = errors.New("change feed subscription is closed")
+	ErrSubscriberTooSlow   = errors.New("change feed subscriber is too slow, dropped")
+	ErrChangeFeedBatchSize = errors.New("change feed batch is larger than capacity")
+)
+
+// ChangeOp the op of change, same as utils.BatchOpType*
+type ChangeOp byte
+
+const (
+	ChangeOpPut         = ChangeOp(utils.BatchOpTypePut)
+	ChangeOpDelete      = ChangeOp(utils.BatchOpTypeDel)
+	ChangeOpDeleteRange = ChangeOp(utils.BatchOpTypeDelRange)
+	ChangeOpMerge       = ChangeOp(utils.BatchOpTypeMerge)
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeOpPut:
+		return "put"
+	case ChangeOpDelete:
+		return "delete"
+	case ChangeOpDeleteRange:
+		return "delete_range"
+	case ChangeOpMerge:
+		return "merge"
+	}
+	return "unknown"
+}
+
+// Change one committed mutation,
+// DeleteRange is [Key, Value), Merge Value is the operand
+type Change struct {
+	Seq   uint64
+	Op    ChangeOp
+	Key   []byte
+	Value []byte
+	// BatchEnd the last change of a commit, apply changes of a commit atomically until it
+	BatchEnd bool
+}
+
+// ChangeFeedOptions change feed options
+type ChangeFeedOptions struct {
+	// Capacity retained changes in memory for subscribers to resume
+	Capacity int `mapstructure:"capacity"`
+	// StartSeq the seq of first change is StartSeq+1,
+	// use the last seq persisted by subscribers to keep seq monotonic after restart
+	StartSeq uint64 `mapstructure:"startSeq"`
+	// BackpressureTimeout writers wait for backpressure subscribers at most timeout,
+	// then the lagging ones are dropped, 0 is wait forever
+	BackpressureTimeout time.Duration `mapstructure:"backpressureTimeout"`
+}
+
+func DefaultChangeFeedOptions() *ChangeFeedOptions {
+	return &ChangeFeedOptions{Capacity: 65536}
+}
+
+func (o *ChangeFeedOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithChangeFeedCapacity(n int) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*ChangeFeedOptions)
+		if !ok {
+			return
+		}
+		o.Capacity = n
+	})
+}
+
+func WithChangeFeedStartSeq(seq uint64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*ChangeFeedOptions)
+		if !ok {
+			return
+		}
+		o.StartSeq = seq
+	})
+}
+
+func WithChangeFeedBackpressureTimeout(timeout time.Duration) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*ChangeFeedOptions)
+		if !ok {
+			return
+		}
+		o.BackpressureTimeout = timeout
+	})
+}
+
+// WithChangeFeedOptions use opts, eg: unmarshaled from config
+func WithChangeFeedOptions(opts ChangeFeedOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*ChangeFeedOptions)
+		if !ok {
+			return
+		}
+		*o = opts
+	})
+}
+
+// ChangeFeed wrap db to stream every committed mutation in commit order,
+// each mutation gets a monotonically increasing seq when commit, the mutations of a commit are contiguous.
+// writes are serialized to keep the feed order same as the db, all writes must go through it.
+// the recent Capacity changes are retained in memory, subscribers can resume from a retained seq,
+// backpressure subscribers block writers when they lag Capacity changes behind,
+// and the commits of more than Capacity changes are rejected while they are subscribed.
+type ChangeFeed struct {
+	IDB
+	opts *ChangeFeedOptions
+
+	// wmu serialize commits
+	wmu sync.Mutex
+
+	mu sync.Mutex
+	// ring retained changes, ring[head] is the change of firstSeq
+	ring     []Change
+	head     int
+	size     int
+	firstSeq uint64
+	lastSeq  uint64
+	closed   bool
+	// appended closed and renewed when changes appended or feed closed
+	appended chan struct{}
+	// advanced closed and renewed when backpressure subscribers advance or close
+	advanced chan struct{}
+	subs     map[*Subscription]struct{}
+}
+
+func NewChangeFeed(db IDB, opts ...option.Option) *ChangeFeed {
+	o := DefaultChangeFeedOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+	if o.Capacity <= 0 {
+		o.Capacity = DefaultChangeFeedOptions().Capacity
+	}
+
+	return &ChangeFeed{
+		IDB:      db,
+		opts:     o,
+		ring:     make([]Change, o.Capacity),
+		firstSeq: o.StartSeq + 1,
+		lastSeq:  o.StartSeq,
+		appended: make(chan struct{}),
+		advanced: make(chan struct{}),
+		subs:     map[*Subscription]struct{}{},
+	}
+}
+
+// LastSeq the seq of the last committed change
+func (f *ChangeFeed) LastSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastSeq
+}
+
+// FirstSeq the seq of the oldest retained change, > LastSeq if nothing retained
+func (f *ChangeFeed) FirstSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.firstSeq
+}
+
+// Subscribe subscribe changes from seq (inclusive), use LastSeq()+1 for new changes only,
+// return ErrChangeFeedTruncated if seq is not retained.
+// backpressure subscriber blocks writers instead of being truncated when it lags behind.
+func (f *ChangeFeed) Subscribe(seq uint64, backpressure bool) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrChangeFeedClosed
+	}
+	if seq < f.firstSeq || seq > f.lastSeq+1 {
+		return nil, fmt.Errorf("%w: seq %d retained [%d, %d]", ErrChangeFeedTruncated, seq, f.firstSeq, f.lastSeq)
+	}
+
+	s := &Subscription{f: f, seq: seq, backpressure: backpressure}
+	f.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Close close the feed and db, subscribers get ErrChangeFeedClosed after the retained changes
+func (f *ChangeFeed) Close() error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.appended)
+		close(f.advanced)
+	}
+	f.mu.Unlock()
+
+	return f.IDB.Close()
+}
+
+// lagsLocked return true if backpressure subscriber s lags too far to append n changes
+func (f *ChangeFeed) lagsLocked(s *Subscription, n int) bool {
+	return s.backpressure && int(f.lastSeq+1-s.seq)+n > len(f.ring)
+}
+
+// waitRoom wait until n changes can be appended without overwriting
+// the changes which are not read by backpressure subscribers,
+// the lagging ones are dropped after BackpressureTimeout,
+// return ErrChangeFeedBatchSize if n > Capacity and there are backpressure subscribers,
+// the commit would overwrite its own changes before they are read.
+func (f *ChangeFeed) waitRoom(n int) error {
+	var timeout <-chan time.Time
+	if f.opts.BackpressureTimeout > 0 {
+		timer := time.NewTimer(f.opts.BackpressureTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if f.closed {
+			return ErrChangeFeedClosed
+		}
+		lag := false
+		for s := range f.subs {
+			if s.backpressure && n > len(f.ring) {
+				return fmt.Errorf("%w: %d > %d", ErrChangeFeedBatchSize, n, len(f.ring))
+			}
+			if lag = f.lagsLocked(s, n); lag {
+				break
+			}
+		}
+		if !lag {
+			return nil
+		}
+
+		advanced := f.advanced
+		f.mu.Unlock()
+		select {
+		case <-advanced:
+			f.mu.Lock()
+		case <-timeout:
+			f.mu.Lock()
+			for s := range f.subs {
+				if f.lagsLocked(s, n) {
+					s.err = ErrSubscriberTooSlow
+					delete(f.subs, s)
+				}
+			}
+		}
+	}
+}
+
+// notifyLocked renew ch after closing it to wake up waiters
+func (f *ChangeFeed) notifyLocked(ch *chan struct{}) {
+	if f.closed {
+		return
+	}
+	close(*ch)
+	*ch = make(chan struct{})
+}
+
+// append changes of a commit with new seqs
+func (f *ChangeFeed) append(ops *utils.BatchOpBuffer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		f.lastSeq++
+		c := Change{Seq: f.lastSeq, Op: ChangeOp(op.Type), Key: op.Key, Value: op.Value, BatchEnd: e.Next() == nil}
+		if f.size == len(f.ring) {
+			f.head = (f.head + 1) % len(f.ring)
+			f.firstSeq++
+			f.size--
+		}
+		f.ring[(f.head+f.size)%len(f.ring)] = c
+		f.size++
+	}
+
+	f.notifyLocked(&f.appended)
+}
+
+// commit write ops to inner batch and append them to feed in commit order
+func (f *ChangeFeed) commit(ops *utils.BatchOpBuffer, sync bool) error {
+	if ops.Len() == 0 {
+		return nil
+	}
+
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	if err := f.waitRoom(ops.Len()); err != nil {
+		return err
+	}
+
+	wb := f.IDB.NewWriteBatch()
+	defer wb.Close()
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		switch op.Type {
+		case utils.BatchOpTypePut:
+			wb.Put(op.Key, op.Value)
+		case utils.BatchOpTypeDel:
+			wb.Delete(op.Key)
+		case utils.BatchOpTypeDelRange:
+			if err := BatchDeleteRange(f.IDB, wb, op.Key, op.Value); err != nil {
+				return err
+			}
+		case utils.BatchOpTypeMerge:
+			if err := BatchMerge(wb, op.Key, op.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	if sync {
+		err = wb.SyncCommit()
+	} else {
+		err = wb.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	f.append(ops)
+	return nil
+}
+
+func (f *ChangeFeed) write(sync bool, fn func(ops *utils.BatchOpBuffer)) error {
+	ops := utils.NewBatchOpBuffer()
+	fn(ops)
+	return f.commit(ops, sync)
+}
+
+func (f *ChangeFeed) Put(key []byte, value []byte) error {
+	return f.write(false, func(ops *utils.BatchOpBuffer) {
+		ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+	})
+}
+
+func (f *ChangeFeed) SyncPut(key []byte, value []byte) error {
+	return f.write(true, func(ops *utils.BatchOpBuffer) {
+		ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+	})
+}
+
+func (f *ChangeFeed) Delete(key []byte) error {
+	return f.write(false, func(ops *utils.BatchOpBuffer) {
+		ops.Del(append([]byte{}, key...))
+	})
+}
+
+func (f *ChangeFeed) SyncDelete(key []byte) error {
+	return f.write(true, func(ops *utils.BatchOpBuffer) {
+		ops.Del(append([]byte{}, key...))
+	})
+}
+
+func (f *ChangeFeed) DeleteRange(start, end []byte) error {
+	return f.write(false, func(ops *utils.BatchOpBuffer) {
+		ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+	})
+}
+
+// Merge merge operand to key, the change is the operand not the merged value
+func (f *ChangeFeed) Merge(key, operand []byte) error {
+	return f.write(false, func(ops *utils.BatchOpBuffer) {
+		ops.Merge(append([]byte{}, key...), append([]byte{}, operand...))
+	})
+}
+
+func (f *ChangeFeed) GetSlice(key []byte) (ISlice, error) {
+	if g, ok := f.IDB.(ISliceGeter); ok {
+		return g.GetSlice(key)
+	}
+
+	v, err := f.IDB.Get(key)
+	if v == nil {
+		return nil, err
+	}
+	return GoSlice(v), nil
+}
+
+func (f *ChangeFeed) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return MultiGet(f.IDB, keys)
+}
+
+func (f *ChangeFeed) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return NewIteratorWithOptions(f.IDB, opts)
+}
+
+func (f *ChangeFeed) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(f.IDB, ranges...)
+}
+
+func (f *ChangeFeed) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(f.IDB, ranges...)
+}
+
+func (f *ChangeFeed) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(f.IDB, r)
+}
+
+func (f *ChangeFeed) Property(name string) (string, error) {
+	return Property(f.IDB, name)
+}
+
+func (f *ChangeFeed) CompactRange(start, end []byte) error {
+	return CompactRange(f.IDB, start, end)
+}
+
+func (f *ChangeFeed) NewWriteBatch() IWriteBatch {
+	return &feedWriteBatch{f: f, ops: utils.NewBatchOpBuffer()}
+}
+
+// feedWriteBatch buffer ops, write and append them to feed when commit
+type feedWriteBatch struct {
+	f   *ChangeFeed
+	ops *utils.BatchOpBuffer
+}
+
+func (wb *feedWriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (wb *feedWriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+}
+
+func (wb *feedWriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+}
+
+func (wb *feedWriteBatch) Merge(key, operand []byte) {
+	wb.ops.Merge(append([]byte{}, key...), append([]byte{}, operand...))
+}
+
+// Commit commit ops, the ops are kept, the changes are appended again if commit again
+func (wb *feedWriteBatch) Commit() error {
+	return wb.f.commit(wb.ops, false)
+}
+
+func (wb *feedWriteBatch) SyncCommit() error {
+	return wb.f.commit(wb.ops, true)
+}
+
+func (wb *feedWriteBatch) Rollback() error {
+	wb.ops.Reset()
+	return nil
+}
+
+func (wb *feedWriteBatch) Data() []byte {
+	return wb.ops.Data()
+}
+
+func (wb *feedWriteBatch) Close() {
+	wb.ops.Reset()
+}
+
+// Subscription ordered changes reader of feed, not goroutine safe
+type Subscription struct {
+	f            *ChangeFeed
+	backpressure bool
+	// seq the next seq to read
+	seq uint64
+	err error
+}
+
+// Seq the seq of next change to read, resume from it after restart
+func (s *Subscription) Seq() uint64 {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+
+	return s.seq
+}
+
+// Next wait and return the next change, the change must not be modified.
+// return ErrChangeFeedTruncated if the change is overwritten (not backpressure subscriber),
+// ErrSubscriberTooSlow if dropped by backpressure timeout,
+// ErrChangeFeedClosed after the retained changes if feed is closed.
+func (s *Subscription) Next(ctx context.Context) (Change, error) {
+	f := s.f
+	f.mu.Lock()
+	for {
+		if s.err != nil {
+			f.mu.Unlock()
+			return Change{}, s.err
+		}
+		if s.seq < f.firstSeq {
+			s.err = fmt.Errorf("%w: seq %d first retained %d", ErrChangeFeedTruncated, s.seq, f.firstSeq)
+			delete(f.subs, s)
+			continue
+		}
+		if s.seq <= f.lastSeq {
+			c := f.ring[(f.head+int(s.seq-f.firstSeq))%len(f.ring)]
+			s.seq++
+			if s.backpressure {
+				f.notifyLocked(&f.advanced)
+			}
+			f.mu.Unlock()
+			return c, nil
+		}
+		if f.closed {
+			f.mu.Unlock()
+			return Change{}, ErrChangeFeedClosed
+		}
+
+		appended := f.appended
+		f.mu.Unlock()
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return Change{}, ctx.Err()
+		}
+		f.mu.Lock()
+	}
+}
+
+// Close unsubscribe, release the backpressure
+func (s *Subscription) Close() {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = ErrSubscriptionClosed
+	delete(f.subs, s)
+	if s.backpressure {
+		f.notifyLocked(&f.advanced)
+	}
+}
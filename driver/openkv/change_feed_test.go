@@ -0,0 +1,246 @@
+package driver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+	"github.com/weedge/pkg/option"
+)
+
+func TestChangeFeedConformance(t *testing.T) {
+	openkvtest.RunConformance(t, wrapStore{memkv.NewStore(), func(root driver.IDB) driver.IDB {
+		return driver.NewChangeFeed(root, driver.WithChangeFeedCapacity(16))
+	}})
+}
+
+func openChangeFeed(t *testing.T, opts ...option.Option) *driver.ChangeFeed {
+	db, err := memkv.NewStore().Open("feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := driver.NewChangeFeed(db, opts...)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func nextChanges(t *testing.T, s *driver.Subscription, n int) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	changes := []string{}
+	for i := 0; i < n; i++ {
+		c, err := s.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		str := fmt.Sprintf("%d:%s:%s", c.Seq, c.Op, c.Key)
+		if c.BatchEnd {
+			str += "$"
+		}
+		changes = append(changes, str)
+	}
+	return fmt.Sprint(changes)
+}
+
+func TestChangeFeedOrderResume(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedStartSeq(100))
+	s, err := f.Subscribe(f.LastSeq()+1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	f.Put([]byte("a"), []byte("1"))
+	wb := f.NewWriteBatch()
+	wb.Put([]byte("b"), []byte("1"))
+	wb.Delete([]byte("a"))
+	driver.BatchDeleteRange(f, wb, []byte("c"), []byte("d"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	wb.Close()
+
+	expected := "[101:put:a$ 102:put:b 103:delete:a 104:delete_range:c$]"
+	if got := nextChanges(t, s, 4); got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	if s.Seq() != 105 || f.LastSeq() != 104 {
+		t.Errorf("Got seq %d last %d expected 105 104", s.Seq(), f.LastSeq())
+	}
+
+	// resume from a retained seq
+	r, err := f.Subscribe(103, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := nextChanges(t, r, 2); got != "[103:delete:a 104:delete_range:c$]" {
+		t.Errorf("Got %s", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got %v expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestChangeFeedTruncated(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedCapacity(4))
+	s, _ := f.Subscribe(1, false)
+	defer s.Close()
+	for i := 0; i < 6; i++ {
+		f.Put([]byte(fmt.Sprintf("k%d", i)), nil)
+	}
+
+	if f.FirstSeq() != 3 {
+		t.Errorf("Got %d expected %d", f.FirstSeq(), 3)
+	}
+	if _, err := s.Next(context.Background()); !errors.Is(err, driver.ErrChangeFeedTruncated) {
+		t.Errorf("Got %v expected %v", err, driver.ErrChangeFeedTruncated)
+	}
+	if _, err := f.Subscribe(2, false); !errors.Is(err, driver.ErrChangeFeedTruncated) {
+		t.Errorf("Got %v expected %v", err, driver.ErrChangeFeedTruncated)
+	}
+}
+
+func TestChangeFeedBackpressure(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedCapacity(4))
+	s, _ := f.Subscribe(1, true)
+	defer s.Close()
+	for i := 0; i < 4; i++ {
+		f.Put([]byte(fmt.Sprintf("k%d", i)), nil)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- f.Put([]byte("k4"), nil)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Got put done %v expected blocked", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if got := nextChanges(t, s, 1); got != "[1:put:k0$]" {
+		t.Errorf("Got %s", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := nextChanges(t, s, 4); got != "[2:put:k1$ 3:put:k2$ 4:put:k3$ 5:put:k4$]" {
+		t.Errorf("Got %s", got)
+	}
+}
+
+func TestChangeFeedBackpressureTimeout(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedCapacity(2), driver.WithChangeFeedBackpressureTimeout(10*time.Millisecond))
+	s, _ := f.Subscribe(1, true)
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		if err := f.Put([]byte(fmt.Sprintf("k%d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Next(context.Background()); !errors.Is(err, driver.ErrSubscriberTooSlow) {
+		t.Errorf("Got %v expected %v", err, driver.ErrSubscriberTooSlow)
+	}
+}
+
+func TestChangeFeedReplay(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedCapacity(64))
+	s, _ := f.Subscribe(1, true)
+	defer s.Close()
+
+	replica, _ := memkv.NewStore().Open("replica")
+	defer replica.Close()
+	replayed := make(chan error)
+	applied := atomic.Uint64{}
+	go func() {
+		for {
+			c, err := s.Next(context.Background())
+			if err != nil {
+				replayed <- err
+				return
+			}
+			switch c.Op {
+			case driver.ChangeOpPut:
+				err = replica.Put(c.Key, c.Value)
+			case driver.ChangeOpDelete:
+				err = replica.Delete(c.Key)
+			}
+			if err != nil {
+				replayed <- err
+				return
+			}
+			applied.Store(c.Seq)
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := []byte(fmt.Sprintf("k%d", j%10))
+				if j%3 == 0 {
+					f.Delete(key)
+				} else {
+					f.Put(key, []byte(fmt.Sprintf("%d-%d", i, j)))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for last := f.LastSeq(); applied.Load() < last; {
+		time.Sleep(time.Millisecond)
+	}
+	if got, expected := iterKeyValues(replica), iterKeyValues(f); got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+
+	f.Close()
+	if err := <-replayed; !errors.Is(err, driver.ErrChangeFeedClosed) {
+		t.Errorf("Got %v expected %v", err, driver.ErrChangeFeedClosed)
+	}
+}
+
+func TestChangeFeedBatchSize(t *testing.T) {
+	f := openChangeFeed(t, driver.WithChangeFeedCapacity(4))
+	s, _ := f.Subscribe(1, true)
+	f.Put([]byte("a"), nil)
+
+	wb := f.NewWriteBatch()
+	defer wb.Close()
+	for i := 0; i < 5; i++ {
+		wb.Put([]byte(fmt.Sprintf("k%d", i)), nil)
+	}
+	if err := wb.Commit(); !errors.Is(err, driver.ErrChangeFeedBatchSize) {
+		t.Errorf("Got %v expected %v", err, driver.ErrChangeFeedBatchSize)
+	}
+	if v, _ := f.Get([]byte("k0")); v != nil || f.LastSeq() != 1 {
+		t.Errorf("Got %q last seq %d, batch is committed", v, f.LastSeq())
+	}
+	if got := nextChanges(t, s, 1); got != "[1:put:a$]" {
+		t.Errorf("Got %s", got)
+	}
+
+	// no backpressure subscribers, lossy subscribers are truncated
+	s.Close()
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if f.LastSeq() != 6 || f.FirstSeq() != 3 {
+		t.Errorf("Got first %d last %d expected 3 6", f.FirstSeq(), f.LastSeq())
+	}
+}
@@ -0,0 +1,279 @@
+package codeckv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// value format:
+//
+//	plain value (no header, legacy data or small value not encrypted)
+//	| HeaderMagic (4 bytes) | HeaderVersion | format | crc32c (4 bytes) | payload |
+//
+// format low 4 bits is compression, high 4 bits is encryption,
+// crc32c is the checksum of | HeaderMagic | HeaderVersion | format | payload |,
+// encrypted payload is | uvarint key id | nonce | aes-gcm sealed compressed value |,
+// the additional data of aes-gcm is | key | HeaderMagic | HeaderVersion | format |.
+// new plain value which starts with HeaderMagic is written with header too,
+// value which starts with HeaderMagic and HeaderVersion but has invalid format or checksum is corrupted.
+const (
+	HeaderMagic   = "\xcecdc"
+	HeaderVersion = byte(1)
+	headerLen     = len(HeaderMagic) + 2 + crc32.Size
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	CompressionNone byte = iota
+	CompressionSnappy
+	CompressionZstd
+	compressionNum
+)
+
+const (
+	EncryptionNone byte = iota
+	EncryptionAESGCM
+	encryptionNum
+)
+
+var ErrCorrupted = errors.New("codec value corrupted")
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		// nil writer/reader for EncodeAll/DecodeAll which are goroutine safe
+		zstdEnc, _ = zstd.NewWriter(nil)
+		zstdDec, _ = zstd.NewReader(nil)
+	})
+	return zstdEnc, zstdDec
+}
+
+// Format parse the compression and encryption of value, ok is false if value is plain or corrupted
+func Format(value []byte) (compression, encryption byte, ok bool) {
+	compression, encryption, ok, err := parseHeader(value)
+	return compression, encryption, ok && err == nil
+}
+
+// parseHeader ok is false if value is plain, return ErrCorrupted if header is invalid
+func parseHeader(value []byte) (compression, encryption byte, ok bool, err error) {
+	prefix := len(HeaderMagic) + 1
+	if len(value) < prefix || string(value[:len(HeaderMagic)]) != HeaderMagic || value[len(HeaderMagic)] != HeaderVersion {
+		return
+	}
+	if len(value) < headerLen {
+		return 0, 0, true, ErrCorrupted
+	}
+	format := value[prefix]
+	compression, encryption = format&0x0f, format>>4
+	if compression >= compressionNum || encryption >= encryptionNum ||
+		binary.BigEndian.Uint32(value[prefix+1:]) != checksum(format, value[headerLen:]) {
+		return 0, 0, true, ErrCorrupted
+	}
+	return compression, encryption, true, nil
+}
+
+func checksum(format byte, payload []byte) uint32 {
+	crc := crc32.Update(0, crc32c, []byte(HeaderMagic))
+	crc = crc32.Update(crc, crc32c, []byte{HeaderVersion, format})
+	return crc32.Update(crc, crc32c, payload)
+}
+
+// appendHeader append header with zero checksum, sealHeader set it after payload is appended
+func appendHeader(out []byte, format byte) []byte {
+	out = append(out, HeaderMagic...)
+	return append(out, HeaderVersion, format, 0, 0, 0, 0)
+}
+
+func sealHeader(out []byte) []byte {
+	format := out[len(HeaderMagic)+1]
+	binary.BigEndian.PutUint32(out[len(HeaderMagic)+2:], checksum(format, out[headerLen:]))
+	return out
+}
+
+type codec struct {
+	opts    *Options
+	ciphers sync.Map // key id -> cipher.AEAD
+}
+
+func newCodec(opts *Options) *codec {
+	return &codec{opts: opts}
+}
+
+func (c *codec) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	if a, ok := c.ciphers.Load(id); ok {
+		return a.(cipher.AEAD), nil
+	}
+
+	var err error
+	if key == nil {
+		if key, err = c.opts.keyProvider.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.ciphers.Store(id, a)
+
+	return a, nil
+}
+
+func compress(value []byte, opts *Options) (byte, []byte) {
+	n := len(value)
+	switch {
+	case opts.ZstdThreshold > 0 && n >= opts.ZstdThreshold:
+		enc, _ := zstdCodec()
+		if out := enc.EncodeAll(value, nil); len(out) < n {
+			return CompressionZstd, out
+		}
+	case opts.SnappyThreshold > 0 && n >= opts.SnappyThreshold:
+		if out := snappy.Encode(nil, value); len(out) < n {
+			return CompressionSnappy, out
+		}
+	}
+
+	return CompressionNone, value
+}
+
+func decompress(compression byte, payload []byte) ([]byte, error) {
+	switch compression {
+	case CompressionSnappy:
+		out, err := snappy.Decode(nil, payload)
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		return nonNil(out), nil
+	case CompressionZstd:
+		_, dec := zstdCodec()
+		out, err := dec.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		return nonNil(out), nil
+	}
+
+	return payload, nil
+}
+
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// encode key's value to stored format
+func (c *codec) encode(key, value []byte) ([]byte, error) {
+	compression, payload := compress(value, c.opts)
+	if c.opts.keyProvider == nil {
+		if compression == CompressionNone && !bytes.HasPrefix(value, []byte(HeaderMagic)) {
+			return value, nil
+		}
+		out := make([]byte, 0, headerLen+len(payload))
+		out = appendHeader(out, compression)
+		return sealHeader(append(out, payload...)), nil
+	}
+
+	id, k, err := c.opts.keyProvider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	a, err := c.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+
+	format := EncryptionAESGCM<<4 | compression
+	out := make([]byte, 0, headerLen+binary.MaxVarintLen32+a.NonceSize()+len(payload)+a.Overhead())
+	out = appendHeader(out, format)
+	out = binary.AppendUvarint(out, uint64(id))
+	nonce := out[len(out) : len(out)+a.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = out[:len(out)+a.NonceSize()]
+
+	return sealHeader(a.Seal(out, nonce, payload, additionalData(key, format))), nil
+}
+
+func additionalData(key []byte, format byte) []byte {
+	ad := make([]byte, 0, len(key)+len(HeaderMagic)+2)
+	ad = append(ad, key...)
+	ad = append(ad, HeaderMagic...)
+	return append(ad, HeaderVersion, format)
+}
+
+// decode key's stored value, nil value is not exists
+func (c *codec) decode(key, value []byte) ([]byte, error) {
+	compression, encryption, ok, err := parseHeader(value)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return value, nil
+	}
+
+	payload := value[headerLen:]
+	if encryption == EncryptionAESGCM {
+		id, n := binary.Uvarint(payload)
+		if n <= 0 || id > uint64(^uint32(0)) {
+			return nil, ErrCorrupted
+		}
+		if c.opts.keyProvider == nil {
+			return nil, ErrNoCurrentKey
+		}
+		a, err := c.aead(uint32(id), nil)
+		if err != nil {
+			return nil, err
+		}
+		payload = payload[n:]
+		if len(payload) < a.NonceSize() {
+			return nil, ErrCorrupted
+		}
+		nonce := payload[:a.NonceSize()]
+		payload, err = a.Open(nil, nonce, payload[a.NonceSize():], additionalData(key, value[len(HeaderMagic)+1]))
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+	}
+
+	return decompress(compression, nonNil(payload))
+}
+
+// stale value is not encrypted by the current key
+func (c *codec) stale(value []byte) bool {
+	if c.opts.keyProvider == nil {
+		return false
+	}
+	current, _, err := c.opts.keyProvider.CurrentKey()
+	if err != nil {
+		return false
+	}
+
+	_, encryption, ok := Format(value)
+	if !ok || encryption == EncryptionNone {
+		return true
+	}
+	id, n := binary.Uvarint(value[headerLen:])
+	return n <= 0 || uint32(id) != current
+}
@@ -0,0 +1,406 @@
+// Package codeckv wrap openkv engine to compress and encrypt values transparently,
+// values are compressed by snappy/zstd which is chosen by value size threshold,
+// then encrypted by aes-gcm with the current key of KeyProvider if it is set.
+// stored value is self-describing by header, plain data written before still reads.
+package codeckv
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/option"
+)
+
+// RewriteBatchSize Rewrite commit once per batch size keys
+var RewriteBatchSize = 1024
+
+// Store wrap the inner store, the opened dbs encode values by options
+type Store struct {
+	inner driver.IStore
+	opts  []option.Option
+}
+
+func NewStore(inner driver.IStore, opts ...option.Option) *Store {
+	return &Store{inner: inner, opts: opts}
+}
+
+func (s *Store) String() string {
+	return s.Name()
+}
+
+// Name codec:<inner store name>
+func (s *Store) Name() string {
+	return "codec:" + s.inner.Name()
+}
+
+func (s *Store) Open(path string) (driver.IDB, error) {
+	db, err := s.inner.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(db, s.opts...), nil
+}
+
+// OptionSpecs the options which inner store supports
+func (s *Store) OptionSpecs() []driver.OptionSpec {
+	return driver.GetStoreInfo(s.inner).Options
+}
+
+func (s *Store) OpenWithOptions(path string, opts ...option.Option) (driver.IDB, error) {
+	db, err := driver.OpenWithOptions(s.inner, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(db, s.opts...), nil
+}
+
+func (s *Store) Repair(path string) error {
+	return s.inner.Repair(path)
+}
+
+// Restore restore the archive of DB.Backup (stored values) to inner store
+func (s *Store) Restore(r io.Reader, path string) error {
+	return driver.Restore(s.inner, r, path)
+}
+
+// Wrap wrap db to encode values by options,
+// the iterators, write batches and snapshots from it are wrapped too.
+// merge is not supported, wrap it with driver.WithMergeOperator for merge.
+func Wrap(db driver.IDB, opts ...option.Option) *DB {
+	return &DB{IDB: db, c: newCodec(getOptions(opts...))}
+}
+
+type DB struct {
+	driver.IDB
+	c *codec
+	// writes hold the read lock, Rewrite holds the write lock to compare and swap a batch
+	mu sync.RWMutex
+}
+
+func (db *DB) Get(key []byte) ([]byte, error) {
+	v, err := db.IDB.Get(key)
+	if v == nil || err != nil {
+		return v, err
+	}
+	return db.c.decode(key, v)
+}
+
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return multiGet(db.c, db.IDB, keys)
+}
+
+func multiGet(c *codec, g driver.IGeter, keys [][]byte) ([][]byte, []error) {
+	values, errs := driver.MultiGet(g, keys)
+	for i, v := range values {
+		if v != nil && errs[i] == nil {
+			values[i], errs[i] = c.decode(keys[i], v)
+		}
+	}
+	return values, errs
+}
+
+func (db *DB) Put(key []byte, value []byte) error {
+	v, err := db.c.encode(key, value)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.IDB.Put(key, v)
+}
+
+func (db *DB) SyncPut(key []byte, value []byte) error {
+	v, err := db.c.encode(key, value)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.IDB.SyncPut(key, v)
+}
+
+func (db *DB) Delete(key []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.IDB.Delete(key)
+}
+
+func (db *DB) SyncDelete(key []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.IDB.SyncDelete(key)
+}
+
+func (db *DB) DeleteRange(start, end []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return driver.DeleteRange(db.IDB, start, end)
+}
+
+func (db *DB) ApproximateSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateTotalSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateCount(r driver.Range) (uint64, error) {
+	return driver.ApproximateCount(db.IDB, r)
+}
+
+func (db *DB) Property(name string) (string, error) {
+	return driver.Property(db.IDB, name)
+}
+
+func (db *DB) NewIterator() driver.IIterator {
+	return &Iterator{IIterator: db.IDB.NewIterator(), c: db.c}
+}
+
+func (db *DB) NewIteratorWithOptions(opts *driver.IteratorOptions) driver.IIterator {
+	return &Iterator{IIterator: driver.NewIteratorWithOptions(db.IDB, opts), c: db.c}
+}
+
+func (db *DB) CompactRange(start, end []byte) error {
+	return driver.CompactRange(db.IDB, start, end)
+}
+
+func (db *DB) NewWriteBatch() driver.IWriteBatch {
+	return &WriteBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db.IDB, mu: &db.mu, c: db.c}
+}
+
+func (db *DB) NewSnapshot() (driver.ISnapshot, error) {
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{ISnapshot: snap, c: db.c}, nil
+}
+
+// Backup stream the stored (compressed/encrypted) values of db to w,
+// restore it by Store.Restore or driver.RestoreToDB to the inner db.
+func (db *DB) Backup(w io.Writer) error {
+	return driver.Backup(db.IDB, w)
+}
+
+// Rewrite re-encode the values in [start, end) which are not encrypted by the current key,
+// for key rotation, after it the old keys can be dropped.
+// each batch of RewriteBatchSize keys is compared and swapped under lock,
+// the key which is written after it is read is skipped (the new value is encoded by the current key),
+// return the number of rewritten keys.
+func (db *DB) Rewrite(start, end []byte) (int, error) {
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Close()
+
+	it := driver.NewIteratorWithOptions(snap, &driver.IteratorOptions{LowerBound: start, UpperBound: end})
+	defer it.Close()
+
+	n := 0
+	keys, olds, news := [][]byte{}, [][]byte{}, [][]byte{}
+	for it.First(); it.Valid(); it.Next() {
+		if !db.c.stale(it.Value()) {
+			continue
+		}
+		key, old := append([]byte{}, it.Key()...), append([]byte{}, it.Value()...)
+		v, err := db.c.decode(key, old)
+		if err != nil {
+			return n, err
+		}
+		if v, err = db.c.encode(key, v); err != nil {
+			return n, err
+		}
+		keys, olds, news = append(keys, key), append(olds, old), append(news, v)
+		if len(keys) == RewriteBatchSize {
+			swapped, err := db.swap(keys, olds, news)
+			if n += swapped; err != nil {
+				return n, err
+			}
+			keys, olds, news = keys[:0], olds[:0], news[:0]
+		}
+	}
+	if err := it.Error(); err != nil {
+		return n, err
+	}
+	if len(keys) > 0 {
+		swapped, err := db.swap(keys, olds, news)
+		return n + swapped, err
+	}
+
+	return n, nil
+}
+
+// swap put news of keys whose stored values are still olds, return the number of swapped keys
+func (db *DB) swap(keys, olds, news [][]byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	values, errs := driver.MultiGet(db.IDB, keys)
+	wb := db.IDB.NewWriteBatch()
+	defer wb.Close()
+
+	n := 0
+	for i, key := range keys {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		if values[i] == nil || !bytes.Equal(values[i], olds[i]) {
+			continue
+		}
+		wb.Put(key, news[i])
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// WriteBatch encode values when put, encode error is returned by commit
+type WriteBatch struct {
+	driver.IWriteBatch
+	db  driver.IDB
+	mu  *sync.RWMutex
+	c   *codec
+	err error
+}
+
+func (wb *WriteBatch) Put(key []byte, value []byte) {
+	v, err := wb.c.encode(key, value)
+	if err != nil {
+		if wb.err == nil {
+			wb.err = err
+		}
+		return
+	}
+	wb.IWriteBatch.Put(key, v)
+}
+
+func (wb *WriteBatch) DeleteRange(start, end []byte) {
+	driver.BatchDeleteRange(wb.db, wb.IWriteBatch, start, end)
+}
+
+func (wb *WriteBatch) Commit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	return wb.IWriteBatch.Commit()
+}
+
+func (wb *WriteBatch) SyncCommit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	return wb.IWriteBatch.SyncCommit()
+}
+
+func (wb *WriteBatch) Rollback() error {
+	wb.err = nil
+	return wb.IWriteBatch.Rollback()
+}
+
+type Snapshot struct {
+	driver.ISnapshot
+	c *codec
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	v, err := s.ISnapshot.Get(key)
+	if v == nil || err != nil {
+		return v, err
+	}
+	return s.c.decode(key, v)
+}
+
+func (s *Snapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return multiGet(s.c, s.ISnapshot, keys)
+}
+
+func (s *Snapshot) NewIterator() driver.IIterator {
+	return &Iterator{IIterator: s.ISnapshot.NewIterator(), c: s.c}
+}
+
+func (s *Snapshot) NewIteratorWithOptions(opts *driver.IteratorOptions) driver.IIterator {
+	return &Iterator{IIterator: driver.NewIteratorWithOptions(s.ISnapshot, opts), c: s.c}
+}
+
+// Iterator decode value of the current key once,
+// after value decode error, the iterator is invalid and Error() return it.
+type Iterator struct {
+	driver.IIterator
+	c       *codec
+	value   []byte
+	decoded bool
+	err     error
+}
+
+func (it *Iterator) step(move func()) {
+	if it.err != nil {
+		return
+	}
+	it.value, it.decoded = nil, false
+	move()
+}
+
+func (it *Iterator) First() {
+	it.step(it.IIterator.First)
+}
+
+func (it *Iterator) Last() {
+	it.step(it.IIterator.Last)
+}
+
+func (it *Iterator) Seek(key []byte) {
+	it.step(func() { it.IIterator.Seek(key) })
+}
+
+func (it *Iterator) Next() {
+	it.step(it.IIterator.Next)
+}
+
+func (it *Iterator) Prev() {
+	it.step(it.IIterator.Prev)
+}
+
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.IIterator.Valid()
+}
+
+func (it *Iterator) Key() []byte {
+	if it.err != nil {
+		return nil
+	}
+	return it.IIterator.Key()
+}
+
+func (it *Iterator) Value() []byte {
+	if it.err != nil || !it.IIterator.Valid() {
+		return nil
+	}
+	if !it.decoded {
+		it.value, it.err = it.c.decode(it.IIterator.Key(), it.IIterator.Value())
+		it.decoded = true
+	}
+	return it.value
+}
+
+func (it *Iterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.IIterator.Error()
+}
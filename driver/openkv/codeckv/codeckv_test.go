@@ -0,0 +1,237 @@
+package codeckv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+type testStore struct {
+	*Store
+	mem *memkv.Store
+}
+
+func (s testStore) Destroy(path string) error {
+	return s.mem.Destroy(path)
+}
+
+func testKeyRing(t *testing.T, ids ...uint32) *KeyRing {
+	kr := NewKeyRing()
+	for _, id := range ids {
+		if err := kr.Rotate(id, bytes.Repeat([]byte{byte(id)}, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kr
+}
+
+func TestConformance(t *testing.T) {
+	mem := memkv.NewStore()
+	openkvtest.RunConformance(t, testStore{NewStore(mem), mem})
+}
+
+func TestConformanceEncrypted(t *testing.T) {
+	mem := memkv.NewStore()
+	openkvtest.RunConformance(t, testStore{NewStore(mem,
+		WithSnappyThreshold(1), WithZstdThreshold(64), WithKeyProvider(testKeyRing(t, 1))), mem})
+}
+
+func TestFormat(t *testing.T) {
+	root, _ := memkv.NewStore().Open("format")
+	defer root.Close()
+	db := Wrap(root, WithSnappyThreshold(16), WithZstdThreshold(1024))
+
+	small := []byte("v")
+	magic := []byte(HeaderMagic + "x")
+	mid := bytes.Repeat([]byte("a"), 100)
+	large := bytes.Repeat([]byte("b"), 2000)
+	random := make([]byte, 100)
+	for i := range random {
+		random[i] = byte(i * 131)
+	}
+	cases := []struct {
+		key, value  []byte
+		header      bool
+		compression byte
+	}{
+		{[]byte("small"), small, false, CompressionNone},
+		{[]byte("empty"), []byte{}, false, CompressionNone},
+		{[]byte("magic"), magic, true, CompressionNone},
+		{[]byte("mid"), mid, true, CompressionSnappy},
+		{[]byte("large"), large, true, CompressionZstd},
+		{[]byte("random"), random, false, CompressionNone},
+	}
+	for _, c := range cases {
+		if err := db.Put(c.key, c.value); err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := root.Get(c.key)
+		compression, _, ok := Format(raw)
+		if ok != c.header || compression != c.compression {
+			t.Errorf("%s format Got %v %d expected %v %d", c.key, ok, compression, c.header, c.compression)
+		}
+		if v, err := db.Get(c.key); err != nil || v == nil || !bytes.Equal(v, c.value) {
+			t.Errorf("%s Got %q %v expected %q", c.key, v, err, c.value)
+		}
+	}
+
+	// plain data written before still reads, even it starts with a byte of HeaderMagic and a valid format
+	for _, legacy := range []string{"plain", "\xce\x01abc", HeaderMagic} {
+		root.Put([]byte("legacy"), []byte(legacy))
+		if v, err := db.Get([]byte("legacy")); err != nil || string(v) != legacy {
+			t.Errorf("Got %q %v expected %q", v, err, legacy)
+		}
+	}
+
+	// header checksum mismatch
+	raw, _ := root.Get([]byte("mid"))
+	raw[len(raw)-1]++
+	root.Put([]byte("mid"), raw)
+	if _, _, ok := Format(raw); ok {
+		t.Errorf("Got format ok of corrupted value")
+	}
+	if _, err := db.Get([]byte("mid")); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Got %v expected %v", err, ErrCorrupted)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	kr := testKeyRing(t, 1)
+	root, _ := memkv.NewStore().Open("encrypt")
+	defer root.Close()
+	db := Wrap(root, WithKeyProvider(kr))
+
+	db.Put([]byte("a"), []byte("secret"))
+	root.Put([]byte("legacy"), []byte("plain"))
+	raw, _ := root.Get([]byte("a"))
+	if _, encryption, ok := Format(raw); !ok || encryption != EncryptionAESGCM || bytes.Contains(raw, []byte("secret")) {
+		t.Errorf("value is not encrypted %q", raw)
+	}
+
+	// value is bound to key
+	root.Put([]byte("b"), raw)
+	if _, err := db.Get([]byte("b")); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Got %v expected %v", err, ErrCorrupted)
+	}
+	root.Delete([]byte("b"))
+
+	// rotate key, old values still read, rewrite them to the new key
+	kr.Rotate(2, bytes.Repeat([]byte{2}, 16))
+	db.Put([]byte("c"), []byte("new"))
+	if v, _ := db.Get([]byte("a")); string(v) != "secret" {
+		t.Errorf("Got %q expected %q", v, "secret")
+	}
+	n, err := db.Rewrite(nil, nil)
+	if err != nil || n != 2 {
+		t.Errorf("rewrite Got %d %v expected %d", n, err, 2)
+	}
+
+	kr2 := NewKeyRing()
+	kr2.Rotate(2, bytes.Repeat([]byte{2}, 16))
+	db2 := Wrap(root, WithKeyProvider(kr2))
+	it := db2.NewIterator()
+	defer it.Close()
+	got := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		got = append(got, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+	}
+	if fmt.Sprint(got) != "[a=secret c=new legacy=plain]" || it.Error() != nil {
+		t.Errorf("Got %v %v", got, it.Error())
+	}
+
+	// old key is dropped, values written by it can't be read
+	db.Put([]byte("d"), []byte("x"))
+	kr3 := NewKeyRing()
+	kr3.Rotate(1, bytes.Repeat([]byte{1}, 32))
+	if _, err := Wrap(root, WithKeyProvider(kr3)).Get([]byte("a")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Got %v expected %v", err, ErrKeyNotFound)
+	}
+	if _, err := Wrap(root).Get([]byte("a")); !errors.Is(err, ErrNoCurrentKey) {
+		t.Errorf("Got %v expected %v", err, ErrNoCurrentKey)
+	}
+
+	// iterator stops at decode error
+	it3 := Wrap(root, WithKeyProvider(kr3)).NewIterator()
+	defer it3.Close()
+	it3.First()
+	if it3.Value() != nil || it3.Valid() || !errors.Is(it3.Error(), ErrKeyNotFound) {
+		t.Errorf("Got %v %v", it3.Valid(), it3.Error())
+	}
+}
+
+func TestWriteBatchEncodeError(t *testing.T) {
+	root, _ := memkv.NewStore().Open("batch")
+	defer root.Close()
+	db := Wrap(root, WithKeyProvider(NewKeyRing()))
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	wb.Put([]byte("a"), []byte("1"))
+	if err := wb.Commit(); !errors.Is(err, ErrNoCurrentKey) {
+		t.Errorf("Got %v expected %v", err, ErrNoCurrentKey)
+	}
+	wb.Rollback()
+	if err := wb.Commit(); err != nil {
+		t.Errorf("Got %v expected nil", err)
+	}
+	if v, _ := root.Get([]byte("a")); v != nil {
+		t.Errorf("Got %q expected nil", v)
+	}
+	if err := driver.Merge(db, []byte("a"), []byte("1")); !errors.Is(err, driver.ErrMergeUnsupported) {
+		t.Errorf("Got %v expected %v", err, driver.ErrMergeUnsupported)
+	}
+}
+
+// snapHookDB call hook after snapshot is taken
+type snapHookDB struct {
+	driver.IDB
+	hook func()
+}
+
+func (db *snapHookDB) NewSnapshot() (driver.ISnapshot, error) {
+	snap, err := db.IDB.NewSnapshot()
+	db.hook()
+	return snap, err
+}
+
+func TestRewriteConcurrentWrite(t *testing.T) {
+	kr := testKeyRing(t, 1)
+	root, _ := memkv.NewStore().Open("rewrite")
+	defer root.Close()
+	inner := &snapHookDB{IDB: root, hook: func() {}}
+	db := Wrap(inner, WithKeyProvider(kr))
+	for _, k := range []string{"a", "b", "c"} {
+		db.Put([]byte(k), []byte("old"))
+	}
+
+	// writes after the snapshot of rewrite are not overwritten by stale values
+	kr.Rotate(2, bytes.Repeat([]byte{2}, 16))
+	inner.hook = func() {
+		db.Put([]byte("a"), []byte("new"))
+		db.Delete([]byte("b"))
+	}
+	n, err := db.Rewrite(nil, nil)
+	if err != nil || n != 1 {
+		t.Errorf("rewrite Got %d %v expected %d", n, err, 1)
+	}
+
+	got := []string{}
+	for _, k := range []string{"a", "b", "c"} {
+		v, err := db.Get([]byte(k))
+		got = append(got, fmt.Sprintf("%s=%s/%v", k, v, err))
+	}
+	if fmt.Sprint(got) != "[a=new/<nil> b=/<nil> c=old/<nil>]" {
+		t.Errorf("Got %v", got)
+	}
+	for _, k := range []string{"a", "c"} {
+		raw, _ := root.Get([]byte(k))
+		if db.c.stale(raw) {
+			t.Errorf("%s is not rewritten to the current key", k)
+		}
+	}
+}
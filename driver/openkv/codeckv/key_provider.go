@@ -0,0 +1,86 @@
+package codeckv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrKeyNotFound   = errors.New("encryption key not found")
+	ErrNoCurrentKey  = errors.New("no current encryption key")
+	ErrInvalidKeyLen = errors.New("invalid encryption key length, must be 16, 24 or 32 bytes")
+)
+
+// KeyProvider provide aes keys to encrypt/decrypt values,
+// the key of one id must not change, rotate to a new id instead.
+type KeyProvider interface {
+	// CurrentKey return the key id and key to encrypt new values
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key return the key of id to decrypt values
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing in memory KeyProvider, keep old keys to decrypt values written before rotation
+type KeyRing struct {
+	mu         sync.RWMutex
+	keys       map[uint32][]byte
+	current    uint32
+	hasCurrent bool
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[uint32][]byte{}}
+}
+
+// Add add key of id to decrypt values
+func (r *KeyRing) Add(id uint32, key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return ErrInvalidKeyLen
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k, ok := r.keys[id]; ok && string(k) != string(key) {
+		return fmt.Errorf("encryption key id %d exists", id)
+	}
+	r.keys[id] = append([]byte{}, key...)
+
+	return nil
+}
+
+// Rotate add key of id and use it to encrypt new values
+func (r *KeyRing) Rotate(id uint32, key []byte) error {
+	if err := r.Add(id, key); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.current, r.hasCurrent = id, true
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.hasCurrent {
+		return 0, nil, ErrNoCurrentKey
+	}
+
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: id %d", ErrKeyNotFound, id)
+	}
+
+	return k, nil
+}
@@ -0,0 +1,84 @@
+package codeckv
+
+import (
+	"fmt"
+
+	"github.com/weedge/pkg/option"
+)
+
+// Options value codec options
+type Options struct {
+	// SnappyThreshold values which size >= it are compressed by snappy, 0 is disabled
+	SnappyThreshold int `mapstructure:"snappyThreshold"`
+	// ZstdThreshold values which size >= it are compressed by zstd (prior to snappy), 0 is disabled
+	ZstdThreshold int `mapstructure:"zstdThreshold"`
+
+	// optional, encrypt values by aes-gcm with the current key of provider
+	keyProvider KeyProvider
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		SnappyThreshold: 256,
+		ZstdThreshold:   16 * 1024,
+	}
+}
+
+func (o *Options) String() string {
+	return fmt.Sprintf("snappyThreshold:%d zstdThreshold:%d encrypt:%t", o.SnappyThreshold, o.ZstdThreshold, o.keyProvider != nil)
+}
+
+func WithSnappyThreshold(n int) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		o.SnappyThreshold = n
+	})
+}
+
+func WithZstdThreshold(n int) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		o.ZstdThreshold = n
+	})
+}
+
+// WithKeyProvider encrypt values with the keys of provider
+func WithKeyProvider(kp KeyProvider) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		o.keyProvider = kp
+	})
+}
+
+// WithOptions use opts, eg: unmarshaled from config
+func WithOptions(opts Options) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		kp := o.keyProvider
+		*o = opts
+		if o.keyProvider == nil {
+			o.keyProvider = kp
+		}
+	})
+}
+
+func getOptions(opts ...option.Option) *Options {
+	options := DefaultOptions()
+	for _, o := range opts {
+		o.Apply(options)
+	}
+
+	return options
+}
@@ -0,0 +1,375 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils/jobutils"
+)
+
+// CompactRange compact keys in [start, end), nil or empty start/end is unbounded,
+// use engine native range compaction if db is ICompactRanger, else Compact the whole db.
+func CompactRange(db IDB, start, end []byte) error {
+	if c, ok := db.(ICompactRanger); ok {
+		return c.CompactRange(start, end)
+	}
+
+	return db.Compact()
+}
+
+// CompactionOptions background compaction scheduler options
+type CompactionOptions struct {
+	// Interval check tombstone density interval
+	Interval time.Duration `mapstructure:"interval"`
+	// MinDeletes trigger compaction if PropertyNumDeletes >= MinDeletes
+	// and deletes/(keys+deletes) >= DeleteRatio, 0 is disabled
+	MinDeletes  uint64  `mapstructure:"minDeletes"`
+	DeleteRatio float64 `mapstructure:"deleteRatio"`
+	// ChunkBytes compact range in chunks of estimated total data size (include tombstones),
+	// to spread io by budget
+	ChunkBytes int64 `mapstructure:"chunkBytes"`
+	// IOBytesPerSec io budget of compaction (estimated by total data size of chunks include tombstones), 0 is unlimited
+	IOBytesPerSec int64 `mapstructure:"ioBytesPerSec"`
+}
+
+func DefaultCompactionOptions() *CompactionOptions {
+	return &CompactionOptions{
+		Interval:    time.Minute,
+		MinDeletes:  100000,
+		DeleteRatio: 0.3,
+		ChunkBytes:  64 << 20,
+	}
+}
+
+func (o *CompactionOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithCompactionInterval(interval time.Duration) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*CompactionOptions)
+		if !ok {
+			return
+		}
+		o.Interval = interval
+	})
+}
+
+// WithCompactionTrigger trigger compaction by tombstone density
+func WithCompactionTrigger(minDeletes uint64, deleteRatio float64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*CompactionOptions)
+		if !ok {
+			return
+		}
+		o.MinDeletes, o.DeleteRatio = minDeletes, deleteRatio
+	})
+}
+
+func WithCompactionChunkBytes(n int64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*CompactionOptions)
+		if !ok {
+			return
+		}
+		o.ChunkBytes = n
+	})
+}
+
+func WithCompactionIOBudget(bytesPerSec int64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*CompactionOptions)
+		if !ok {
+			return
+		}
+		o.IOBytesPerSec = bytesPerSec
+	})
+}
+
+// WithCompactionOptions use opts, eg: unmarshaled from config
+func WithCompactionOptions(opts CompactionOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*CompactionOptions)
+		if !ok {
+			return
+		}
+		*o = opts
+	})
+}
+
+// CompactionStats compaction scheduler progress
+type CompactionStats struct {
+	Running bool
+	// Pending range compactions which are triggered
+	Pending int
+	// Runs finished range compactions, Triggers by density and Trigger
+	Runs, DensityTriggers, Triggers uint64
+	// CompactedChunks number of all compacted chunks
+	CompactedChunks uint64
+	// CompactedBytes estimated bytes (include tombstones) of all compacted chunks
+	CompactedBytes uint64
+	// the running range compaction progress bytes
+	CurrentDoneBytes, CurrentTotalBytes uint64
+	LastDuration                        time.Duration
+	LastError                           error
+}
+
+// maxPendingCompactions coalesce pending ranges to the whole db if more than it
+const maxPendingCompactions = 16
+
+// maxCompactionSplitDepth stop bisecting range if deeper than it, eg: one huge key
+const maxCompactionSplitDepth = 256
+
+// CompactionScheduler background compaction driven by jobutils.Runner,
+// compact the whole db when tombstone density is high,
+// and the ranges which are triggered after bulk deletes (eg: SlotsDel, FlushDB),
+// compaction is split in chunks and throttled by io budget.
+type CompactionScheduler struct {
+	db     IDB
+	runner *jobutils.Runner
+	opts   *CompactionOptions
+
+	taskID  uint64
+	notify  chan struct{}
+	mu      sync.Mutex
+	pending []Range
+	stats   CompactionStats
+}
+
+func NewCompactionScheduler(db IDB, runner *jobutils.Runner, opts ...option.Option) *CompactionScheduler {
+	o := DefaultCompactionOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+
+	return &CompactionScheduler{
+		db:     db,
+		runner: runner,
+		opts:   o,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Start run the scheduler task in runner
+func (s *CompactionScheduler) Start() (err error) {
+	s.taskID, err = s.runner.RunCancelableTask(s.run)
+	return
+}
+
+// Stop cancel the scheduler task, the running chunk compaction is finished
+func (s *CompactionScheduler) Stop() error {
+	return s.runner.StopCancelableTask(s.taskID)
+}
+
+// Trigger schedule compaction of keys in [start, end) after bulk deletes
+func (s *CompactionScheduler) Trigger(start, end []byte) {
+	s.mu.Lock()
+	s.stats.Triggers++
+	s.push(Range{
+		Start: append([]byte{}, start...),
+		Limit: append([]byte{}, end...),
+	})
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// push pending range with lock held
+func (s *CompactionScheduler) push(r Range) {
+	if len(s.pending) >= maxPendingCompactions {
+		s.pending = append(s.pending[:0], Range{})
+		return
+	}
+	s.pending = append(s.pending, r)
+}
+
+// Stats return the progress of scheduler
+func (s *CompactionScheduler) Stats() CompactionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Pending = len(s.pending)
+	return stats
+}
+
+func (s *CompactionScheduler) run(ctx context.Context) {
+	var tick <-chan time.Time
+	if s.opts.Interval > 0 {
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			if s.dense() {
+				s.mu.Lock()
+				s.stats.DensityTriggers++
+				s.push(Range{})
+				s.mu.Unlock()
+			}
+		case <-s.notify:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.pending) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			r := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+
+			s.compact(ctx, r)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// dense tombstone density is high
+func (s *CompactionScheduler) dense() bool {
+	if s.opts.MinDeletes == 0 {
+		return false
+	}
+	deletes, err := PropertyUint(s.db, PropertyNumDeletes)
+	if err != nil || deletes < s.opts.MinDeletes {
+		return false
+	}
+	keys, err := PropertyUint(s.db, PropertyEstimateNumKeys)
+	if err != nil {
+		return false
+	}
+
+	return float64(deletes)/float64(keys+deletes) >= s.opts.DeleteRatio
+}
+
+// compact the range in chunks of ChunkBytes, wait for io budget after each chunk
+func (s *CompactionScheduler) compact(ctx context.Context, r Range) {
+	start := time.Now()
+	total := uint64(0)
+	if sizes, err := ApproximateTotalSizes(s.db, r); err == nil {
+		total = sizes[0]
+	}
+	s.mu.Lock()
+	s.stats.Running = true
+	s.stats.CurrentDoneBytes, s.stats.CurrentTotalBytes = 0, total
+	s.mu.Unlock()
+
+	err := s.compactChunks(ctx, r)
+
+	s.mu.Lock()
+	s.stats.Running = false
+	s.stats.Runs++
+	s.stats.LastDuration = time.Since(start)
+	s.stats.LastError = err
+	s.mu.Unlock()
+}
+
+func (s *CompactionScheduler) compactChunks(ctx context.Context, r Range) error {
+	_, native := s.db.(ICompactRanger)
+	chunks := []compactionChunk{{Range: r}}
+	if native && s.opts.ChunkBytes > 0 {
+		var err error
+		if chunks, err = s.split(nil, r, 0); err != nil {
+			return err
+		}
+	} else if sizes, err := ApproximateTotalSizes(s.db, r); err == nil {
+		chunks[0].bytes = sizes[0]
+	}
+
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := CompactRange(s.db, chunk.Start, chunk.Limit); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.stats.CompactedChunks++
+		s.stats.CompactedBytes += chunk.bytes
+		s.stats.CurrentDoneBytes += chunk.bytes
+		s.mu.Unlock()
+
+		if s.opts.IOBytesPerSec > 0 && chunk.bytes > 0 {
+			wait := time.Duration(float64(chunk.bytes) / float64(s.opts.IOBytesPerSec) * float64(time.Second))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	return nil
+}
+
+// compactionChunk range and its estimated total bytes
+type compactionChunk struct {
+	Range
+	bytes uint64
+}
+
+// split range to chunks of about ChunkBytes by bisecting key range,
+// sizes are ApproximateTotalSizes so tombstones count,
+// adjacent small chunks are coalesced and appended to chunks.
+func (s *CompactionScheduler) split(chunks []compactionChunk, r Range, depth int) ([]compactionChunk, error) {
+	sizes, err := ApproximateTotalSizes(s.db, r)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := uint64(s.opts.ChunkBytes)
+	mid := midKey(r.Start, r.Limit)
+	if sizes[0] <= limit || depth >= maxCompactionSplitDepth || mid == nil {
+		if n := len(chunks); n > 0 && chunks[n-1].bytes+sizes[0] <= limit {
+			chunks[n-1].Limit = r.Limit
+			chunks[n-1].bytes += sizes[0]
+			return chunks, nil
+		}
+		return append(chunks, compactionChunk{Range: r, bytes: sizes[0]}), nil
+	}
+
+	if chunks, err = s.split(chunks, Range{Start: r.Start, Limit: mid}, depth+1); err != nil {
+		return nil, err
+	}
+	return s.split(chunks, Range{Start: mid, Limit: r.Limit}, depth+1)
+}
+
+// midKey return the key between start and limit by treating keys as fractions,
+// nil or empty limit is unbounded, nil if no key between them, eg: limit is start+"\x00"
+func midKey(start, limit []byte) []byte {
+	n := len(start)
+	if len(limit) > n {
+		n = len(limit)
+	}
+	// one more byte, so the mid of adjacent keys exists
+	n++
+
+	a := new(big.Int).SetBytes(append(append([]byte{}, start...), make([]byte, n-len(start))...))
+	b := new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	if len(limit) > 0 {
+		b.SetBytes(append(append([]byte{}, limit...), make([]byte, n-len(limit))...))
+	}
+	mid := new(big.Int).Add(a, b)
+	mid.Rsh(mid, 1)
+	if mid.Cmp(a) <= 0 {
+		return nil
+	}
+
+	return mid.FillBytes(make([]byte, n))
+}
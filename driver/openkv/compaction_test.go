@@ -0,0 +1,150 @@
+package driver_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/utils/jobutils"
+)
+
+func openDeletedDB(t *testing.T, n int) driver.IDB {
+	db, err := memkv.NewStore().Open("compaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for i := 0; i < n; i++ {
+		db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+	}
+	driver.DeleteRange(db, []byte("k000"), []byte(fmt.Sprintf("k%03d", n/2)))
+	return db
+}
+
+func numDeletes(t *testing.T, db driver.IDB) uint64 {
+	t.Helper()
+	n, err := driver.PropertyUint(db, driver.PropertyNumDeletes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 500 && !cond(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !cond() {
+		t.Fatal("wait timeout")
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	db := openDeletedDB(t, 100)
+	if n := numDeletes(t, db); n != 100 {
+		t.Fatalf("Got %d expected %d", n, 100)
+	}
+
+	driver.CompactRange(db, []byte("k000"), []byte("k010"))
+	if n := numDeletes(t, db); n != 80 {
+		t.Errorf("Got %d expected %d", n, 80)
+	}
+
+	// namespace range is isolated
+	ns := driver.Namespace(db, "ns")
+	ns.Put([]byte("a"), []byte("1"))
+	ns.Delete([]byte("a"))
+	driver.CompactRange(ns, nil, nil)
+	if n := numDeletes(t, db); n != 80 {
+		t.Errorf("Got %d expected %d", n, 80)
+	}
+
+	// fallback compact whole db
+	driver.CompactRange(&plainDB{db}, []byte("k000"), []byte("k010"))
+	if n := numDeletes(t, db); n != 0 {
+		t.Errorf("Got %d expected %d", n, 0)
+	}
+}
+
+func TestCompactionSchedulerTrigger(t *testing.T) {
+	db := openDeletedDB(t, 100)
+	runner := jobutils.NewRunner()
+	defer runner.Stop()
+
+	s := driver.NewCompactionScheduler(db, runner, driver.WithCompactionInterval(0), driver.WithCompactionChunkBytes(100))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	s.Trigger([]byte("k000"), []byte("k025"))
+	waitFor(t, func() bool { return s.Stats().Runs == 1 })
+	stats := s.Stats()
+	if n := numDeletes(t, db); n != 50 || stats.Triggers != 1 || stats.Running || stats.LastError != nil {
+		t.Errorf("Got deletes %d stats %+v", n, stats)
+	}
+}
+
+func TestCompactionSchedulerDensity(t *testing.T) {
+	db := openDeletedDB(t, 200)
+	runner := jobutils.NewRunner()
+	defer runner.Stop()
+
+	s := driver.NewCompactionScheduler(db, runner,
+		driver.WithCompactionInterval(10*time.Millisecond),
+		driver.WithCompactionTrigger(10, 0.3),
+		driver.WithCompactionChunkBytes(200),
+		driver.WithCompactionIOBudget(2000))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	waitFor(t, func() bool { return s.Stats().Runs >= 1 })
+	s.Stop()
+
+	// 100 live keys of 5 bytes and 100 deleted keys of 9 bytes (stale version and tombstone),
+	// 1400 bytes at 2000 bytes/s
+	if d := time.Since(start); d < 700*time.Millisecond {
+		t.Errorf("Got duration %v expected >= 700ms by io budget", d)
+	}
+	stats := s.Stats()
+	if n := numDeletes(t, db); n != 0 || stats.DensityTriggers == 0 || stats.CompactedBytes != 1400 || stats.CurrentDoneBytes != stats.CurrentTotalBytes {
+		t.Errorf("Got deletes %d stats %+v", n, stats)
+	}
+}
+
+func TestCompactionSchedulerDeletedRange(t *testing.T) {
+	db := openDeletedDB(t, 200)
+	driver.DeleteRange(db, nil, nil)
+	runner := jobutils.NewRunner()
+	defer runner.Stop()
+
+	s := driver.NewCompactionScheduler(db, runner,
+		driver.WithCompactionInterval(0),
+		driver.WithCompactionChunkBytes(300),
+		driver.WithCompactionIOBudget(6000))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	start := time.Now()
+	s.Trigger(nil, nil)
+	waitFor(t, func() bool { return s.Stats().Runs == 1 })
+
+	// no live keys, 200 deleted keys of 9 bytes, 1800 bytes at 6000 bytes/s,
+	// split to chunks of at most 300 bytes by tombstones
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("Got duration %v expected >= 300ms by io budget", d)
+	}
+	stats := s.Stats()
+	if n := numDeletes(t, db); n != 0 || stats.CompactedChunks < 6 || stats.CompactedBytes != 1800 ||
+		stats.CurrentTotalBytes != 1800 || stats.CurrentDoneBytes != 1800 || stats.LastError != nil {
+		t.Errorf("Got deletes %d stats %+v", n, stats)
+	}
+}
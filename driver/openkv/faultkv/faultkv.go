@@ -0,0 +1,390 @@
+package faultkv
+
+import (
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils"
+)
+
+// Store wrap the inner store, the opened dbs inject faults by plan
+type Store struct {
+	inner driver.IStore
+	plan  *Plan
+}
+
+func NewStore(inner driver.IStore, plan *Plan) *Store {
+	return &Store{inner: inner, plan: plan}
+}
+
+func (s *Store) String() string {
+	return s.Name()
+}
+
+// Name fault:<inner store name>
+func (s *Store) Name() string {
+	return "fault:" + s.inner.Name()
+}
+
+func (s *Store) Open(path string) (driver.IDB, error) {
+	db, err := s.inner.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(db, s.plan), nil
+}
+
+// OptionSpecs the options which inner store supports
+func (s *Store) OptionSpecs() []driver.OptionSpec {
+	return driver.GetStoreInfo(s.inner).Options
+}
+
+func (s *Store) OpenWithOptions(path string, opts ...option.Option) (driver.IDB, error) {
+	db, err := driver.OpenWithOptions(s.inner, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(db, s.plan), nil
+}
+
+func (s *Store) Repair(path string) error {
+	return s.inner.Repair(path)
+}
+
+// Wrap wrap db to inject faults by plan,
+// the iterators, write batches and snapshots from it are wrapped too.
+func Wrap(db driver.IDB, plan *Plan) driver.IDB {
+	return &DB{IDB: db, plan: plan}
+}
+
+type DB struct {
+	driver.IDB
+	plan *Plan
+}
+
+func (db *DB) Get(key []byte) ([]byte, error) {
+	if err := db.plan.check(OpGet, key).inject(); err != nil {
+		return nil, err
+	}
+	return db.IDB.Get(key)
+}
+
+func (db *DB) GetSlice(key []byte) (driver.ISlice, error) {
+	if err := db.plan.check(OpGet, key).inject(); err != nil {
+		return nil, err
+	}
+	if g, ok := db.IDB.(driver.ISliceGeter); ok {
+		return g.GetSlice(key)
+	}
+
+	v, err := db.IDB.Get(key)
+	if v == nil {
+		return nil, err
+	}
+	return driver.GoSlice(v), nil
+}
+
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return multiGet(db.plan, db.IDB, keys)
+}
+
+// multiGet inject fault of OpGet for each key
+func multiGet(plan *Plan, g driver.IGeter, keys [][]byte) ([][]byte, []error) {
+	values, errs := driver.MultiGet(g, keys)
+	for i, key := range keys {
+		if err := plan.check(OpGet, key).inject(); err != nil {
+			values[i], errs[i] = nil, err
+		}
+	}
+	return values, errs
+}
+
+func (db *DB) Put(key []byte, value []byte) error {
+	if err := db.plan.check(OpPut, key).inject(); err != nil {
+		return err
+	}
+	return db.IDB.Put(key, value)
+}
+
+func (db *DB) Delete(key []byte) error {
+	if err := db.plan.check(OpDelete, key).inject(); err != nil {
+		return err
+	}
+	return db.IDB.Delete(key)
+}
+
+func (db *DB) SyncPut(key []byte, value []byte) error {
+	if err := db.plan.check(OpSyncPut, key).inject(); err != nil {
+		return err
+	}
+	return db.IDB.SyncPut(key, value)
+}
+
+func (db *DB) SyncDelete(key []byte) error {
+	if err := db.plan.check(OpSyncDelete, key).inject(); err != nil {
+		return err
+	}
+	return db.IDB.SyncDelete(key)
+}
+
+func (db *DB) DeleteRange(start, end []byte) error {
+	if err := db.plan.check(OpDeleteRange, start, end).inject(); err != nil {
+		return err
+	}
+	return driver.DeleteRange(db.IDB, start, end)
+}
+
+func (db *DB) Merge(key, operand []byte) error {
+	if err := db.plan.check(OpMerge, key).inject(); err != nil {
+		return err
+	}
+	return driver.Merge(db.IDB, key, operand)
+}
+
+func (db *DB) ApproximateSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateTotalSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateCount(r driver.Range) (uint64, error) {
+	return driver.ApproximateCount(db.IDB, r)
+}
+
+func (db *DB) Property(name string) (string, error) {
+	return driver.Property(db.IDB, name)
+}
+
+func (db *DB) NewIterator() driver.IIterator {
+	return &Iterator{IIterator: db.IDB.NewIterator(), plan: db.plan}
+}
+
+func (db *DB) NewIteratorWithOptions(opts *driver.IteratorOptions) driver.IIterator {
+	return &Iterator{IIterator: driver.NewIteratorWithOptions(db.IDB, opts), plan: db.plan}
+}
+
+func (db *DB) NewWriteBatch() driver.IWriteBatch {
+	return &WriteBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db.IDB, plan: db.plan, ops: utils.NewBatchOpBuffer()}
+}
+
+func (db *DB) NewSnapshot() (driver.ISnapshot, error) {
+	if err := db.plan.check(OpNewSnapshot).inject(); err != nil {
+		return nil, err
+	}
+
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{ISnapshot: snap, plan: db.plan}, nil
+}
+
+func (db *DB) Compact() error {
+	if err := db.plan.check(OpCompact).inject(); err != nil {
+		return err
+	}
+	return db.IDB.Compact()
+}
+
+func (db *DB) CompactRange(start, end []byte) error {
+	if err := db.plan.check(OpCompact, start, end).inject(); err != nil {
+		return err
+	}
+	return driver.CompactRange(db.IDB, start, end)
+}
+
+// WriteBatch keep the ops to replay part of them for torn commit
+type WriteBatch struct {
+	driver.IWriteBatch
+	db   driver.IDB
+	plan *Plan
+	ops  *utils.BatchOpBuffer
+	// merge err, return from commit
+	err error
+}
+
+func (wb *WriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+	wb.IWriteBatch.Put(key, value)
+}
+
+func (wb *WriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+	wb.IWriteBatch.Delete(key)
+}
+
+func (wb *WriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+	driver.BatchDeleteRange(wb.db, wb.IWriteBatch, start, end)
+}
+
+func (wb *WriteBatch) Merge(key, operand []byte) {
+	if err := driver.BatchMerge(wb.IWriteBatch, key, operand); err != nil {
+		if wb.err == nil {
+			wb.err = err
+		}
+		return
+	}
+	wb.ops.Merge(append([]byte{}, key...), append([]byte{}, operand...))
+}
+
+func (wb *WriteBatch) keys() [][]byte {
+	keys := make([][]byte, 0, wb.ops.Len())
+	for e := wb.ops.FrontElement(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*utils.BatchOp).Key)
+	}
+	return keys
+}
+
+func (wb *WriteBatch) commit(op Op, commit func() error) error {
+	if wb.err != nil {
+		return wb.err
+	}
+	f := wb.plan.check(op, wb.keys()...)
+	if f == nil || f.kind == FaultSlow {
+		f.inject()
+		return commit()
+	}
+	if f.kind != FaultTornCommit {
+		return f.err
+	}
+
+	keep := f.tornKeep
+	if keep <= 0 || keep >= wb.ops.Len() {
+		keep = wb.ops.Len() / 2
+	}
+	torn := wb.db.NewWriteBatch()
+	defer torn.Close()
+	e := wb.ops.FrontElement()
+	for i := 0; i < keep; i++ {
+		bop := e.Value.(*utils.BatchOp)
+		switch bop.Type {
+		case utils.BatchOpTypePut:
+			torn.Put(bop.Key, bop.Value)
+		case utils.BatchOpTypeDel:
+			torn.Delete(bop.Key)
+		case utils.BatchOpTypeDelRange:
+			driver.BatchDeleteRange(wb.db, torn, bop.Key, bop.Value)
+		case utils.BatchOpTypeMerge:
+			driver.BatchMerge(torn, bop.Key, bop.Value)
+		}
+		e = e.Next()
+	}
+	if err := torn.Commit(); err != nil {
+		return err
+	}
+
+	return f.err
+}
+
+func (wb *WriteBatch) Commit() error {
+	return wb.commit(OpCommit, wb.IWriteBatch.Commit)
+}
+
+func (wb *WriteBatch) SyncCommit() error {
+	return wb.commit(OpSyncCommit, wb.IWriteBatch.SyncCommit)
+}
+
+func (wb *WriteBatch) Rollback() error {
+	wb.ops.Reset()
+	wb.err = nil
+	return wb.IWriteBatch.Rollback()
+}
+
+func (wb *WriteBatch) Close() {
+	wb.ops.Reset()
+	wb.IWriteBatch.Close()
+}
+
+type Snapshot struct {
+	driver.ISnapshot
+	plan *Plan
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if err := s.plan.check(OpGet, key).inject(); err != nil {
+		return nil, err
+	}
+	return s.ISnapshot.Get(key)
+}
+
+func (s *Snapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return multiGet(s.plan, s.ISnapshot, keys)
+}
+
+func (s *Snapshot) NewIterator() driver.IIterator {
+	return &Iterator{IIterator: s.ISnapshot.NewIterator(), plan: s.plan}
+}
+
+func (s *Snapshot) NewIteratorWithOptions(opts *driver.IteratorOptions) driver.IIterator {
+	return &Iterator{IIterator: driver.NewIteratorWithOptions(s.ISnapshot, opts), plan: s.plan}
+}
+
+// Iterator after error fault injected, the iterator is invalid and Error() return it,
+// the key of OpIterStep is the key which the step moves to
+type Iterator struct {
+	driver.IIterator
+	plan *Plan
+	err  error
+}
+
+func (it *Iterator) step(move func()) {
+	if it.err != nil {
+		return
+	}
+
+	move()
+	var key []byte
+	if it.IIterator.Valid() {
+		key = it.IIterator.Key()
+	}
+	it.err = it.plan.check(OpIterStep, key).inject()
+}
+
+func (it *Iterator) First() {
+	it.step(it.IIterator.First)
+}
+
+func (it *Iterator) Last() {
+	it.step(it.IIterator.Last)
+}
+
+func (it *Iterator) Seek(key []byte) {
+	it.step(func() { it.IIterator.Seek(key) })
+}
+
+func (it *Iterator) Next() {
+	it.step(it.IIterator.Next)
+}
+
+func (it *Iterator) Prev() {
+	it.step(it.IIterator.Prev)
+}
+
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.IIterator.Valid()
+}
+
+func (it *Iterator) Key() []byte {
+	if it.err != nil {
+		return nil
+	}
+	return it.IIterator.Key()
+}
+
+func (it *Iterator) Value() []byte {
+	if it.err != nil {
+		return nil
+	}
+	return it.IIterator.Value()
+}
+
+func (it *Iterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.IIterator.Error()
+}
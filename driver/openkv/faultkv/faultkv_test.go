@@ -0,0 +1,175 @@
+package faultkv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+type testStore struct {
+	*Store
+	mem *memkv.Store
+}
+
+func (s testStore) Destroy(path string) error {
+	return s.mem.Destroy(path)
+}
+
+func TestConformance(t *testing.T) {
+	plan, _ := NewPlan(0)
+	mem := memkv.NewStore()
+	openkvtest.RunConformance(t, testStore{NewStore(mem, plan), mem})
+}
+
+func openTestDB(t *testing.T, rules ...Rule) (driver.IDB, *Plan) {
+	plan, err := NewPlan(1, rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewStore(memkv.NewStore(), plan).Open("fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, plan
+}
+
+func TestErrorAfterN(t *testing.T) {
+	myErr := errors.New("disk full")
+	db, plan := openTestDB(t, Rule{Ops: WriteOps, AfterN: 3, Times: 2, Err: myErr})
+
+	for i := 0; i < 6; i++ {
+		err := db.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+		if (i == 3 || i == 4) != (err == myErr) {
+			t.Errorf("put %d Got error %v", i, err)
+		}
+	}
+	if v, _ := db.Get([]byte("k3")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+	if n := plan.Fired(0); n != 2 {
+		t.Errorf("Got fired %d expected %d", n, 2)
+	}
+}
+
+func TestKeyMatch(t *testing.T) {
+	db, _ := openTestDB(t, Rule{Ops: []Op{OpGet}, KeyMatch: "^slot:"})
+
+	db.Put([]byte("slot:1"), []byte("v"))
+	db.Put([]byte("data:1"), []byte("v"))
+	if _, err := db.Get([]byte("slot:1")); err != ErrInjected {
+		t.Errorf("Got %v expected %v", err, ErrInjected)
+	}
+	if v, err := db.Get([]byte("data:1")); err != nil || string(v) != "v" {
+		t.Errorf("Got %s %v expected v", v, err)
+	}
+}
+
+func TestTornCommit(t *testing.T) {
+	db, _ := openTestDB(t, Rule{Ops: []Op{OpCommit}, Kind: FaultTornCommit, TornKeep: 2, Times: 1})
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	for i := 0; i < 5; i++ {
+		wb.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+	}
+	if err := wb.Commit(); err != ErrInjected {
+		t.Fatalf("Got %v expected %v", err, ErrInjected)
+	}
+
+	it := db.NewIterator()
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		n++
+	}
+	it.Close()
+	if n != 2 {
+		t.Errorf("Got %d keys after torn commit expected %d", n, 2)
+	}
+
+	// retry commit
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get([]byte("k4")); string(v) != "v" {
+		t.Errorf("Got %s expected v", v)
+	}
+}
+
+func TestIteratorError(t *testing.T) {
+	db, _ := openTestDB(t, Rule{Ops: []Op{OpIterStep}, AfterN: 2})
+	for i := 0; i < 5; i++ {
+		db.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+	}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	it := snap.NewIterator()
+	defer it.Close()
+
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 2 || it.Error() != ErrInjected {
+		t.Errorf("Got %d keys error %v expected 2 keys and %v", n, it.Error(), ErrInjected)
+	}
+	// keep invalid after error
+	it.First()
+	if it.Valid() {
+		t.Errorf("Got valid iterator after error")
+	}
+}
+
+func TestSlowSync(t *testing.T) {
+	db, _ := openTestDB(t, Rule{Ops: SyncOps, Kind: FaultSlow, Delay: 20 * time.Millisecond})
+
+	start := time.Now()
+	if err := db.SyncPut([]byte("a"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Got sync put latency %v expected >= 20ms", d)
+	}
+	if v, _ := db.Get([]byte("a")); string(v) != "v" {
+		t.Errorf("Got %s expected v", v)
+	}
+}
+
+func TestProbability(t *testing.T) {
+	db, plan := openTestDB(t, Rule{Ops: []Op{OpPut}, Probability: 0.5})
+
+	errs := 0
+	for i := 0; i < 1000; i++ {
+		if db.Put([]byte("k"), []byte("v")) != nil {
+			errs++
+		}
+	}
+	if errs < 400 || errs > 600 || uint64(errs) != plan.Fired(0) {
+		t.Errorf("Got %d errors fired %d expected about 500", errs, plan.Fired(0))
+	}
+}
+
+func TestBatchMergeUnsupported(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	wb.Put([]byte("a"), []byte("1"))
+	driver.BatchMerge(wb, []byte("b"), []byte("1"))
+	if err := wb.Commit(); !errors.Is(err, driver.ErrMergeUnsupported) {
+		t.Errorf("Got %v expected %v", err, driver.ErrMergeUnsupported)
+	}
+	if v, _ := db.Get([]byte("a")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+}
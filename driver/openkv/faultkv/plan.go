@@ -0,0 +1,214 @@
+// Package faultkv fault-injection openkv engine for chaos testing,
+// wrap any IDB/IStore with a scripted fault plan which injects errors,
+// torn batch commits, slow ops and iterator errors.
+package faultkv
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/weedge/pkg/utils"
+)
+
+var ErrInjected = errors.New("faultkv: injected fault")
+
+// Op the op kind which faults inject to
+type Op int
+
+const (
+	OpGet Op = iota
+	OpPut
+	OpDelete
+	OpSyncPut
+	OpSyncDelete
+	OpDeleteRange
+	OpCommit
+	OpSyncCommit
+	OpIterStep
+	OpNewSnapshot
+	OpCompact
+	OpMerge
+)
+
+var (
+	// WriteOps ops which write to db
+	WriteOps = []Op{OpPut, OpDelete, OpSyncPut, OpSyncDelete, OpDeleteRange, OpCommit, OpSyncCommit, OpMerge}
+	// SyncOps ops which sync to stable disk
+	SyncOps = []Op{OpSyncPut, OpSyncDelete, OpSyncCommit}
+)
+
+// FaultKind how to inject the fault
+type FaultKind int
+
+const (
+	// FaultError return error without doing the op
+	FaultError FaultKind = iota
+	// FaultSlow sleep Delay then do the op
+	FaultSlow
+	// FaultTornCommit commit part of batch ops then return error,
+	// only for OpCommit/OpSyncCommit, others are same as FaultError
+	FaultTornCommit
+)
+
+// Rule fault rule, triggered when all the set conditions match
+type Rule struct {
+	// Ops match op kinds, empty is all ops
+	Ops []Op
+	// KeyMatch match key regexp, empty is all keys,
+	// batch commit matches if any op key matches
+	KeyMatch string
+	// AfterN trigger after the first N matched ops
+	AfterN uint64
+	// Times max trigger times, 0 is unlimited
+	Times uint64
+	// Probability trigger probability of matched ops (0, 1), 0 is always
+	Probability float64
+
+	Kind FaultKind
+	// Err injected error, default ErrInjected
+	Err error
+	// Delay of FaultSlow
+	Delay time.Duration
+	// TornKeep the number of ops which are committed by FaultTornCommit,
+	// 0 is half of the batch ops
+	TornKeep int
+}
+
+type rule struct {
+	Rule
+	ops     map[Op]bool
+	re      *regexp.Regexp
+	matched uint64
+	fired   uint64
+}
+
+// fault the triggered fault
+type fault struct {
+	kind     FaultKind
+	err      error
+	delay    time.Duration
+	tornKeep int
+}
+
+// Plan scripted fault plan, rules are checked in order,
+// the first triggered rule injects its fault.
+type Plan struct {
+	mu    sync.Mutex
+	rules []*rule
+	rnd   *rand.Rand
+}
+
+// NewPlan new fault plan, seed is for probability rules
+func NewPlan(seed int64, rules ...Rule) (*Plan, error) {
+	p := &Plan{rnd: rand.New(rand.NewSource(seed))}
+	for _, r := range rules {
+		if err := p.Add(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Add add a rule to the plan
+func (p *Plan) Add(r Rule) error {
+	re, err := utils.BuildMatchRegexp(r.KeyMatch)
+	if err != nil {
+		return err
+	}
+	if r.Err == nil {
+		r.Err = ErrInjected
+	}
+
+	nr := &rule{Rule: r, re: re, ops: map[Op]bool{}}
+	for _, op := range r.Ops {
+		nr.ops[op] = true
+	}
+
+	p.mu.Lock()
+	p.rules = append(p.rules, nr)
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Reset remove all rules
+func (p *Plan) Reset() {
+	p.mu.Lock()
+	p.rules = nil
+	p.mu.Unlock()
+}
+
+// Fired return the trigger times of the i-th rule
+func (p *Plan) Fired(i int) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i < 0 || i >= len(p.rules) {
+		return 0
+	}
+	return p.rules[i].fired
+}
+
+func (r *rule) match(op Op, keys [][]byte) bool {
+	if len(r.ops) > 0 && !r.ops[op] {
+		return false
+	}
+	if r.re == nil {
+		return true
+	}
+	for _, key := range keys {
+		if r.re.Match(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check return the fault of the first triggered rule, or nil
+func (p *Plan) check(op Op, keys ...[]byte) *fault {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, r := range p.rules {
+		if !r.match(op, keys) {
+			continue
+		}
+		r.matched++
+		if r.matched <= r.AfterN {
+			continue
+		}
+		if r.Times > 0 && r.fired >= r.Times {
+			continue
+		}
+		if r.Probability > 0 && p.rnd.Float64() >= r.Probability {
+			continue
+		}
+
+		r.fired++
+		return &fault{kind: r.Kind, err: r.Err, delay: r.Delay, tornKeep: r.TornKeep}
+	}
+
+	return nil
+}
+
+// inject sleep if slow fault, return error if error fault
+func (f *fault) inject() error {
+	if f == nil {
+		return nil
+	}
+	if f.kind == FaultSlow {
+		time.Sleep(f.delay)
+		return nil
+	}
+
+	return f.err
+}
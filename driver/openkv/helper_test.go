@@ -0,0 +1,69 @@
+package driver_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+// wrapStore open memory db wrapped by wrap, for conformance test
+type wrapStore struct {
+	*memkv.Store
+	wrap func(db driver.IDB) driver.IDB
+}
+
+// wrapDB close the root db when close
+type wrapDB struct {
+	driver.IDB
+	root driver.IDB
+}
+
+func (db *wrapDB) Close() error {
+	return db.root.Close()
+}
+
+func (s wrapStore) Open(path string) (driver.IDB, error) {
+	root, err := s.Store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrapDB{IDB: s.wrap(root), root: root}, nil
+}
+
+func iterKeyValues(db driver.IDB) string {
+	it := db.NewIterator()
+	defer it.Close()
+
+	kvs := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+	}
+	return fmt.Sprint(kvs)
+}
+
+// testBatchMergeUnsupported wrapper batch of db without merge must fail commit
+func testBatchMergeUnsupported(t *testing.T, db driver.IDB) {
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	wb.Put([]byte("a"), []byte("1"))
+	driver.BatchMerge(wb, []byte("b"), []byte("1"))
+	if err := wb.Commit(); !errors.Is(err, driver.ErrMergeUnsupported) {
+		t.Errorf("Got %v expected %v", err, driver.ErrMergeUnsupported)
+	}
+	if v, _ := db.Get([]byte("a")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+
+	wb.Rollback()
+	wb.Put([]byte("a"), []byte("1"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+}
@@ -1,5 +1,9 @@
 package driver
 
+import (
+	"io"
+)
+
 type IDB interface {
 	Close() error
 
@@ -19,3 +23,57 @@ type IDB interface {
 
 	Compact() error
 }
+
+// IRangeDeleter interface for engine which can delete keys in [start, end) natively,
+// eg: range tombstones, nil or empty start/end is unbounded
+type IRangeDeleter interface {
+	DeleteRange(start, end []byte) error
+}
+
+// INamespacer interface for engine which has native keyspace namespaces,
+// eg: rocksdb column family; the ops of namespace batches/snapshots
+// which are from the same root batch/snapshot are atomic/consistent.
+type INamespacer interface {
+	// Namespace return the db view of namespace keyspace
+	Namespace(name string) IDB
+	// NamespaceWriteBatch return the batch view of namespace, ops write to wb
+	NamespaceWriteBatch(wb IWriteBatch, name string) IWriteBatch
+	// NamespaceSnapshot return the snapshot view of namespace, read from snap
+	NamespaceSnapshot(snap ISnapshot, name string) ISnapshot
+	// NamespaceTxn return the txn view of namespace, ops go to txn
+	NamespaceTxn(txn ITxn, name string) ITxn
+}
+
+// ICheckpointer interface for engine which can create an openable checkpoint
+// of db in dir online, eg: rocksdb checkpoint (hard link sst files)
+type ICheckpointer interface {
+	Checkpoint(dir string) error
+}
+
+// IBackuper interface for engine which can stream a consistent backup online,
+// the backup is restored by the same engine IRestorer
+type IBackuper interface {
+	Backup(w io.Writer) error
+}
+
+// IMerger interface for db which can merge operand to key by merge operator,
+// engine native merge operator or WithMergeOperator
+type IMerger interface {
+	Merge(key, operand []byte) error
+}
+
+// IGeter db or snapshot which can get value of key
+type IGeter interface {
+	Get(key []byte) ([]byte, error)
+}
+
+// IMultiGeter interface for db/snapshot which can get values of keys in one batched lookup,
+// values[i]/errs[i] is the result of keys[i], not exists value is nil
+type IMultiGeter interface {
+	MultiGet(keys [][]byte) (values [][]byte, errs []error)
+}
+
+// ICompactRanger interface for engine which can compact keys in [start, end) natively
+type ICompactRanger interface {
+	CompactRange(start, end []byte) error
+}
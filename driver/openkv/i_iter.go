@@ -17,3 +17,33 @@ type IIterator interface {
 
 	Error() error
 }
+
+// IteratorOptions iterator read options, nil or empty bound is unbounded
+type IteratorOptions struct {
+	// LowerBound inclusive lower bound key
+	LowerBound []byte
+	// UpperBound exclusive upper bound key
+	UpperBound []byte
+	// Prefix only iterate keys with the prefix, intersect with bounds
+	Prefix []byte
+	// Reverse iterate in reverse order, First start from the last key,
+	// Next move to the previous key, Seek(key) move to the last key <= key
+	Reverse bool
+
+	// hints for engine, generic wrapper ignore them
+
+	// DontFillCache don't fill the block cache by the iterator reads, for bulk scan
+	DontFillCache bool
+	// ReadaheadSize readahead bytes for bulk scan, 0 is engine default
+	ReadaheadSize int
+}
+
+// IIteratorCreator db/snapshot which can new iterator
+type IIteratorCreator interface {
+	NewIterator() IIterator
+}
+
+// IIteratorOptioner interface for engine which can push down iterator options
+type IIteratorOptioner interface {
+	NewIteratorWithOptions(opts *IteratorOptions) IIterator
+}
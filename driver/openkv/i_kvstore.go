@@ -2,6 +2,7 @@ package driver
 
 import (
 	"fmt"
+	"io"
 )
 
 type IStore interface {
@@ -10,6 +11,11 @@ type IStore interface {
 	Repair(path string) error
 }
 
+// IRestorer interface for store which can restore its native backup to path
+type IRestorer interface {
+	Restore(r io.Reader, path string) error
+}
+
 var dbs = map[string]IStore{}
 
 func Register(s IStore) error {
@@ -25,3 +25,9 @@ func (s GoSlice) Free() {
 type ISliceGeter interface {
 	GetSlice(key []byte) (ISlice, error)
 }
+
+// IMultiSliceGeter interface for use cgo leveldb/rocksdb lib batched slice get op (zero-copy),
+// slices[i]/errs[i] is the result of keys[i], not exists slice is nil, free slices after used
+type IMultiSliceGeter interface {
+	MultiGetSlice(keys [][]byte) (slices []ISlice, errs []error)
+}
@@ -14,3 +14,13 @@ type IWriteBatch interface {
 	// close WriteBatch
 	Close()
 }
+
+// IWriteBatchRangeDeleter interface for write batch which can delete keys in [start, end) natively
+type IWriteBatchRangeDeleter interface {
+	DeleteRange(start, end []byte)
+}
+
+// IWriteBatchMerger interface for write batch which can merge operand to key
+type IWriteBatchMerger interface {
+	Merge(key, operand []byte)
+}
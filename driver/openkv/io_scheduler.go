@@ -0,0 +1,609 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weedge/pkg/metadata"
+	"github.com/weedge/pkg/option"
+)
+
+// IOPriority the io class of calls to db
+type IOPriority int
+
+const (
+	IOPriorityForeground IOPriority = iota
+	IOPriorityMigration
+	IOPriorityFullSync
+	IOPriorityCompaction
+	IOPriorityBackground
+	ioPriorityNum
+)
+
+var ioPriorityNames = [ioPriorityNum]string{
+	"foreground", "migration", "full_sync", "compaction", "background",
+}
+
+func (p IOPriority) String() string {
+	if p < 0 || p >= ioPriorityNum {
+		return "unknown"
+	}
+	return ioPriorityNames[p]
+}
+
+func ParseIOPriority(name string) (IOPriority, error) {
+	for i, n := range ioPriorityNames {
+		if strings.EqualFold(n, name) {
+			return IOPriority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown io priority %s", name)
+}
+
+// MetadataKeyIOPriority metadata key of io priority in context,
+// value is IOPriority or its name
+const MetadataKeyIOPriority = "openkv_io_priority"
+
+// IOPriorityFromContext get io priority from metadata in context
+func IOPriorityFromContext(ctx context.Context) (IOPriority, bool) {
+	switch v := metadata.Value(ctx, MetadataKeyIOPriority).(type) {
+	case IOPriority:
+		return v, v >= 0 && v < ioPriorityNum
+	case string:
+		p, err := ParseIOPriority(v)
+		return p, err == nil
+	}
+	return IOPriorityForeground, false
+}
+
+// NewIOPriorityContext return a context with io priority in a copy of its metadata
+func NewIOPriorityContext(ctx context.Context, p IOPriority) context.Context {
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	md.Set(MetadataKeyIOPriority, p)
+	return metadata.NewContext(ctx, md)
+}
+
+// IContextBinder db which classify calls by the context bound to it
+type IContextBinder interface {
+	WithContext(ctx context.Context) IDB
+}
+
+// WithContext bind ctx to db if db is IContextBinder, else return db
+func WithContext(db IDB, ctx context.Context) IDB {
+	if b, ok := db.(IContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+
+	return db
+}
+
+// IOLimit token bucket limit of one io class, 0 is unlimited
+type IOLimit struct {
+	BytesPerSec int64 `mapstructure:"bytesPerSec"`
+	OpsPerSec   int64 `mapstructure:"opsPerSec"`
+	// Burst bytes of bucket, default is BytesPerSec
+	BurstBytes int64 `mapstructure:"burstBytes"`
+}
+
+// IOSchedulerOptions io scheduler options
+type IOSchedulerOptions struct {
+	// Limits io limits by io priority name, foreground is never limited
+	Limits map[string]IOLimit `mapstructure:"limits"`
+}
+
+func DefaultIOSchedulerOptions() *IOSchedulerOptions {
+	return &IOSchedulerOptions{Limits: map[string]IOLimit{}}
+}
+
+func (o *IOSchedulerOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithIOLimit(p IOPriority, limit IOLimit) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*IOSchedulerOptions)
+		if !ok {
+			return
+		}
+		if o.Limits == nil {
+			o.Limits = map[string]IOLimit{}
+		}
+		o.Limits[p.String()] = limit
+	})
+}
+
+// WithIOSchedulerOptions use opts, eg: unmarshaled from config
+func WithIOSchedulerOptions(opts IOSchedulerOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*IOSchedulerOptions)
+		if !ok {
+			return
+		}
+		*o = opts
+	})
+}
+
+// tokenBucket reserve tokens in debt, the caller waits until the debt is paid,
+// so a reservation larger than burst is allowed
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve take n tokens, return the duration to wait until the debt is paid,
+// reserve 0 tokens waits for the debt of reads charged before
+func (b *tokenBucket) reserve(n int64) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type ioLimiter struct {
+	limit IOLimit
+	bytes *tokenBucket
+	ops   *tokenBucket
+}
+
+type ioClassStat struct {
+	ops, bytes, throttled, waitNs atomic.Uint64
+}
+
+// IOClassStats io stats of one priority class
+type IOClassStats struct {
+	Priority IOPriority
+	Limit    IOLimit
+	Ops      uint64
+	Bytes    uint64
+	// Throttled calls which waited for tokens
+	Throttled uint64
+	Wait      time.Duration
+}
+
+// IOScheduler classify calls to db by io priority, and limit background classes
+// (eg: slot migration, full sync snapshot streaming, compaction) by token buckets,
+// so foreground latency stays flat.
+// priority is from the metadata of context bound by WithContext, or explicit by WithPriority,
+// calls on the scheduler itself bypass it.
+// reads are charged after they are done, so the next call of the class pays for them.
+type IOScheduler struct {
+	IDB
+	limiters [ioPriorityNum]atomic.Pointer[ioLimiter]
+	stats    [ioPriorityNum]ioClassStat
+}
+
+func NewIOScheduler(db IDB, opts ...option.Option) (*IOScheduler, error) {
+	o := DefaultIOSchedulerOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+
+	s := &IOScheduler{IDB: db}
+	for name, limit := range o.Limits {
+		p, err := ParseIOPriority(name)
+		if err != nil {
+			return nil, err
+		}
+		s.SetLimit(p, limit)
+	}
+
+	return s, nil
+}
+
+// SetLimit set the limit of io class online, limit of foreground is ignored
+func (s *IOScheduler) SetLimit(p IOPriority, limit IOLimit) {
+	if p <= IOPriorityForeground || p >= ioPriorityNum {
+		return
+	}
+	s.limiters[p].Store(&ioLimiter{
+		limit: limit,
+		bytes: newTokenBucket(limit.BytesPerSec, limit.BurstBytes),
+		ops:   newTokenBucket(limit.OpsPerSec, 0),
+	})
+}
+
+// WithPriority return db view whose calls are in io class p
+func (s *IOScheduler) WithPriority(p IOPriority) IDB {
+	if p < 0 || p >= ioPriorityNum {
+		p = IOPriorityBackground
+	}
+	return &prioDB{IDB: s.IDB, s: s, p: p, ctx: context.Background()}
+}
+
+// WithContext return db view whose calls are in io class of the priority in ctx metadata,
+// default is foreground, waiting for tokens returns ctx error if ctx is done.
+func (s *IOScheduler) WithContext(ctx context.Context) IDB {
+	p, _ := IOPriorityFromContext(ctx)
+	return &prioDB{IDB: s.IDB, s: s, p: p, ctx: ctx}
+}
+
+// Stats return io stats of all classes
+func (s *IOScheduler) Stats() []IOClassStats {
+	stats := make([]IOClassStats, 0, ioPriorityNum)
+	for i := range s.stats {
+		st := &s.stats[i]
+		cs := IOClassStats{
+			Priority:  IOPriority(i),
+			Ops:       st.ops.Load(),
+			Bytes:     st.bytes.Load(),
+			Throttled: st.throttled.Load(),
+			Wait:      time.Duration(st.waitNs.Load()),
+		}
+		if l := s.limiters[i].Load(); l != nil {
+			cs.Limit = l.limit
+		}
+		stats = append(stats, cs)
+	}
+
+	return stats
+}
+
+// acquire charge ops and bytes to class p, and wait for the tokens
+func (s *IOScheduler) acquire(ctx context.Context, p IOPriority, ops, bytes int64) error {
+	st := &s.stats[p]
+	st.ops.Add(uint64(ops))
+	st.bytes.Add(uint64(bytes))
+
+	l := s.limiters[p].Load()
+	if l == nil {
+		return nil
+	}
+	wait := l.ops.reserve(ops)
+	if d := l.bytes.reserve(bytes); d > wait {
+		wait = d
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	st.throttled.Add(1)
+	st.waitNs.Add(uint64(wait))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// charge charge read bytes to class p without waiting
+func (s *IOScheduler) charge(p IOPriority, bytes int64) {
+	s.stats[p].bytes.Add(uint64(bytes))
+	if l := s.limiters[p].Load(); l != nil {
+		l.bytes.reserve(bytes)
+	}
+}
+
+// prioDB db view of one io class
+type prioDB struct {
+	IDB
+	s   *IOScheduler
+	p   IOPriority
+	ctx context.Context
+}
+
+func (db *prioDB) acquire(ops, bytes int) error {
+	return db.s.acquire(db.ctx, db.p, int64(ops), int64(bytes))
+}
+
+func (db *prioDB) Get(key []byte) ([]byte, error) {
+	if err := db.acquire(1, len(key)); err != nil {
+		return nil, err
+	}
+	v, err := db.IDB.Get(key)
+	db.s.charge(db.p, int64(len(v)))
+	return v, err
+}
+
+func (db *prioDB) GetSlice(key []byte) (ISlice, error) {
+	g, ok := db.IDB.(ISliceGeter)
+	if !ok {
+		v, err := db.Get(key)
+		if v == nil {
+			return nil, err
+		}
+		return GoSlice(v), nil
+	}
+
+	if err := db.acquire(1, len(key)); err != nil {
+		return nil, err
+	}
+	s, err := g.GetSlice(key)
+	if s != nil {
+		db.s.charge(db.p, int64(s.Size()))
+	}
+	return s, err
+}
+
+func (db *prioDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return prioMultiGet(db, db.IDB, keys)
+}
+
+func prioMultiGet(db *prioDB, g IGeter, keys [][]byte) ([][]byte, []error) {
+	n := 0
+	for _, key := range keys {
+		n += len(key)
+	}
+	if err := db.acquire(len(keys), n); err != nil {
+		errs := make([]error, len(keys))
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([][]byte, len(keys)), errs
+	}
+
+	values, errs := MultiGet(g, keys)
+	n = 0
+	for _, v := range values {
+		n += len(v)
+	}
+	db.s.charge(db.p, int64(n))
+	return values, errs
+}
+
+func (db *prioDB) Put(key []byte, value []byte) error {
+	if err := db.acquire(1, len(key)+len(value)); err != nil {
+		return err
+	}
+	return db.IDB.Put(key, value)
+}
+
+func (db *prioDB) SyncPut(key []byte, value []byte) error {
+	if err := db.acquire(1, len(key)+len(value)); err != nil {
+		return err
+	}
+	return db.IDB.SyncPut(key, value)
+}
+
+func (db *prioDB) Delete(key []byte) error {
+	if err := db.acquire(1, len(key)); err != nil {
+		return err
+	}
+	return db.IDB.Delete(key)
+}
+
+func (db *prioDB) SyncDelete(key []byte) error {
+	if err := db.acquire(1, len(key)); err != nil {
+		return err
+	}
+	return db.IDB.SyncDelete(key)
+}
+
+func (db *prioDB) DeleteRange(start, end []byte) error {
+	if err := db.acquire(1, len(start)+len(end)); err != nil {
+		return err
+	}
+	return DeleteRange(db.IDB, start, end)
+}
+
+func (db *prioDB) Merge(key, operand []byte) error {
+	if err := db.acquire(1, len(key)+len(operand)); err != nil {
+		return err
+	}
+	return Merge(db.IDB, key, operand)
+}
+
+func (db *prioDB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *prioDB) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *prioDB) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(db.IDB, r)
+}
+
+func (db *prioDB) Property(name string) (string, error) {
+	return Property(db.IDB, name)
+}
+
+func (db *prioDB) NewIterator() IIterator {
+	return &prioIterator{IIterator: db.IDB.NewIterator(), db: db}
+}
+
+func (db *prioDB) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return &prioIterator{IIterator: NewIteratorWithOptions(db.IDB, opts), db: db}
+}
+
+func (db *prioDB) NewWriteBatch() IWriteBatch {
+	return &prioWriteBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db}
+}
+
+func (db *prioDB) NewSnapshot() (ISnapshot, error) {
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &prioSnapshot{ISnapshot: snap, db: db}, nil
+}
+
+func (db *prioDB) Compact() error {
+	if err := db.acquire(1, 0); err != nil {
+		return err
+	}
+	return db.IDB.Compact()
+}
+
+func (db *prioDB) CompactRange(start, end []byte) error {
+	if err := db.acquire(1, 0); err != nil {
+		return err
+	}
+	return CompactRange(db.IDB, start, end)
+}
+
+type prioWriteBatch struct {
+	IWriteBatch
+	db    *prioDB
+	ops   int
+	bytes int
+	// merge err, return from commit
+	err error
+}
+
+func (wb *prioWriteBatch) Put(key []byte, value []byte) {
+	wb.ops++
+	wb.bytes += len(key) + len(value)
+	wb.IWriteBatch.Put(key, value)
+}
+
+func (wb *prioWriteBatch) Delete(key []byte) {
+	wb.ops++
+	wb.bytes += len(key)
+	wb.IWriteBatch.Delete(key)
+}
+
+func (wb *prioWriteBatch) DeleteRange(start, end []byte) {
+	wb.ops++
+	wb.bytes += len(start) + len(end)
+	BatchDeleteRange(wb.db.IDB, wb.IWriteBatch, start, end)
+}
+
+func (wb *prioWriteBatch) Merge(key, operand []byte) {
+	wb.ops++
+	wb.bytes += len(key) + len(operand)
+	if err := BatchMerge(wb.IWriteBatch, key, operand); err != nil && wb.err == nil {
+		wb.err = err
+	}
+}
+
+func (wb *prioWriteBatch) Commit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	if err := wb.db.acquire(wb.ops, wb.bytes); err != nil {
+		return err
+	}
+	return wb.IWriteBatch.Commit()
+}
+
+func (wb *prioWriteBatch) SyncCommit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	if err := wb.db.acquire(wb.ops, wb.bytes); err != nil {
+		return err
+	}
+	return wb.IWriteBatch.SyncCommit()
+}
+
+func (wb *prioWriteBatch) Rollback() error {
+	wb.ops, wb.bytes, wb.err = 0, 0, nil
+	return wb.IWriteBatch.Rollback()
+}
+
+type prioSnapshot struct {
+	ISnapshot
+	db *prioDB
+}
+
+func (s *prioSnapshot) Get(key []byte) ([]byte, error) {
+	if err := s.db.acquire(1, len(key)); err != nil {
+		return nil, err
+	}
+	v, err := s.ISnapshot.Get(key)
+	s.db.s.charge(s.db.p, int64(len(v)))
+	return v, err
+}
+
+func (s *prioSnapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return prioMultiGet(s.db, s.ISnapshot, keys)
+}
+
+func (s *prioSnapshot) NewIterator() IIterator {
+	return &prioIterator{IIterator: s.ISnapshot.NewIterator(), db: s.db}
+}
+
+func (s *prioSnapshot) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return &prioIterator{IIterator: NewIteratorWithOptions(s.ISnapshot, opts), db: s.db}
+}
+
+// prioIterator wait for tokens before each step, the error of waiting is sticky
+type prioIterator struct {
+	IIterator
+	db  *prioDB
+	err error
+}
+
+func (it *prioIterator) step(fn func()) {
+	if it.err != nil {
+		return
+	}
+	if it.err = it.db.acquire(1, 0); it.err != nil {
+		return
+	}
+	fn()
+	if it.IIterator.Valid() {
+		it.db.s.charge(it.db.p, int64(len(it.IIterator.Key())+len(it.IIterator.Value())))
+	}
+}
+
+func (it *prioIterator) First() {
+	it.step(it.IIterator.First)
+}
+
+func (it *prioIterator) Last() {
+	it.step(it.IIterator.Last)
+}
+
+func (it *prioIterator) Seek(key []byte) {
+	it.step(func() { it.IIterator.Seek(key) })
+}
+
+func (it *prioIterator) Next() {
+	it.step(it.IIterator.Next)
+}
+
+func (it *prioIterator) Prev() {
+	it.step(it.IIterator.Prev)
+}
+
+func (it *prioIterator) Valid() bool {
+	return it.err == nil && it.IIterator.Valid()
+}
+
+func (it *prioIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.IIterator.Error()
+}
@@ -0,0 +1,125 @@
+package driver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+	"github.com/weedge/pkg/metadata"
+)
+
+func TestIOSchedulerConformance(t *testing.T) {
+	openkvtest.RunConformance(t, wrapStore{memkv.NewStore(), func(root driver.IDB) driver.IDB {
+		s, _ := driver.NewIOScheduler(root, driver.WithIOLimit(driver.IOPriorityMigration, driver.IOLimit{BytesPerSec: 1 << 30}))
+		return s.WithPriority(driver.IOPriorityMigration)
+	}})
+}
+
+func TestIOPriorityFromContext(t *testing.T) {
+	if p, ok := driver.IOPriorityFromContext(context.Background()); ok || p != driver.IOPriorityForeground {
+		t.Errorf("Got %v %v expected %v", p, ok, driver.IOPriorityForeground)
+	}
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(driver.MetadataKeyIOPriority, "full_sync"))
+	if p, ok := driver.IOPriorityFromContext(ctx); !ok || p != driver.IOPriorityFullSync {
+		t.Errorf("Got %v %v expected %v", p, ok, driver.IOPriorityFullSync)
+	}
+	ctx = driver.NewIOPriorityContext(ctx, driver.IOPriorityCompaction)
+	if p, ok := driver.IOPriorityFromContext(ctx); !ok || p != driver.IOPriorityCompaction {
+		t.Errorf("Got %v %v expected %v", p, ok, driver.IOPriorityCompaction)
+	}
+	if _, err := driver.NewIOScheduler(nil, driver.WithIOSchedulerOptions(driver.IOSchedulerOptions{
+		Limits: map[string]driver.IOLimit{"unknown": {}},
+	})); err == nil {
+		t.Error("Got nil expected unknown io priority error")
+	}
+}
+
+func TestIOSchedulerLimit(t *testing.T) {
+	db, _ := memkv.NewStore().Open("io")
+	defer db.Close()
+	s, err := driver.NewIOScheduler(db, driver.WithIOLimit(driver.IOPriorityMigration, driver.IOLimit{BytesPerSec: 10000, BurstBytes: 1000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(db driver.IDB) time.Duration {
+		start := time.Now()
+		for i := 0; i < 30; i++ {
+			if err := db.Put([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 94)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return time.Since(start)
+	}
+
+	// 3000 bytes, burst 1000 bytes, 10000 bytes/s
+	if d := write(s.WithPriority(driver.IOPriorityMigration)); d < 150*time.Millisecond {
+		t.Errorf("migration Got %v expected >= 150ms", d)
+	}
+	if d := write(s.WithContext(context.Background())); d > 100*time.Millisecond {
+		t.Errorf("foreground Got %v expected no throttle", d)
+	}
+
+	stats := s.Stats()
+	fg, mig := stats[driver.IOPriorityForeground], stats[driver.IOPriorityMigration]
+	if fg.Ops != 30 || fg.Bytes != 3000 || fg.Throttled != 0 {
+		t.Errorf("foreground Got %+v", fg)
+	}
+	if mig.Ops != 30 || mig.Bytes != 3000 || mig.Throttled == 0 || mig.Limit.BytesPerSec != 10000 {
+		t.Errorf("migration Got %+v", mig)
+	}
+
+	// reads are charged after done, the scan of migration is throttled
+	s.SetLimit(driver.IOPriorityMigration, driver.IOLimit{BytesPerSec: 1000})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	it := driver.WithContext(s, driver.NewIOPriorityContext(ctx, driver.IOPriorityMigration)).NewIterator()
+	defer it.Close()
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		n++
+	}
+	if n >= 30 || !errors.Is(it.Error(), context.DeadlineExceeded) {
+		t.Errorf("Got %d keys error %v expected %v", n, it.Error(), context.DeadlineExceeded)
+	}
+}
+
+func TestIOSchedulerOpsLimit(t *testing.T) {
+	db, _ := memkv.NewStore().Open("io_ops")
+	defer db.Close()
+	s, _ := driver.NewIOScheduler(db, driver.WithIOLimit(driver.IOPriorityCompaction, driver.IOLimit{OpsPerSec: 1}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	cdb := s.WithContext(driver.NewIOPriorityContext(ctx, driver.IOPriorityCompaction))
+	if err := cdb.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cdb.Get([]byte("a")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	// plain db without scheduler is not bound
+	if driver.WithContext(db, ctx) != db {
+		t.Error("Got bound db expected the db")
+	}
+}
+
+func TestIOSchedulerBatchMergeUnsupported(t *testing.T) {
+	db, err := memkv.NewStore().Open("io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s, err := driver.NewIOScheduler(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBatchMergeUnsupported(t, s.WithPriority(driver.IOPriorityMigration))
+}
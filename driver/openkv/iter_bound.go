@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"bytes"
+)
+
+// NewIteratorWithOptions new iterator with options from db or snapshot,
+// use engine native options if it is IIteratorOptioner,
+// else wrap the iterator to enforce the options.
+func NewIteratorWithOptions(c IIteratorCreator, opts *IteratorOptions) IIterator {
+	if o, ok := c.(IIteratorOptioner); ok {
+		return o.NewIteratorWithOptions(opts)
+	}
+
+	return NewBoundedIterator(c.NewIterator(), opts)
+}
+
+// NewBoundedIterator wrap any iterator to enforce bounds, prefix and reverse options
+func NewBoundedIterator(it IIterator, opts *IteratorOptions) IIterator {
+	if opts == nil {
+		return it
+	}
+
+	var lower, upper []byte
+	if len(opts.LowerBound) > 0 {
+		lower = opts.LowerBound
+	}
+	if len(opts.UpperBound) > 0 {
+		upper = opts.UpperBound
+	}
+	if len(opts.Prefix) > 0 {
+		if lower == nil || bytes.Compare(lower, opts.Prefix) < 0 {
+			lower = opts.Prefix
+		}
+		if pu := PrefixUpperBound(opts.Prefix); pu != nil &&
+			(upper == nil || bytes.Compare(pu, upper) < 0) {
+			upper = pu
+		}
+	}
+
+	var bit IIterator = it
+	if lower != nil || upper != nil {
+		bit = &boundedIterator{IIterator: it, lower: lower, upper: upper}
+	}
+	if opts.Reverse {
+		bit = &reverseIterator{IIterator: bit}
+	}
+
+	return bit
+}
+
+// PrefixUpperBound return the smallest key which is greater than all keys with the prefix,
+// return nil if not exists (prefix is empty or all 0xff)
+func PrefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upper := append([]byte{}, prefix[:i+1]...)
+			upper[i]++
+			return upper
+		}
+	}
+
+	return nil
+}
+
+// boundedIterator iterate keys in [lower, upper)
+type boundedIterator struct {
+	IIterator
+	lower []byte
+	upper []byte
+}
+
+func (it *boundedIterator) First() {
+	if it.lower != nil {
+		it.IIterator.Seek(it.lower)
+		return
+	}
+	it.IIterator.First()
+}
+
+func (it *boundedIterator) Last() {
+	if it.upper == nil {
+		it.IIterator.Last()
+		return
+	}
+
+	it.IIterator.Seek(it.upper)
+	if it.IIterator.Valid() {
+		it.IIterator.Prev()
+		return
+	}
+	it.IIterator.Last()
+}
+
+func (it *boundedIterator) Seek(key []byte) {
+	if it.lower != nil && bytes.Compare(key, it.lower) < 0 {
+		key = it.lower
+	}
+	it.IIterator.Seek(key)
+}
+
+func (it *boundedIterator) Next() {
+	if it.Valid() {
+		it.IIterator.Next()
+	}
+}
+
+func (it *boundedIterator) Prev() {
+	if it.Valid() {
+		it.IIterator.Prev()
+	}
+}
+
+func (it *boundedIterator) Valid() bool {
+	if !it.IIterator.Valid() {
+		return false
+	}
+
+	key := it.IIterator.Key()
+	if it.lower != nil && bytes.Compare(key, it.lower) < 0 {
+		return false
+	}
+	if it.upper != nil && bytes.Compare(key, it.upper) >= 0 {
+		return false
+	}
+
+	return true
+}
+
+func (it *boundedIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.IIterator.Key()
+}
+
+func (it *boundedIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.IIterator.Value()
+}
+
+// reverseIterator iterate in reverse order
+type reverseIterator struct {
+	IIterator
+}
+
+func (it *reverseIterator) First() {
+	it.IIterator.Last()
+}
+
+func (it *reverseIterator) Last() {
+	it.IIterator.First()
+}
+
+// Seek move to the last key <= key
+func (it *reverseIterator) Seek(key []byte) {
+	it.IIterator.Seek(key)
+	if !it.IIterator.Valid() {
+		// all keys are less than key
+		it.IIterator.Last()
+		return
+	}
+	if bytes.Compare(it.IIterator.Key(), key) > 0 {
+		it.IIterator.Prev()
+	}
+}
+
+func (it *reverseIterator) Next() {
+	it.IIterator.Prev()
+}
+
+func (it *reverseIterator) Prev() {
+	it.IIterator.Next()
+}
@@ -0,0 +1,103 @@
+package driver_test
+
+import (
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+func iterKeys(it driver.IIterator) string {
+	keys := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return fmt.Sprint(keys)
+}
+
+func TestNewIteratorWithOptions(t *testing.T) {
+	db, err := memkv.NewStore().Open("iter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"a", "b", "ba", "bb", "b\xff", "c", "d"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	tests := []struct {
+		name string
+		opts *driver.IteratorOptions
+		want string
+	}{
+		{"nil", nil, "[a b ba bb b\xff c d]"},
+		{"lower", &driver.IteratorOptions{LowerBound: []byte("bb")}, "[bb b\xff c d]"},
+		{"upper", &driver.IteratorOptions{UpperBound: []byte("c")}, "[a b ba bb b\xff]"},
+		{"range", &driver.IteratorOptions{LowerBound: []byte("az"), UpperBound: []byte("bb")}, "[b ba]"},
+		{"prefix", &driver.IteratorOptions{Prefix: []byte("b")}, "[b ba bb b\xff]"},
+		{"prefix&bound", &driver.IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("ba"), UpperBound: []byte("z")}, "[ba bb b\xff]"},
+		{"reverse", &driver.IteratorOptions{Reverse: true}, "[d c b\xff bb ba b a]"},
+		{"reverse prefix", &driver.IteratorOptions{Prefix: []byte("b"), Reverse: true}, "[b\xff bb ba b]"},
+		{"empty", &driver.IteratorOptions{LowerBound: []byte("x")}, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := driver.NewIteratorWithOptions(db, tt.opts)
+			defer it.Close()
+			if got := iterKeys(it); got != tt.want {
+				t.Errorf("Got %q expected %q", got, tt.want)
+			}
+		})
+	}
+
+	it := driver.NewIteratorWithOptions(db, &driver.IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("c")})
+	defer it.Close()
+	it.Last()
+	if string(it.Key()) != "b\xff" {
+		t.Errorf("Last Got %q expected %q", it.Key(), "b\xff")
+	}
+	it.Seek([]byte("a"))
+	if string(it.Key()) != "b" {
+		t.Errorf("Seek Got %q expected %q", it.Key(), "b")
+	}
+	it.Prev()
+	if it.Valid() {
+		t.Errorf("Prev Got %q expected invalid", it.Key())
+	}
+	it.Seek([]byte("c"))
+	if it.Valid() {
+		t.Errorf("Seek Got %q expected invalid", it.Key())
+	}
+
+	rit := driver.NewIteratorWithOptions(db, &driver.IteratorOptions{UpperBound: []byte("c"), Reverse: true})
+	defer rit.Close()
+	rit.Seek([]byte("bab"))
+	if string(rit.Key()) != "ba" {
+		t.Errorf("reverse Seek Got %q expected %q", rit.Key(), "ba")
+	}
+	rit.Seek([]byte("z"))
+	if string(rit.Key()) != "b\xff" {
+		t.Errorf("reverse Seek Got %q expected %q", rit.Key(), "b\xff")
+	}
+	rit.Seek([]byte("0"))
+	if rit.Valid() {
+		t.Errorf("reverse Seek Got %q expected invalid", rit.Key())
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	tests := []struct {
+		prefix, want []byte
+	}{
+		{[]byte("ab"), []byte("ac")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte("\xff\xff"), nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := driver.PrefixUpperBound(tt.prefix); string(got) != string(tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("PrefixUpperBound(%q) Got %q expected %q", tt.prefix, got, tt.want)
+		}
+	}
+}
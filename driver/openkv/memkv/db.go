@@ -0,0 +1,369 @@
+package memkv
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/utils"
+)
+
+var (
+	ErrClosed         = errors.New("memory db is closed")
+	ErrSnapshotClosed = errors.New("memory db snapshot is closed")
+	ErrBatchClosed    = errors.New("memory db write batch is closed")
+)
+
+// engine the data of one path
+type engine struct {
+	mu     sync.RWMutex
+	list   *skiplist
+	seq    uint64
+	opened bool
+	// live snapshot(iterator) seq -> ref count,
+	// compact keeps versions which are visible to them
+	snapshots map[uint64]int
+	// auto compact after written bytes >= writeBufferSize, 0 is disabled
+	writeBufferSize int64
+	written         int64
+}
+
+func newEngine() *engine {
+	return &engine{
+		list:      newSkiplist(),
+		snapshots: map[uint64]int{},
+	}
+}
+
+// acquire hold a snapshot at current seq
+func (e *engine) acquire() uint64 {
+	e.mu.Lock()
+	seq := e.seq
+	e.snapshots[seq]++
+	e.mu.Unlock()
+
+	return seq
+}
+
+func (e *engine) release(seq uint64) {
+	e.mu.Lock()
+	if e.snapshots[seq]--; e.snapshots[seq] <= 0 {
+		delete(e.snapshots, seq)
+	}
+	e.mu.Unlock()
+}
+
+func (e *engine) get(key []byte, seq uint64) []byte {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	n := e.list.get(key)
+	if n == nil {
+		return nil
+	}
+	v, ok := n.get(seq)
+	if !ok {
+		return nil
+	}
+
+	return append([]byte{}, v...)
+}
+
+// multiGet get values of keys at seq under one read lock
+func (e *engine) multiGet(keys [][]byte, seq uint64) [][]byte {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		n := e.list.get(key)
+		if n == nil {
+			continue
+		}
+		if v, ok := n.get(seq); ok {
+			values[i] = append([]byte{}, v...)
+		}
+	}
+
+	return values
+}
+
+func (e *engine) latestSeq() uint64 {
+	e.mu.RLock()
+	seq := e.seq
+	e.mu.RUnlock()
+
+	return seq
+}
+
+// write apply batch ops atomically with one new seq,
+// return true if written bytes reach write buffer size to compact
+func (e *engine) write(ops *utils.BatchOpBuffer) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.writeLocked(ops)
+}
+
+// commitTxn write ops if tracked keys are not written after seq
+func (e *engine) commitTxn(seq uint64, tracked [][]byte, ops *utils.BatchOpBuffer) (compact bool, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, key := range tracked {
+		// tombstones newer than seq are not compacted while the txn snapshot is live
+		if n := e.list.get(key); n != nil && n.versions[0].seq > seq {
+			return false, driver.ErrTxnConflict
+		}
+	}
+	if ops.Len() == 0 {
+		return false, nil
+	}
+
+	return e.writeLocked(ops), nil
+}
+
+func (e *engine) writeLocked(ops *utils.BatchOpBuffer) bool {
+	e.seq++
+	for el := ops.FrontElement(); el != nil; el = el.Next() {
+		op := el.Value.(*utils.BatchOp)
+		e.written += int64(len(op.Key) + len(op.Value))
+		switch op.Type {
+		case utils.BatchOpTypePut:
+			e.list.getOrInsert(op.Key).set(version{seq: e.seq, value: op.Value})
+		case utils.BatchOpTypeDel:
+			if n := e.list.get(op.Key); n != nil {
+				n.set(version{seq: e.seq, deleted: true})
+			}
+		case utils.BatchOpTypeDelRange:
+			e.deleteRange(op.Key, op.Value)
+		}
+	}
+
+	return e.writeBufferSize > 0 && e.written >= e.writeBufferSize
+}
+
+// clone copy the visible data at current seq to a new engine
+func (e *engine) clone() *engine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ne := newEngine()
+	ne.seq = 1
+	for n := e.list.findFirst(); n != nil; n = n.next[0] {
+		if v, ok := n.get(e.seq); ok {
+			ne.list.getOrInsert(n.key).set(version{seq: ne.seq, value: v})
+		}
+	}
+
+	return ne
+}
+
+// deleteRange add tombstones to the live keys in [start, end) with current seq
+func (e *engine) deleteRange(start, end []byte) {
+	n := e.list.findFirst()
+	if len(start) > 0 {
+		n = e.list.findGreaterOrEqual(start, nil)
+	}
+	for ; n != nil; n = n.next[0] {
+		if len(end) > 0 && bytes.Compare(n.key, end) >= 0 {
+			break
+		}
+		if !n.versions[0].deleted {
+			n.set(version{seq: e.seq, deleted: true})
+		}
+	}
+}
+
+// compact drop the versions which are invisible to live snapshots,
+// and unlink deleted keys
+func (e *engine) compact() {
+	e.compactRange(nil, nil)
+}
+
+// compactRange compact the keys in [start, end), nil or empty start/end is unbounded
+func (e *engine) compactRange(start, end []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(start) == 0 && len(end) == 0 {
+		e.written = 0
+	}
+	minSeq := e.seq
+	for seq := range e.snapshots {
+		if seq < minSeq {
+			minSeq = seq
+		}
+	}
+
+	removes := [][]byte{}
+	n := e.list.findFirst()
+	if len(start) > 0 {
+		n = e.list.findGreaterOrEqual(start, nil)
+	}
+	for ; n != nil; n = n.next[0] {
+		if len(end) > 0 && bytes.Compare(n.key, end) >= 0 {
+			break
+		}
+		for i := range n.versions {
+			if n.versions[i].seq <= minSeq {
+				n.versions = n.versions[: i+1 : i+1]
+				break
+			}
+		}
+		if len(n.versions) == 1 && n.versions[0].deleted && n.versions[0].seq <= minSeq {
+			removes = append(removes, n.key)
+		}
+	}
+	for _, key := range removes {
+		e.list.remove(key)
+	}
+}
+
+// DB memory db handle
+type DB struct {
+	store  *Store
+	path   string
+	e      *engine
+	closed atomic.Bool
+}
+
+func (db *DB) Close() error {
+	if db.closed.Swap(true) {
+		return nil
+	}
+	db.store.close(db.e)
+
+	return nil
+}
+
+func (db *DB) Get(key []byte) ([]byte, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	return db.e.get(key, db.e.latestSeq()), nil
+}
+
+func (db *DB) GetSlice(key []byte) (driver.ISlice, error) {
+	v, err := db.Get(key)
+	if v == nil {
+		return nil, err
+	}
+
+	return driver.GoSlice(v), nil
+}
+
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	errs := make([]error, len(keys))
+	if db.closed.Load() {
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+		return make([][]byte, len(keys)), errs
+	}
+
+	return db.e.multiGet(keys, db.e.latestSeq()), errs
+}
+
+func (db *DB) Put(key []byte, value []byte) error {
+	wb := db.newWriteBatch()
+	wb.Put(key, value)
+	return wb.Commit()
+}
+
+func (db *DB) Delete(key []byte) error {
+	wb := db.newWriteBatch()
+	wb.Delete(key)
+	return wb.Commit()
+}
+
+// DeleteRange delete keys in [start, end) atomically
+func (db *DB) DeleteRange(start, end []byte) error {
+	wb := db.newWriteBatch()
+	wb.DeleteRange(start, end)
+	return wb.Commit()
+}
+
+// SyncPut same as Put, memory has no stable disk to sync
+func (db *DB) SyncPut(key []byte, value []byte) error {
+	return db.Put(key, value)
+}
+
+// SyncDelete same as Delete, memory has no stable disk to sync
+func (db *DB) SyncDelete(key []byte) error {
+	return db.Delete(key)
+}
+
+func (db *DB) NewIterator() driver.IIterator {
+	if db.closed.Load() {
+		return &Iterator{err: ErrClosed}
+	}
+
+	return newIterator(db.e, db.e.acquire(), true)
+}
+
+func (db *DB) NewWriteBatch() driver.IWriteBatch {
+	return db.newWriteBatch()
+}
+
+func (db *DB) newWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db, ops: utils.NewBatchOpBuffer()}
+}
+
+func (db *DB) NewSnapshot() (driver.ISnapshot, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	return &Snapshot{e: db.e, seq: db.e.acquire()}, nil
+}
+
+// Checkpoint copy the data of db to a new db of dir in the same store
+func (db *DB) Checkpoint(dir string) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+
+	return db.store.add(dir, db.e.clone())
+}
+
+func (db *DB) Compact() error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	db.e.compact()
+
+	return nil
+}
+
+// Begin begin an optimistic transaction on the snapshot of current seq
+func (db *DB) Begin() (driver.ITxn, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	snap := &Snapshot{e: db.e, seq: db.e.acquire()}
+	return driver.NewTxn(snap, func(tracked [][]byte, ops *utils.BatchOpBuffer) error {
+		if db.closed.Load() {
+			return ErrClosed
+		}
+		compact, err := db.e.commitTxn(snap.seq, tracked, ops)
+		if compact {
+			db.e.compact()
+		}
+		return err
+	}, nil), nil
+}
+
+// CompactRange compact the keys in [start, end), nil or empty start/end is unbounded
+func (db *DB) CompactRange(start, end []byte) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	db.e.compactRange(start, end)
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package memkv
+
+// Iterator iterate keys at one snapshot seq,
+// Key/Value return the engine inner bytes, don't modify them.
+type Iterator struct {
+	e   *engine
+	seq uint64
+	// release the snapshot seq when close
+	owned  bool
+	cur    *node
+	value  []byte
+	err    error
+	closed bool
+}
+
+func newIterator(e *engine, seq uint64, owned bool) *Iterator {
+	return &Iterator{e: e, seq: seq, owned: owned}
+}
+
+func (it *Iterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	it.cur, it.value = nil, nil
+	if it.owned && it.e != nil {
+		it.e.release(it.seq)
+	}
+
+	return nil
+}
+
+func (it *Iterator) usable() bool {
+	return it.e != nil && !it.closed
+}
+
+// forward move to the first visible node from n (include)
+func (it *Iterator) forward(n *node) {
+	for ; n != nil; n = n.next[0] {
+		if v, ok := n.get(it.seq); ok {
+			it.cur, it.value = n, v
+			return
+		}
+	}
+	it.cur, it.value = nil, nil
+}
+
+// backward move to the last visible node from n (include)
+func (it *Iterator) backward(n *node) {
+	for n != nil {
+		if v, ok := n.get(it.seq); ok {
+			it.cur, it.value = n, v
+			return
+		}
+		n = it.e.list.findLessThan(n.key)
+	}
+	it.cur, it.value = nil, nil
+}
+
+func (it *Iterator) First() {
+	if !it.usable() {
+		return
+	}
+	it.e.mu.RLock()
+	it.forward(it.e.list.findFirst())
+	it.e.mu.RUnlock()
+}
+
+func (it *Iterator) Last() {
+	if !it.usable() {
+		return
+	}
+	it.e.mu.RLock()
+	it.backward(it.e.list.findLast())
+	it.e.mu.RUnlock()
+}
+
+// Seek move to the first key >= key
+func (it *Iterator) Seek(key []byte) {
+	if !it.usable() {
+		return
+	}
+	it.e.mu.RLock()
+	it.forward(it.e.list.findGreaterOrEqual(key, nil))
+	it.e.mu.RUnlock()
+}
+
+// Next move to the next key, nothing to do if iterator is invalid
+func (it *Iterator) Next() {
+	if !it.usable() || it.cur == nil {
+		return
+	}
+	it.e.mu.RLock()
+	it.forward(it.cur.next[0])
+	it.e.mu.RUnlock()
+}
+
+// Prev move to the previous key, nothing to do if iterator is invalid
+func (it *Iterator) Prev() {
+	if !it.usable() || it.cur == nil {
+		return
+	}
+	it.e.mu.RLock()
+	it.backward(it.e.list.findLessThan(it.cur.key))
+	it.e.mu.RUnlock()
+}
+
+func (it *Iterator) Valid() bool {
+	return it.cur != nil
+}
+
+func (it *Iterator) Key() []byte {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.key
+}
+
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+func (it *Iterator) Error() error {
+	return it.err
+}
@@ -0,0 +1,232 @@
+package memkv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+func TestConformance(t *testing.T) {
+	openkvtest.RunConformance(t, NewStore())
+}
+
+func openTestDB(t *testing.T) driver.IDB {
+	store, err := driver.GetStore(StoreName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := t.Name()
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		defaultStore.Destroy(path)
+	})
+
+	return db
+}
+
+func TestGetPutDelete(t *testing.T) {
+	db := openTestDB(t)
+
+	if v, err := db.Get([]byte("a")); err != nil || v != nil {
+		t.Fatalf("Got %v %v expected nil", v, err)
+	}
+	db.Put([]byte("a"), []byte("1"))
+	if v, _ := db.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+	db.Put([]byte("a"), []byte("2"))
+	if v, _ := db.Get([]byte("a")); string(v) != "2" {
+		t.Errorf("Got %s expected %s", v, "2")
+	}
+	db.Delete([]byte("a"))
+	if v, _ := db.Get([]byte("a")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+	db.Put([]byte("b"), nil)
+	if v, _ := db.Get([]byte("b")); v == nil || len(v) != 0 {
+		t.Errorf("Got %v expected empty value", v)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	db := openTestDB(t)
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("1"))
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	db.Put([]byte("a"), []byte("2"))
+	db.Delete([]byte("b"))
+	db.Put([]byte("c"), []byte("2"))
+	db.Compact()
+
+	if v, _ := snap.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+	if v, _ := snap.Get([]byte("b")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+	if v, _ := snap.Get([]byte("c")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+
+	it := snap.NewIterator()
+	defer it.Close()
+	keys := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Errorf("Got %v expected %v", keys, "[a b]")
+	}
+}
+
+func TestIteratorOrder(t *testing.T) {
+	db := openTestDB(t)
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	for i := 9; i >= 0; i-- {
+		wb.Put([]byte(fmt.Sprintf("key%02d", i*2)), []byte{byte(i)})
+	}
+	wb.Delete([]byte("key04"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	var prev []byte
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		if prev != nil && bytes.Compare(prev, it.Key()) >= 0 {
+			t.Fatalf("key %s is not after %s", it.Key(), prev)
+		}
+		prev = append(prev[:0], it.Key()...)
+		n++
+	}
+	if n != 9 {
+		t.Errorf("Got %v expected %v", n, 9)
+	}
+
+	it.Seek([]byte("key03"))
+	if !it.Valid() || string(it.Key()) != "key06" {
+		t.Errorf("Got %s expected %s", it.Key(), "key06")
+	}
+	it.Prev()
+	if !it.Valid() || string(it.Key()) != "key02" {
+		t.Errorf("Got %s expected %s", it.Key(), "key02")
+	}
+	it.Seek([]byte("key99"))
+	if it.Valid() {
+		t.Errorf("Got %s expected invalid", it.Key())
+	}
+	it.Last()
+	if !it.Valid() || string(it.Key()) != "key18" {
+		t.Errorf("Got %s expected %s", it.Key(), "key18")
+	}
+	it.First()
+	it.Prev()
+	if it.Valid() {
+		t.Errorf("Got %s expected invalid", it.Key())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("v"))
+	}
+	for i := 0; i < 100; i += 2 {
+		db.Delete([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	db.Compact()
+
+	e := db.(*DB).e
+	if e.list.length != 50 {
+		t.Errorf("Got %v expected %v", e.list.length, 50)
+	}
+	if v, _ := db.Get([]byte("key001")); string(v) != "v" {
+		t.Errorf("Got %s expected %s", v, "v")
+	}
+}
+
+func TestProperty(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("key%d", i)), []byte("v"))
+	}
+	for i := 0; i < 4; i++ {
+		db.Delete([]byte(fmt.Sprintf("key%d", i)))
+	}
+
+	check := func(name string, expected uint64) {
+		t.Helper()
+		if v, err := driver.PropertyUint(db, name); err != nil || v != expected {
+			t.Errorf("%s Got %d %v expected %d", name, v, err, expected)
+		}
+	}
+	check(driver.PropertyEstimateNumKeys, 6)
+	check(driver.PropertyEstimateLiveDataSize, 30)
+	check(driver.PropertyNumDeletes, 8)
+	check(driver.PropertyPendingCompactionBytes, 36)
+	check(driver.PropertyMemtableUsage, 66)
+
+	db.Compact()
+	check(driver.PropertyNumDeletes, 0)
+	check(driver.PropertyPendingCompactionBytes, 0)
+	check(driver.PropertyMemtableUsage, 30)
+}
+
+func TestWriteBufferSizeAutoCompact(t *testing.T) {
+	db, err := NewStore().OpenWithOptions("auto", driver.WithWriteBufferSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	e := db.(*DB).e
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("v"))
+		db.Delete([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	if e.list.length >= 10 || e.written >= 64 {
+		t.Errorf("Got length %d written %d expected auto compacted", e.list.length, e.written)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	store := NewStore()
+	db, err := store.Open("reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open("reopen"); err == nil {
+		t.Error("open an opened db expected error")
+	}
+	db.Put([]byte("a"), []byte("1"))
+	db.Close()
+	if err := db.Put([]byte("a"), []byte("1")); err != ErrClosed {
+		t.Errorf("Got %v expected %v", err, ErrClosed)
+	}
+
+	db, err = store.Open("reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Got %s expected %s", v, "1")
+	}
+	db.Close()
+}
@@ -0,0 +1,200 @@
+package memkv
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+const (
+	maxHeight = 12
+	branching = 4
+)
+
+// version one mvcc version of key, commit seq is the batch commit sequence
+type version struct {
+	seq     uint64
+	value   []byte
+	deleted bool
+}
+
+// node skiplist node, versions order by seq desc (newest first)
+type node struct {
+	key      []byte
+	versions []version
+	next     []*node
+}
+
+// get return visible value at seq, ok is false if not found or deleted
+func (n *node) get(seq uint64) (value []byte, ok bool) {
+	for i := range n.versions {
+		if n.versions[i].seq <= seq {
+			if n.versions[i].deleted {
+				return nil, false
+			}
+			return n.versions[i].value, true
+		}
+	}
+
+	return nil, false
+}
+
+// set add a new version, replace it if the newest version has the same seq
+// (same key op more than once in one batch)
+func (n *node) set(v version) {
+	if len(n.versions) > 0 && n.versions[0].seq == v.seq {
+		n.versions[0] = v
+		return
+	}
+
+	n.versions = append(n.versions, version{})
+	copy(n.versions[1:], n.versions)
+	n.versions[0] = v
+}
+
+// skiplist ordered by key, not goroutine safe, protected by engine lock.
+// removed nodes keep their next pointers, so iterators which stay on
+// a removed node still can move forward.
+type skiplist struct {
+	head   *node
+	height int
+	length int
+	rnd    *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:   &node{next: make([]*node, maxHeight)},
+		height: 1,
+		rnd:    rand.New(rand.NewSource(0xdeadbeef)),
+	}
+}
+
+func (s *skiplist) randomHeight() int {
+	h := 1
+	for h < maxHeight && s.rnd.Intn(branching) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGreaterOrEqual return the first node which key >= key, or nil.
+// if prev is not nil, fill prev node at each level
+func (s *skiplist) findGreaterOrEqual(key []byte, prev []*node) *node {
+	x := s.head
+	level := s.height - 1
+	for {
+		next := x.next[level]
+		if next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next
+			continue
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+		level--
+	}
+}
+
+// findLessThan return the last node which key < key, or nil
+func (s *skiplist) findLessThan(key []byte) *node {
+	x := s.head
+	level := s.height - 1
+	for {
+		next := x.next[level]
+		if next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+
+	if x == s.head {
+		return nil
+	}
+	return x
+}
+
+// findFirst return the first node, or nil
+func (s *skiplist) findFirst() *node {
+	return s.head.next[0]
+}
+
+// findLast return the last node, or nil
+func (s *skiplist) findLast() *node {
+	x := s.head
+	level := s.height - 1
+	for {
+		next := x.next[level]
+		if next != nil {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+
+	if x == s.head {
+		return nil
+	}
+	return x
+}
+
+// getOrInsert return the node of key, insert a new node if not exists
+func (s *skiplist) getOrInsert(key []byte) *node {
+	prev := make([]*node, maxHeight)
+	x := s.findGreaterOrEqual(key, prev)
+	if x != nil && bytes.Equal(x.key, key) {
+		return x
+	}
+
+	h := s.randomHeight()
+	if h > s.height {
+		for i := s.height; i < h; i++ {
+			prev[i] = s.head
+		}
+		s.height = h
+	}
+
+	x = &node{key: key, next: make([]*node, h)}
+	for i := 0; i < h; i++ {
+		x.next[i] = prev[i].next[i]
+		prev[i].next[i] = x
+	}
+	s.length++
+
+	return x
+}
+
+// get return the node of key, or nil
+func (s *skiplist) get(key []byte) *node {
+	x := s.findGreaterOrEqual(key, nil)
+	if x != nil && bytes.Equal(x.key, key) {
+		return x
+	}
+	return nil
+}
+
+// remove unlink the node of key
+func (s *skiplist) remove(key []byte) {
+	prev := make([]*node, maxHeight)
+	x := s.findGreaterOrEqual(key, prev)
+	if x == nil || !bytes.Equal(x.key, key) {
+		return
+	}
+
+	for i := 0; i < len(x.next); i++ {
+		prev[i].next[i] = x.next[i]
+	}
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+	s.length--
+}
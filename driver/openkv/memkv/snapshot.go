@@ -0,0 +1,60 @@
+package memkv
+
+import (
+	"sync/atomic"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+)
+
+// Snapshot read view at one commit seq, isolated from later writes
+type Snapshot struct {
+	e      *engine
+	seq    uint64
+	closed atomic.Bool
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if s.closed.Load() {
+		return nil, ErrSnapshotClosed
+	}
+
+	return s.e.get(key, s.seq), nil
+}
+
+func (s *Snapshot) GetSlice(key []byte) (driver.ISlice, error) {
+	v, err := s.Get(key)
+	if v == nil {
+		return nil, err
+	}
+
+	return driver.GoSlice(v), nil
+}
+
+func (s *Snapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	errs := make([]error, len(keys))
+	if s.closed.Load() {
+		for i := range errs {
+			errs[i] = ErrSnapshotClosed
+		}
+		return make([][]byte, len(keys)), errs
+	}
+
+	return s.e.multiGet(keys, s.seq), errs
+}
+
+// NewIterator new iterator at snapshot seq,
+// the iterator must be closed before snapshot close.
+func (s *Snapshot) NewIterator() driver.IIterator {
+	if s.closed.Load() {
+		return &Iterator{err: ErrSnapshotClosed}
+	}
+
+	return newIterator(s.e, s.seq, false)
+}
+
+func (s *Snapshot) Close() {
+	if s.closed.Swap(true) {
+		return
+	}
+	s.e.release(s.seq)
+}
@@ -0,0 +1,113 @@
+package memkv
+
+import (
+	"bytes"
+	"strconv"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+)
+
+// stats exact stats of engine at latest seq
+type stats struct {
+	// live keys and their key/value bytes
+	keys, liveBytes uint64
+	// key/value bytes of all versions
+	totalBytes uint64
+	// tombstones and stale versions which compact would drop (if no snapshot),
+	// and their bytes
+	deletes, deleteBytes uint64
+}
+
+// rangeStats walk the nodes in [start, limit) under read lock
+func (e *engine) rangeStats(start, limit []byte) stats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	s := stats{}
+	n := e.list.findFirst()
+	if len(start) > 0 {
+		n = e.list.findGreaterOrEqual(start, nil)
+	}
+	for ; n != nil; n = n.next[0] {
+		if len(limit) > 0 && bytes.Compare(n.key, limit) >= 0 {
+			break
+		}
+		for i, v := range n.versions {
+			size := uint64(len(n.key) + len(v.value))
+			s.totalBytes += size
+			if i == 0 && !v.deleted {
+				s.keys++
+				s.liveBytes += size
+				continue
+			}
+			s.deletes++
+			s.deleteBytes += size
+		}
+	}
+
+	return s
+}
+
+// ApproximateSizes exact bytes of live keys and values in ranges
+func (db *DB) ApproximateSizes(ranges []driver.Range) ([]uint64, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		sizes[i] = db.e.rangeStats(r.Start, r.Limit).liveBytes
+	}
+
+	return sizes, nil
+}
+
+// ApproximateTotalSizes exact bytes of all versions (include tombstones) in ranges
+func (db *DB) ApproximateTotalSizes(ranges []driver.Range) ([]uint64, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		sizes[i] = db.e.rangeStats(r.Start, r.Limit).totalBytes
+	}
+
+	return sizes, nil
+}
+
+// ApproximateCount exact number of live keys in range
+func (db *DB) ApproximateCount(r driver.Range) (uint64, error) {
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	return db.e.rangeStats(r.Start, r.Limit).keys, nil
+}
+
+// Property memory db has one memtable level, all data is in memtable
+func (db *DB) Property(name string) (string, error) {
+	if db.closed.Load() {
+		return "", ErrClosed
+	}
+
+	var v uint64
+	switch name {
+	case driver.PropertyNumLevels:
+		v = 1
+	case driver.PropertyMemtableUsage:
+		v = db.e.rangeStats(nil, nil).totalBytes
+	case driver.PropertyPendingCompactionBytes:
+		v = db.e.rangeStats(nil, nil).deleteBytes
+	case driver.PropertyEstimateNumKeys:
+		v = db.e.rangeStats(nil, nil).keys
+	case driver.PropertyEstimateLiveDataSize:
+		v = db.e.rangeStats(nil, nil).liveBytes
+	case driver.PropertyNumDeletes:
+		v = db.e.rangeStats(nil, nil).deletes
+	default:
+		return "", driver.ErrUnknownProperty
+	}
+
+	return strconv.FormatUint(v, 10), nil
+}
@@ -0,0 +1,122 @@
+// Package memkv is a pure go in-memory ordered kv engine for openkv,
+// mvcc skiplist based, support snapshot isolation and ordered iteration.
+// the data of one path lives in process memory until Store.Destroy,
+// so close and reopen the same path will see the data before.
+package memkv
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/option"
+)
+
+const StoreName = "memory"
+
+func init() {
+	driver.Register(defaultStore)
+}
+
+var defaultStore = NewStore()
+
+type Store struct {
+	mu      sync.Mutex
+	engines map[string]*engine
+}
+
+// NewStore new a memory store which is not registered,
+// use driver.GetStore(StoreName) to get the registered one.
+func NewStore() *Store {
+	return &Store{engines: map[string]*engine{}}
+}
+
+func (s *Store) String() string {
+	return StoreName
+}
+
+func (s *Store) Name() string {
+	return StoreName
+}
+
+// Open open the db of path, return error if it is opened.
+func (s *Store) Open(path string) (driver.IDB, error) {
+	return s.OpenWithOptions(path)
+}
+
+// OptionSpecs memory db supports writeBufferSize only
+func (s *Store) OptionSpecs() []driver.OptionSpec {
+	return []driver.OptionSpec{
+		{
+			Name: "writeBufferSize", Type: driver.OptionTypeInt, Default: 0, Min: 0, Max: math.MaxInt64,
+			Desc: "auto compact after written bytes reach it, 0 is disabled",
+		},
+	}
+}
+
+// OpenWithOptions open the db of path with options, return error if it is opened.
+func (s *Store) OpenWithOptions(path string, opts ...option.Option) (driver.IDB, error) {
+	o := driver.NewStoreOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.engines[path]
+	if !ok {
+		e = newEngine()
+		s.engines[path] = e
+	}
+	if e.opened {
+		return nil, fmt.Errorf("memory db %s is opened", path)
+	}
+	e.opened = true
+	e.mu.Lock()
+	e.writeBufferSize = o.WriteBufferSize
+	e.mu.Unlock()
+
+	return &DB{store: s, path: path, e: e}, nil
+}
+
+// Repair nothing to repair in memory, return error if it is opened.
+func (s *Store) Repair(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.engines[path]; ok && e.opened {
+		return fmt.Errorf("memory db %s is opened", path)
+	}
+
+	return nil
+}
+
+// Destroy drop the data of path, return error if it is opened.
+func (s *Store) Destroy(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.engines[path]; ok && e.opened {
+		return fmt.Errorf("memory db %s is opened", path)
+	}
+	delete(s.engines, path)
+
+	return nil
+}
+
+func (s *Store) add(path string, e *engine) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.engines[path]; ok {
+		return fmt.Errorf("memory db %s exists", path)
+	}
+	s.engines[path] = e
+
+	return nil
+}
+
+func (s *Store) close(e *engine) {
+	s.mu.Lock()
+	e.opened = false
+	s.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package memkv
+
+import (
+	"github.com/weedge/pkg/utils"
+)
+
+// WriteBatch buffer ops, commit them atomically with one seq.
+// Commit don't reset the batch (Data() still can be used, eg: binlog),
+// use Rollback to reset it.
+type WriteBatch struct {
+	db     *DB
+	ops    *utils.BatchOpBuffer
+	closed bool
+}
+
+func (wb *WriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (wb *WriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+}
+
+// DeleteRange delete keys in [start, end), nil or empty start/end is unbounded
+func (wb *WriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+}
+
+func (wb *WriteBatch) Commit() error {
+	if wb.closed {
+		return ErrBatchClosed
+	}
+	if wb.db.closed.Load() {
+		return ErrClosed
+	}
+	if wb.db.e.write(wb.ops) {
+		wb.db.e.compact()
+	}
+
+	return nil
+}
+
+// SyncCommit same as Commit, memory has no stable disk to sync
+func (wb *WriteBatch) SyncCommit() error {
+	return wb.Commit()
+}
+
+func (wb *WriteBatch) Rollback() error {
+	wb.ops.Reset()
+	return nil
+}
+
+func (wb *WriteBatch) Data() []byte {
+	return wb.ops.Data()
+}
+
+func (wb *WriteBatch) Close() {
+	wb.closed = true
+	wb.ops.Reset()
+}
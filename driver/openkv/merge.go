@@ -0,0 +1,373 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/weedge/pkg/utils"
+)
+
+var (
+	ErrMergeUnsupported = errors.New("merge is unsupported, use WithMergeOperator to wrap db")
+	ErrMergeOverflow    = errors.New("merge increment or decrement would overflow")
+)
+
+// MergeOperator merge operands to the existing value of key
+type MergeOperator interface {
+	Name() string
+	// FullMerge merge operands in order to existing value,
+	// existing is nil if key not exists
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+var mergeOperators = map[string]MergeOperator{}
+
+const (
+	MergeOperatorInt64Add = "int64add"
+	MergeOperatorAppend   = "append"
+)
+
+func init() {
+	RegisterMergeOperator(Int64AddOperator{})
+	RegisterMergeOperator(AppendOperator{})
+}
+
+func RegisterMergeOperator(op MergeOperator) error {
+	name := op.Name()
+	if _, ok := mergeOperators[name]; ok {
+		return fmt.Errorf("merge operator %s is registered", name)
+	}
+
+	mergeOperators[name] = op
+	return nil
+}
+
+func ListMergeOperators() []string {
+	s := []string{}
+	for k := range mergeOperators {
+		s = append(s, k)
+	}
+
+	return s
+}
+
+func GetMergeOperator(name string) (MergeOperator, error) {
+	op, ok := mergeOperators[name]
+	if !ok {
+		return nil, fmt.Errorf("merge operator %s is not registered", name)
+	}
+
+	return op, nil
+}
+
+// Int64AddOperator add int64 operands to value, value and operands are decimal strings
+// like redis INCRBY, not exists value is 0
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Name() string {
+	return MergeOperatorInt64Add
+}
+
+func (Int64AddOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	n, err := utils.StrInt64(existing, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, operand := range operands {
+		delta, err := utils.StrInt64(operand, nil)
+		if err != nil {
+			return nil, err
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, ErrMergeOverflow
+		}
+		n += delta
+	}
+
+	return strconv.AppendInt(nil, n, 10), nil
+}
+
+// AppendOperator append operands to value, like redis APPEND
+type AppendOperator struct{}
+
+func (AppendOperator) Name() string {
+	return MergeOperatorAppend
+}
+
+func (AppendOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return utils.ConcatBytes(append([][]byte{existing}, operands...)), nil
+}
+
+// Merge merge operand to key, db must be IMerger
+func Merge(db IDB, key, operand []byte) error {
+	if m, ok := db.(IMerger); ok {
+		return m.Merge(key, operand)
+	}
+
+	return ErrMergeUnsupported
+}
+
+// BatchMerge add merge operand to key op to write batch, wb must be IWriteBatchMerger
+// wrapper batches record ErrMergeUnsupported of inner batch and return it from Commit
+func BatchMerge(wb IWriteBatch, key, operand []byte) error {
+	if m, ok := wb.(IWriteBatchMerger); ok {
+		m.Merge(key, operand)
+		return nil
+	}
+
+	return ErrMergeUnsupported
+}
+
+const mergeLockStripes = 256
+
+// WithMergeOperator wrap db to support merge by operator for engine without native merge,
+// merge is read-modify-write under key striped lock, so all writes of the keys
+// must go through the wrapped db (writes to the inner db directly may be lost).
+// merges are resolved when write, snapshots and iterators see resolved values.
+func WithMergeOperator(db IDB, op MergeOperator) IDB {
+	return &mergeDB{IDB: db, op: op}
+}
+
+type mergeDB struct {
+	IDB
+	op    MergeOperator
+	locks [mergeLockStripes]sync.Mutex
+}
+
+func stripe(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % mergeLockStripes)
+}
+
+func (db *mergeDB) lock(key []byte) func() {
+	mu := &db.locks[stripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockStripes lock stripes in order to avoid dead lock
+func (db *mergeDB) lockStripes(stripes []int) func() {
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		db.locks[i].Lock()
+	}
+
+	return func() {
+		for _, i := range stripes {
+			db.locks[i].Unlock()
+		}
+	}
+}
+
+func (db *mergeDB) lockAll() func() {
+	stripes := make([]int, mergeLockStripes)
+	for i := range stripes {
+		stripes[i] = i
+	}
+	return db.lockStripes(stripes)
+}
+
+func (db *mergeDB) Merge(key, operand []byte) error {
+	defer db.lock(key)()
+
+	existing, err := db.IDB.Get(key)
+	if err != nil {
+		return err
+	}
+	v, err := db.op.FullMerge(key, existing, [][]byte{operand})
+	if err != nil {
+		return err
+	}
+
+	return db.IDB.Put(key, v)
+}
+
+func (db *mergeDB) Put(key []byte, value []byte) error {
+	defer db.lock(key)()
+	return db.IDB.Put(key, value)
+}
+
+func (db *mergeDB) SyncPut(key []byte, value []byte) error {
+	defer db.lock(key)()
+	return db.IDB.SyncPut(key, value)
+}
+
+func (db *mergeDB) Delete(key []byte) error {
+	defer db.lock(key)()
+	return db.IDB.Delete(key)
+}
+
+func (db *mergeDB) SyncDelete(key []byte) error {
+	defer db.lock(key)()
+	return db.IDB.SyncDelete(key)
+}
+
+func (db *mergeDB) DeleteRange(start, end []byte) error {
+	defer db.lockAll()()
+	return DeleteRange(db.IDB, start, end)
+}
+
+func (db *mergeDB) GetSlice(key []byte) (ISlice, error) {
+	if g, ok := db.IDB.(ISliceGeter); ok {
+		return g.GetSlice(key)
+	}
+
+	v, err := db.IDB.Get(key)
+	if v == nil {
+		return nil, err
+	}
+	return GoSlice(v), nil
+}
+
+func (db *mergeDB) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return NewIteratorWithOptions(db.IDB, opts)
+}
+
+func (db *mergeDB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *mergeDB) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *mergeDB) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(db.IDB, r)
+}
+
+func (db *mergeDB) Property(name string) (string, error) {
+	return Property(db.IDB, name)
+}
+
+func (db *mergeDB) CompactRange(start, end []byte) error {
+	return CompactRange(db.IDB, start, end)
+}
+
+func (db *mergeDB) NewWriteBatch() IWriteBatch {
+	return &mergeWriteBatch{db: db, ops: utils.NewBatchOpBuffer()}
+}
+
+// mergeWriteBatch buffer ops, resolve merges and write to inner batch when commit
+type mergeWriteBatch struct {
+	db  *mergeDB
+	ops *utils.BatchOpBuffer
+}
+
+func (wb *mergeWriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (wb *mergeWriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+}
+
+func (wb *mergeWriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+}
+
+func (wb *mergeWriteBatch) Merge(key, operand []byte) {
+	wb.ops.Merge(append([]byte{}, key...), append([]byte{}, operand...))
+}
+
+func (wb *mergeWriteBatch) Commit() error {
+	return wb.commit(false)
+}
+
+func (wb *mergeWriteBatch) SyncCommit() error {
+	return wb.commit(true)
+}
+
+func inRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0)
+}
+
+func (wb *mergeWriteBatch) commit(sync bool) error {
+	stripes, all := map[int]struct{}{}, false
+	for e := wb.ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		if op.Type == utils.BatchOpTypeDelRange {
+			all = true
+			break
+		}
+		stripes[stripe(op.Key)] = struct{}{}
+	}
+	if all {
+		defer wb.db.lockAll()()
+	} else {
+		ss := make([]int, 0, len(stripes))
+		for i := range stripes {
+			ss = append(ss, i)
+		}
+		defer wb.db.lockStripes(ss)()
+	}
+
+	inner := wb.db.IDB.NewWriteBatch()
+	defer inner.Close()
+
+	// the pending value of keys in batch, nil is deleted
+	pending := map[string][]byte{}
+	delRanges := [][2][]byte{}
+	for e := wb.ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		switch op.Type {
+		case utils.BatchOpTypePut:
+			pending[string(op.Key)] = op.Value
+			inner.Put(op.Key, op.Value)
+		case utils.BatchOpTypeDel:
+			pending[string(op.Key)] = nil
+			inner.Delete(op.Key)
+		case utils.BatchOpTypeDelRange:
+			for k := range pending {
+				if inRange([]byte(k), op.Key, op.Value) {
+					pending[k] = nil
+				}
+			}
+			delRanges = append(delRanges, [2][]byte{op.Key, op.Value})
+			if err := BatchDeleteRange(wb.db.IDB, inner, op.Key, op.Value); err != nil {
+				return err
+			}
+		case utils.BatchOpTypeMerge:
+			existing, ok := pending[string(op.Key)]
+			for i := 0; !ok && i < len(delRanges); i++ {
+				ok = inRange(op.Key, delRanges[i][0], delRanges[i][1])
+			}
+			if !ok {
+				var err error
+				if existing, err = wb.db.IDB.Get(op.Key); err != nil {
+					return err
+				}
+			}
+			v, err := wb.db.op.FullMerge(op.Key, existing, [][]byte{op.Value})
+			if err != nil {
+				return err
+			}
+			pending[string(op.Key)] = v
+			inner.Put(op.Key, v)
+		}
+	}
+
+	if sync {
+		return inner.SyncCommit()
+	}
+	return inner.Commit()
+}
+
+func (wb *mergeWriteBatch) Rollback() error {
+	wb.ops.Reset()
+	return nil
+}
+
+func (wb *mergeWriteBatch) Data() []byte {
+	return wb.ops.Data()
+}
+
+func (wb *mergeWriteBatch) Close() {
+	wb.ops.Reset()
+}
@@ -0,0 +1,126 @@
+package driver_test
+
+import (
+	"sync"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+func TestMergeConformance(t *testing.T) {
+	openkvtest.RunConformance(t, wrapStore{memkv.NewStore(), func(root driver.IDB) driver.IDB {
+		return driver.WithMergeOperator(root, driver.Int64AddOperator{})
+	}})
+}
+
+func openMergeDB(t *testing.T, name string) driver.IDB {
+	op, err := driver.GetMergeOperator(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memkv.NewStore().Open("merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return driver.WithMergeOperator(db, op)
+}
+
+func TestMergeInt64AddConcurrent(t *testing.T) {
+	db := openMergeDB(t, driver.MergeOperatorInt64Add)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if err := driver.Merge(db, []byte("counter"), []byte("2")); err != nil {
+					t.Error(err)
+					return
+				}
+				wb := db.NewWriteBatch()
+				driver.BatchMerge(wb, []byte("counter"), []byte("-1"))
+				if err := wb.Commit(); err != nil {
+					t.Error(err)
+				}
+				wb.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := db.Get([]byte("counter")); string(v) != "4000" {
+		t.Errorf("Got %s expected %s", v, "4000")
+	}
+
+	db.Put([]byte("str"), []byte("abc"))
+	if err := driver.Merge(db, []byte("str"), []byte("1")); err == nil {
+		t.Errorf("merge to not integer value expected error")
+	}
+	db.Put([]byte("max"), []byte("9223372036854775807"))
+	if err := driver.Merge(db, []byte("max"), []byte("1")); err != driver.ErrMergeOverflow {
+		t.Errorf("Got %v expected %v", err, driver.ErrMergeOverflow)
+	}
+}
+
+func TestMergeBatch(t *testing.T) {
+	db := openMergeDB(t, driver.MergeOperatorAppend)
+	db.Put([]byte("a"), []byte("x"))
+	db.Put([]byte("b"), []byte("x"))
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	driver.BatchMerge(wb, []byte("a"), []byte("1"))
+	driver.BatchMerge(wb, []byte("a"), []byte("2"))
+	wb.Delete([]byte("b"))
+	driver.BatchMerge(wb, []byte("b"), []byte("1"))
+	wb.Put([]byte("c"), []byte("y"))
+	driver.BatchMerge(wb, []byte("c"), []byte("1"))
+	wb.(driver.IWriteBatchRangeDeleter).DeleteRange([]byte("c"), []byte("d"))
+	driver.BatchMerge(wb, []byte("c"), []byte("2"))
+
+	if v, _ := db.Get([]byte("a")); string(v) != "x" {
+		t.Errorf("Got %s before commit expected %s", v, "x")
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{"a": "x12", "b": "1", "c": "2"} {
+		if v, _ := db.Get([]byte(k)); string(v) != want {
+			t.Errorf("%s Got %s expected %s", k, v, want)
+		}
+	}
+}
+
+func TestMergeNamespace(t *testing.T) {
+	db := openMergeDB(t, driver.MergeOperatorInt64Add)
+	ns := driver.Namespace(db, "counter")
+
+	driver.Merge(ns, []byte("a"), []byte("1"))
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	driver.BatchMerge(driver.NamespaceWriteBatch(db, wb, "counter"), []byte("a"), []byte("2"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ns.Get([]byte("a")); string(v) != "3" {
+		t.Errorf("Got %s expected %s", v, "3")
+	}
+}
+
+func TestMergeUnsupported(t *testing.T) {
+	db, err := memkv.NewStore().Open("merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := driver.Merge(plainDB{db}, []byte("a"), []byte("1")); err != driver.ErrMergeUnsupported {
+		t.Errorf("Got %v expected %v", err, driver.ErrMergeUnsupported)
+	}
+}
@@ -0,0 +1,490 @@
+package driver
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// MetricOp the op kind of metrics
+type MetricOp int
+
+const (
+	MetricOpGet MetricOp = iota
+	MetricOpPut
+	MetricOpDelete
+	MetricOpDeleteRange
+	MetricOpBatchCommit
+	MetricOpIterStep
+	MetricOpSnapshotGet
+	MetricOpCompact
+	MetricOpMerge
+	MetricOpMultiGet
+	metricOpNum
+)
+
+var metricOpNames = [metricOpNum]string{
+	"get", "put", "delete", "delete_range", "batch_commit", "iter_step", "snapshot_get", "compact", "merge", "multi_get",
+}
+
+func (op MetricOp) String() string {
+	if op < 0 || op >= metricOpNum {
+		return "unknown"
+	}
+	return metricOpNames[op]
+}
+
+// Recorder record the metric of one op,
+// bytes is the key/value bytes read or written
+type Recorder interface {
+	Record(op MetricOp, bytes int, latency time.Duration, err error)
+}
+
+// LatencyBucketsUs latency histogram bucket upper bounds (us), the last bucket is +Inf
+var LatencyBucketsUs = []int64{10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000, 500000, 1000000}
+
+// MetricStat the stat of one op
+type MetricStat struct {
+	Op     MetricOp
+	Calls  uint64
+	Errors uint64
+	Bytes  uint64
+	Usec   uint64
+	// Buckets latency count of LatencyBucketsUs buckets, len(LatencyBucketsUs)+1
+	Buckets []uint64
+}
+
+// UsecPerCall avg latency (us)
+func (s MetricStat) UsecPerCall() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Usec) / float64(s.Calls)
+}
+
+// Percentile return the latency bucket upper bound (us) of percentile p (0, 100],
+// -1 means +Inf bucket
+func (s MetricStat) Percentile(p float64) int64 {
+	var total uint64
+	for _, n := range s.Buckets {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(float64(total)*p/100 + 0.5)
+	var cnt uint64
+	for i, n := range s.Buckets {
+		if cnt += n; cnt >= rank && i < len(LatencyBucketsUs) {
+			return LatencyBucketsUs[i]
+		}
+	}
+	return -1
+}
+
+type opStat struct {
+	calls, errors, bytes, usec uint64
+	buckets                    []uint64
+}
+
+// StatsRecorder lock-free counters and latency histograms Recorder
+type StatsRecorder struct {
+	stats [metricOpNum]opStat
+}
+
+func NewStatsRecorder() *StatsRecorder {
+	r := &StatsRecorder{}
+	for i := range r.stats {
+		r.stats[i].buckets = make([]uint64, len(LatencyBucketsUs)+1)
+	}
+	return r
+}
+
+func (r *StatsRecorder) Record(op MetricOp, bytes int, latency time.Duration, err error) {
+	if op < 0 || op >= metricOpNum {
+		return
+	}
+
+	s := &r.stats[op]
+	atomic.AddUint64(&s.calls, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	atomic.AddUint64(&s.bytes, uint64(bytes))
+	us := latency.Microseconds()
+	atomic.AddUint64(&s.usec, uint64(us))
+
+	i := 0
+	for ; i < len(LatencyBucketsUs); i++ {
+		if us <= LatencyBucketsUs[i] {
+			break
+		}
+	}
+	atomic.AddUint64(&s.buckets[i], 1)
+}
+
+// Stats return the stats of ops which are called
+func (r *StatsRecorder) Stats() []MetricStat {
+	stats := []MetricStat{}
+	for i := range r.stats {
+		s := &r.stats[i]
+		calls := atomic.LoadUint64(&s.calls)
+		if calls == 0 {
+			continue
+		}
+
+		stat := MetricStat{
+			Op:      MetricOp(i),
+			Calls:   calls,
+			Errors:  atomic.LoadUint64(&s.errors),
+			Bytes:   atomic.LoadUint64(&s.bytes),
+			Usec:    atomic.LoadUint64(&s.usec),
+			Buckets: make([]uint64, len(s.buckets)),
+		}
+		for j := range s.buckets {
+			stat.Buckets[j] = atomic.LoadUint64(&s.buckets[j])
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// WithMetrics wrap db to record metrics of ops to recorder,
+// the iterators, write batches and snapshots from it are wrapped too.
+func WithMetrics(db IDB, recorder Recorder) IDB {
+	return &metricsDB{IDB: db, r: recorder}
+}
+
+type metricsDB struct {
+	IDB
+	r Recorder
+}
+
+func (db *metricsDB) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	v, err := db.IDB.Get(key)
+	db.r.Record(MetricOpGet, len(key)+len(v), time.Since(start), err)
+	return v, err
+}
+
+func (db *metricsDB) GetSlice(key []byte) (ISlice, error) {
+	g, ok := db.IDB.(ISliceGeter)
+	if !ok {
+		v, err := db.Get(key)
+		if v == nil {
+			return nil, err
+		}
+		return GoSlice(v), nil
+	}
+
+	start := time.Now()
+	s, err := g.GetSlice(key)
+	n := len(key)
+	if s != nil {
+		n += s.Size()
+	}
+	db.r.Record(MetricOpGet, n, time.Since(start), err)
+	return s, err
+}
+
+func (db *metricsDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return recordMultiGet(db.r, db.IDB, keys)
+}
+
+func (db *metricsDB) MultiGetSlice(keys [][]byte) ([]ISlice, []error) {
+	start := time.Now()
+	slices, errs := MultiGetSlice(db.IDB, keys)
+	n := 0
+	var err error
+	for i := range keys {
+		n += len(keys[i])
+		if slices[i] != nil {
+			n += slices[i].Size()
+		}
+		if errs[i] != nil {
+			err = errs[i]
+		}
+	}
+	db.r.Record(MetricOpMultiGet, n, time.Since(start), err)
+	return slices, errs
+}
+
+func recordMultiGet(r Recorder, g IGeter, keys [][]byte) ([][]byte, []error) {
+	start := time.Now()
+	values, errs := MultiGet(g, keys)
+	n := 0
+	var err error
+	for i := range keys {
+		n += len(keys[i]) + len(values[i])
+		if errs[i] != nil {
+			err = errs[i]
+		}
+	}
+	r.Record(MetricOpMultiGet, n, time.Since(start), err)
+	return values, errs
+}
+
+func (db *metricsDB) Put(key []byte, value []byte) error {
+	start := time.Now()
+	err := db.IDB.Put(key, value)
+	db.r.Record(MetricOpPut, len(key)+len(value), time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) SyncPut(key []byte, value []byte) error {
+	start := time.Now()
+	err := db.IDB.SyncPut(key, value)
+	db.r.Record(MetricOpPut, len(key)+len(value), time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) Delete(key []byte) error {
+	start := time.Now()
+	err := db.IDB.Delete(key)
+	db.r.Record(MetricOpDelete, len(key), time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) SyncDelete(key []byte) error {
+	start := time.Now()
+	err := db.IDB.SyncDelete(key)
+	db.r.Record(MetricOpDelete, len(key), time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) DeleteRange(start, end []byte) error {
+	now := time.Now()
+	err := DeleteRange(db.IDB, start, end)
+	db.r.Record(MetricOpDeleteRange, len(start)+len(end), time.Since(now), err)
+	return err
+}
+
+func (db *metricsDB) Merge(key, operand []byte) error {
+	start := time.Now()
+	err := Merge(db.IDB, key, operand)
+	db.r.Record(MetricOpMerge, len(key)+len(operand), time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *metricsDB) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *metricsDB) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(db.IDB, r)
+}
+
+func (db *metricsDB) Property(name string) (string, error) {
+	return Property(db.IDB, name)
+}
+
+func (db *metricsDB) NewIterator() IIterator {
+	return &metricsIterator{IIterator: db.IDB.NewIterator(), r: db.r}
+}
+
+func (db *metricsDB) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return &metricsIterator{IIterator: NewIteratorWithOptions(db.IDB, opts), r: db.r}
+}
+
+func (db *metricsDB) NewWriteBatch() IWriteBatch {
+	return &metricsWriteBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db.IDB, r: db.r, bytes: new(int)}
+}
+
+func (db *metricsDB) NewSnapshot() (ISnapshot, error) {
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &metricsSnapshot{ISnapshot: snap, r: db.r}, nil
+}
+
+func (db *metricsDB) Compact() error {
+	start := time.Now()
+	err := db.IDB.Compact()
+	db.r.Record(MetricOpCompact, 0, time.Since(start), err)
+	return err
+}
+
+func (db *metricsDB) CompactRange(start, end []byte) error {
+	now := time.Now()
+	err := CompactRange(db.IDB, start, end)
+	db.r.Record(MetricOpCompact, 0, time.Since(now), err)
+	return err
+}
+
+func (db *metricsDB) Begin() (ITxn, error) {
+	return Begin(db.IDB)
+}
+
+func (db *metricsDB) Namespace(name string) IDB {
+	return WithMetrics(Namespace(db.IDB, name), db.r)
+}
+
+// NamespaceWriteBatch the ops of namespace batch are recorded in bytes of wb
+func (db *metricsDB) NamespaceWriteBatch(wb IWriteBatch, name string) IWriteBatch {
+	mwb, ok := wb.(*metricsWriteBatch)
+	if _, native := db.IDB.(INamespacer); !ok || !native {
+		return NamespaceWriteBatch(db.IDB, wb, name)
+	}
+	return &metricsWriteBatch{IWriteBatch: NamespaceWriteBatch(db.IDB, mwb.IWriteBatch, name), db: Namespace(db.IDB, name), r: db.r, bytes: mwb.bytes}
+}
+
+func (db *metricsDB) NamespaceSnapshot(snap ISnapshot, name string) ISnapshot {
+	ms, ok := snap.(*metricsSnapshot)
+	if _, native := db.IDB.(INamespacer); !ok || !native {
+		return NamespaceSnapshot(db.IDB, snap, name)
+	}
+	return &metricsSnapshot{ISnapshot: NamespaceSnapshot(db.IDB, ms.ISnapshot, name), r: db.r}
+}
+
+func (db *metricsDB) NamespaceTxn(txn ITxn, name string) ITxn {
+	return NamespaceTxn(db.IDB, txn, name)
+}
+
+// Checkpoint return ErrCheckpointUnsupported if db is not ICheckpointer
+func (db *metricsDB) Checkpoint(dir string) error {
+	if c, ok := db.IDB.(ICheckpointer); ok {
+		return c.Checkpoint(dir)
+	}
+	return ErrCheckpointUnsupported
+}
+
+func (db *metricsDB) Backup(w io.Writer) error {
+	return Backup(db.IDB, w)
+}
+
+func (db *metricsDB) WithContext(ctx context.Context) IDB {
+	return WithMetrics(WithContext(db.IDB, ctx), db.r)
+}
+
+type metricsWriteBatch struct {
+	IWriteBatch
+	db IDB
+	r  Recorder
+	// bytes is shared with the namespace views of batch
+	bytes *int
+	// merge err, return from commit
+	err error
+}
+
+func (wb *metricsWriteBatch) Put(key []byte, value []byte) {
+	*wb.bytes += len(key) + len(value)
+	wb.IWriteBatch.Put(key, value)
+}
+
+func (wb *metricsWriteBatch) Delete(key []byte) {
+	*wb.bytes += len(key)
+	wb.IWriteBatch.Delete(key)
+}
+
+func (wb *metricsWriteBatch) DeleteRange(start, end []byte) {
+	*wb.bytes += len(start) + len(end)
+	BatchDeleteRange(wb.db, wb.IWriteBatch, start, end)
+}
+
+func (wb *metricsWriteBatch) Merge(key, operand []byte) {
+	*wb.bytes += len(key) + len(operand)
+	if err := BatchMerge(wb.IWriteBatch, key, operand); err != nil && wb.err == nil {
+		wb.err = err
+	}
+}
+
+func (wb *metricsWriteBatch) Commit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	start := time.Now()
+	err := wb.IWriteBatch.Commit()
+	wb.r.Record(MetricOpBatchCommit, *wb.bytes, time.Since(start), err)
+	return err
+}
+
+func (wb *metricsWriteBatch) SyncCommit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	start := time.Now()
+	err := wb.IWriteBatch.SyncCommit()
+	wb.r.Record(MetricOpBatchCommit, *wb.bytes, time.Since(start), err)
+	return err
+}
+
+func (wb *metricsWriteBatch) Rollback() error {
+	*wb.bytes = 0
+	wb.err = nil
+	return wb.IWriteBatch.Rollback()
+}
+
+type metricsSnapshot struct {
+	ISnapshot
+	r Recorder
+}
+
+func (s *metricsSnapshot) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	v, err := s.ISnapshot.Get(key)
+	s.r.Record(MetricOpSnapshotGet, len(key)+len(v), time.Since(start), err)
+	return v, err
+}
+
+func (s *metricsSnapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return recordMultiGet(s.r, s.ISnapshot, keys)
+}
+
+func (s *metricsSnapshot) NewIterator() IIterator {
+	return &metricsIterator{IIterator: s.ISnapshot.NewIterator(), r: s.r}
+}
+
+func (s *metricsSnapshot) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return &metricsIterator{IIterator: NewIteratorWithOptions(s.ISnapshot, opts), r: s.r}
+}
+
+type metricsIterator struct {
+	IIterator
+	r Recorder
+}
+
+func (it *metricsIterator) record(start time.Time) {
+	n := 0
+	if it.IIterator.Valid() {
+		n = len(it.IIterator.Key()) + len(it.IIterator.Value())
+	}
+	it.r.Record(MetricOpIterStep, n, time.Since(start), it.IIterator.Error())
+}
+
+func (it *metricsIterator) First() {
+	start := time.Now()
+	it.IIterator.First()
+	it.record(start)
+}
+
+func (it *metricsIterator) Last() {
+	start := time.Now()
+	it.IIterator.Last()
+	it.record(start)
+}
+
+func (it *metricsIterator) Seek(key []byte) {
+	start := time.Now()
+	it.IIterator.Seek(key)
+	it.record(start)
+}
+
+func (it *metricsIterator) Next() {
+	start := time.Now()
+	it.IIterator.Next()
+	it.record(start)
+}
+
+func (it *metricsIterator) Prev() {
+	start := time.Now()
+	it.IIterator.Prev()
+	it.record(start)
+}
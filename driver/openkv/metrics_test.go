@@ -0,0 +1,130 @@
+package driver_test
+
+import (
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+func TestMetricsConformance(t *testing.T) {
+	rec := driver.NewStatsRecorder()
+	openkvtest.RunConformance(t, wrapStore{memkv.NewStore(), func(root driver.IDB) driver.IDB {
+		return driver.WithMetrics(root, rec)
+	}})
+
+	ops := map[driver.MetricOp]bool{}
+	for _, s := range rec.Stats() {
+		ops[s.Op] = true
+		if s.Calls == 0 {
+			t.Errorf("%s Got 0 calls", s.Op)
+		}
+	}
+	for _, op := range []driver.MetricOp{driver.MetricOpGet, driver.MetricOpPut, driver.MetricOpDelete,
+		driver.MetricOpBatchCommit, driver.MetricOpIterStep, driver.MetricOpSnapshotGet, driver.MetricOpCompact} {
+		if !ops[op] {
+			t.Errorf("%s is not recorded", op)
+		}
+	}
+}
+
+func TestStatsRecorder(t *testing.T) {
+	db, err := memkv.NewStore().Open("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := driver.NewStatsRecorder()
+	mdb := driver.WithMetrics(db, rec)
+	defer mdb.Close()
+
+	mdb.Put([]byte("a"), []byte("123"))
+	mdb.Get([]byte("a"))
+	mdb.Get([]byte("b"))
+	wb := mdb.NewWriteBatch()
+	wb.Put([]byte("b"), []byte("1"))
+	wb.Delete([]byte("a"))
+	wb.Commit()
+	wb.Close()
+
+	stats := map[driver.MetricOp]driver.MetricStat{}
+	for _, s := range rec.Stats() {
+		stats[s.Op] = s
+	}
+	if s := stats[driver.MetricOpGet]; s.Calls != 2 || s.Bytes != 5 {
+		t.Errorf("get Got calls %d bytes %d expected 2 5", s.Calls, s.Bytes)
+	}
+	if s := stats[driver.MetricOpPut]; s.Calls != 1 || s.Bytes != 4 {
+		t.Errorf("put Got calls %d bytes %d expected 1 4", s.Calls, s.Bytes)
+	}
+	if s := stats[driver.MetricOpBatchCommit]; s.Calls != 1 || s.Bytes != 3 {
+		t.Errorf("batch commit Got calls %d bytes %d expected 1 3", s.Calls, s.Bytes)
+	}
+	if p := stats[driver.MetricOpGet].Percentile(99); p == 0 {
+		t.Errorf("get p99 Got %d expected > 0", p)
+	}
+}
+
+func TestMetricsOptionalInterfaces(t *testing.T) {
+	store := memkv.NewStore()
+	db, err := store.Open("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := driver.NewStatsRecorder()
+	mdb := driver.WithMetrics(db, rec)
+	defer mdb.Close()
+
+	txn, err := driver.Begin(mdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Put([]byte("a"), []byte("1"))
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Checkpoint(mdb, store, "native"); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Checkpoint(driver.WithMetrics(plainDB{db}, rec), store, "fallback"); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"native", "fallback"} {
+		dst, err := store.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := dst.Get([]byte("a")); string(v) != "1" {
+			t.Errorf("%s Got %s expected %s", dir, v, "1")
+		}
+		dst.Close()
+	}
+
+	wb := mdb.NewWriteBatch()
+	driver.NamespaceWriteBatch(mdb, wb, "ns").Put([]byte("b"), []byte("2"))
+	wb.Commit()
+	wb.Close()
+	if v, _ := driver.Namespace(mdb, "ns").Get([]byte("b")); string(v) != "2" {
+		t.Errorf("Got %s expected %s", v, "2")
+	}
+	stats := map[driver.MetricOp]driver.MetricStat{}
+	for _, s := range rec.Stats() {
+		stats[s.Op] = s
+	}
+	if s := stats[driver.MetricOpBatchCommit]; s.Calls != 1 || s.Bytes != uint64(len(driver.NamespacePrefix("ns"))+2) {
+		t.Errorf("batch commit Got calls %d bytes %d expected 1 %d", s.Calls, s.Bytes, len(driver.NamespacePrefix("ns"))+2)
+	}
+	if s := stats[driver.MetricOpGet]; s.Calls != 1 {
+		t.Errorf("namespace get Got calls %d expected 1", s.Calls)
+	}
+}
+
+func TestMetricsBatchMergeUnsupported(t *testing.T) {
+	db, err := memkv.NewStore().Open("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	testBatchMergeUnsupported(t, driver.WithMetrics(db, driver.NewStatsRecorder()))
+}
@@ -0,0 +1,45 @@
+package driver
+
+// MultiGet get values of keys from db or snapshot,
+// use engine native batched lookup if g is IMultiGeter, else get keys one by one.
+// values[i]/errs[i] is the result of keys[i], not exists value is nil
+func MultiGet(g IGeter, keys [][]byte) (values [][]byte, errs []error) {
+	if m, ok := g.(IMultiGeter); ok {
+		return m.MultiGet(keys)
+	}
+
+	values, errs = make([][]byte, len(keys)), make([]error, len(keys))
+	for i, key := range keys {
+		values[i], errs[i] = g.Get(key)
+	}
+
+	return
+}
+
+// MultiGetSlice get value slices of keys from db or snapshot,
+// use engine native batched slice lookup if g is IMultiSliceGeter,
+// else get slice one by one by ISliceGeter, else wrap values of MultiGet to GoSlice.
+// slices[i]/errs[i] is the result of keys[i], not exists slice is nil,
+// free slices after used.
+func MultiGetSlice(g IGeter, keys [][]byte) (slices []ISlice, errs []error) {
+	if m, ok := g.(IMultiSliceGeter); ok {
+		return m.MultiGetSlice(keys)
+	}
+
+	slices, errs = make([]ISlice, len(keys)), make([]error, len(keys))
+	if sg, ok := g.(ISliceGeter); ok {
+		for i, key := range keys {
+			slices[i], errs[i] = sg.GetSlice(key)
+		}
+		return
+	}
+
+	values, errs := MultiGet(g, keys)
+	for i, v := range values {
+		if v != nil {
+			slices[i] = GoSlice(v)
+		}
+	}
+
+	return
+}
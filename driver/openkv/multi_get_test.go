@@ -0,0 +1,55 @@
+package driver_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+func TestMultiGetWrappers(t *testing.T) {
+	root, err := memkv.NewStore().Open("multiget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	rec := driver.NewStatsRecorder()
+	dbs := map[string]driver.IDB{
+		"plain":   &plainDB{root},
+		"ns":      driver.Namespace(root, "ns"),
+		"metrics": driver.WithMetrics(root, rec),
+	}
+	for name, db := range dbs {
+		db.Put([]byte(name+"a"), []byte(name))
+		values, errs := driver.MultiGet(db, [][]byte{[]byte(name + "a"), []byte(name + "b")})
+		if got := fmt.Sprintf("%s %q %v", values[0], values[1], errs); got != name+` "" [<nil> <nil>]` {
+			t.Errorf("%s Got %s", name, got)
+		}
+	}
+
+	// namespace keys are isolated
+	values, _ := driver.MultiGet(driver.Namespace(root, "other"), [][]byte{[]byte("nsa")})
+	if values[0] != nil {
+		t.Errorf("Got %q expected nil", values[0])
+	}
+
+	stats := rec.Stats()
+	found := false
+	for _, s := range stats {
+		if s.Op == driver.MetricOpMultiGet {
+			found = s.Calls == 1
+		}
+	}
+	if !found {
+		t.Errorf("multi_get metric Got %v", stats)
+	}
+
+	root.Close()
+	_, errs := driver.MultiGet(root, [][]byte{[]byte("a")})
+	if !errors.Is(errs[0], memkv.ErrClosed) {
+		t.Errorf("Got %v expected %v", errs[0], memkv.ErrClosed)
+	}
+}
@@ -0,0 +1,363 @@
+package driver
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Namespace return the db view of namespace keyspace,
+// use engine native namespace if db is INamespacer,
+// else return a prefix-isolated view, the prefix is uvarint(len(name)) + name,
+// so namespaces never collide with each other, but the keys which are not
+// in any namespace of db may collide with them, don't mix them.
+// Close the view is nothing to do, close the db instead.
+func Namespace(db IDB, name string) IDB {
+	if n, ok := db.(INamespacer); ok {
+		return n.Namespace(name)
+	}
+
+	return &nsDB{IDB: db, prefix: NamespacePrefix(name)}
+}
+
+// NamespaceWriteBatch return the batch view of namespace which ops write to wb,
+// wb is from db.NewWriteBatch, so ops in diff namespaces commit atomically, eg:
+//
+//	wb := db.NewWriteBatch()
+//	defer wb.Close()
+//	NamespaceWriteBatch(db, wb, "meta").Put(metaKey, meta)
+//	NamespaceWriteBatch(db, wb, "data").Put(dataKey, data)
+//	wb.Commit()
+func NamespaceWriteBatch(db IDB, wb IWriteBatch, name string) IWriteBatch {
+	if n, ok := db.(INamespacer); ok {
+		return n.NamespaceWriteBatch(wb, name)
+	}
+
+	return &nsWriteBatch{IWriteBatch: wb, db: db, prefix: NamespacePrefix(name)}
+}
+
+// NamespaceSnapshot return the snapshot view of namespace which read from snap,
+// snap is from db.NewSnapshot, so reads in diff namespaces are consistent.
+func NamespaceSnapshot(db IDB, snap ISnapshot, name string) ISnapshot {
+	if n, ok := db.(INamespacer); ok {
+		return n.NamespaceSnapshot(snap, name)
+	}
+
+	return &nsSnapshot{ISnapshot: snap, prefix: NamespacePrefix(name)}
+}
+
+// NamespacePrefix return the key prefix of namespace for prefix-isolated view
+func NamespacePrefix(name string) []byte {
+	prefix := make([]byte, 0, binary.MaxVarintLen32+len(name))
+	prefix = binary.AppendUvarint(prefix, uint64(len(name)))
+	return append(prefix, name...)
+}
+
+func nsKey(prefix, key []byte) []byte {
+	k := make([]byte, len(prefix)+len(key))
+	copy(k, prefix)
+	copy(k[len(prefix):], key)
+	return k
+}
+
+func nsKeys(prefix []byte, keys [][]byte) [][]byte {
+	nkeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		nkeys[i] = nsKey(prefix, key)
+	}
+	return nkeys
+}
+
+// nsRange return the range of namespace [prefix+start, prefix+end)
+func nsRange(prefix, start, end []byte) ([]byte, []byte) {
+	if len(end) > 0 {
+		end = nsKey(prefix, end)
+	} else {
+		end = PrefixUpperBound(prefix)
+	}
+
+	return nsKey(prefix, start), end
+}
+
+func nsIteratorOptions(prefix []byte, opts *IteratorOptions) *IteratorOptions {
+	nsOpts := &IteratorOptions{}
+	if opts != nil {
+		*nsOpts = *opts
+	}
+	nsOpts.LowerBound, nsOpts.UpperBound = nsRange(prefix, nsOpts.LowerBound, nsOpts.UpperBound)
+	nsOpts.Prefix = nsKey(prefix, nsOpts.Prefix)
+
+	return nsOpts
+}
+
+func newNsIterator(c IIteratorCreator, prefix []byte, opts *IteratorOptions) IIterator {
+	return &nsIterator{
+		IIterator: NewIteratorWithOptions(c, nsIteratorOptions(prefix, opts)),
+		prefix:    prefix,
+	}
+}
+
+// nsDB prefix-isolated db view
+type nsDB struct {
+	IDB
+	prefix []byte
+}
+
+func (db *nsDB) Close() error {
+	return nil
+}
+
+func (db *nsDB) Get(key []byte) ([]byte, error) {
+	return db.IDB.Get(nsKey(db.prefix, key))
+}
+
+func (db *nsDB) GetSlice(key []byte) (ISlice, error) {
+	if g, ok := db.IDB.(ISliceGeter); ok {
+		return g.GetSlice(nsKey(db.prefix, key))
+	}
+
+	v, err := db.Get(key)
+	if v == nil {
+		return nil, err
+	}
+	return GoSlice(v), nil
+}
+
+func (db *nsDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return MultiGet(db.IDB, nsKeys(db.prefix, keys))
+}
+
+func (db *nsDB) Put(key []byte, value []byte) error {
+	return db.IDB.Put(nsKey(db.prefix, key), value)
+}
+
+func (db *nsDB) Delete(key []byte) error {
+	return db.IDB.Delete(nsKey(db.prefix, key))
+}
+
+func (db *nsDB) SyncPut(key []byte, value []byte) error {
+	return db.IDB.SyncPut(nsKey(db.prefix, key), value)
+}
+
+func (db *nsDB) SyncDelete(key []byte) error {
+	return db.IDB.SyncDelete(nsKey(db.prefix, key))
+}
+
+func (db *nsDB) DeleteRange(start, end []byte) error {
+	start, end = nsRange(db.prefix, start, end)
+	return DeleteRange(db.IDB, start, end)
+}
+
+func (db *nsDB) Merge(key, operand []byte) error {
+	return Merge(db.IDB, nsKey(db.prefix, key), operand)
+}
+
+func (db *nsDB) nsRanges(ranges []Range) []Range {
+	nranges := make([]Range, len(ranges))
+	for i, r := range ranges {
+		nranges[i].Start, nranges[i].Limit = nsRange(db.prefix, r.Start, r.Limit)
+	}
+	return nranges
+}
+
+func (db *nsDB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(db.IDB, db.nsRanges(ranges)...)
+}
+
+func (db *nsDB) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(db.IDB, db.nsRanges(ranges)...)
+}
+
+func (db *nsDB) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(db.IDB, db.nsRanges([]Range{r})[0])
+}
+
+// Property the properties of keys are in namespace, others are of the whole db
+func (db *nsDB) Property(name string) (string, error) {
+	switch name {
+	case PropertyEstimateNumKeys:
+		n, err := db.ApproximateCount(Range{})
+		return strconv.FormatUint(n, 10), err
+	case PropertyEstimateLiveDataSize:
+		sizes, err := db.ApproximateSizes([]Range{{}})
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(sizes[0], 10), nil
+	}
+
+	return Property(db.IDB, name)
+}
+
+func (db *nsDB) CompactRange(start, end []byte) error {
+	start, end = nsRange(db.prefix, start, end)
+	return CompactRange(db.IDB, start, end)
+}
+
+func (db *nsDB) Begin() (ITxn, error) {
+	t, err := Begin(db.IDB)
+	if err != nil {
+		return nil, err
+	}
+	return &nsTxn{ITxn: t, prefix: db.prefix}, nil
+}
+
+func (db *nsDB) NewIterator() IIterator {
+	return newNsIterator(db.IDB, db.prefix, nil)
+}
+
+func (db *nsDB) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return newNsIterator(db.IDB, db.prefix, opts)
+}
+
+func (db *nsDB) NewWriteBatch() IWriteBatch {
+	return &nsWriteBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db.IDB, prefix: db.prefix, owned: true}
+}
+
+func (db *nsDB) NewSnapshot() (ISnapshot, error) {
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &nsSnapshot{ISnapshot: snap, prefix: db.prefix, owned: true}, nil
+}
+
+// nsWriteBatch prefix-isolated batch view,
+// Commit/Rollback/Data are the ops of the whole inner batch.
+type nsWriteBatch struct {
+	IWriteBatch
+	db     IDB
+	prefix []byte
+	// close inner batch when close
+	owned bool
+	// merge err, return from commit
+	err error
+}
+
+func (wb *nsWriteBatch) Put(key []byte, value []byte) {
+	wb.IWriteBatch.Put(nsKey(wb.prefix, key), value)
+}
+
+func (wb *nsWriteBatch) Delete(key []byte) {
+	wb.IWriteBatch.Delete(nsKey(wb.prefix, key))
+}
+
+func (wb *nsWriteBatch) DeleteRange(start, end []byte) {
+	start, end = nsRange(wb.prefix, start, end)
+	BatchDeleteRange(wb.db, wb.IWriteBatch, start, end)
+}
+
+func (wb *nsWriteBatch) Merge(key, operand []byte) {
+	if err := BatchMerge(wb.IWriteBatch, nsKey(wb.prefix, key), operand); err != nil && wb.err == nil {
+		wb.err = err
+	}
+}
+
+func (wb *nsWriteBatch) Commit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	return wb.IWriteBatch.Commit()
+}
+
+func (wb *nsWriteBatch) SyncCommit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	return wb.IWriteBatch.SyncCommit()
+}
+
+func (wb *nsWriteBatch) Rollback() error {
+	wb.err = nil
+	return wb.IWriteBatch.Rollback()
+}
+
+func (wb *nsWriteBatch) Close() {
+	if wb.owned {
+		wb.IWriteBatch.Close()
+	}
+}
+
+// nsSnapshot prefix-isolated snapshot view
+type nsSnapshot struct {
+	ISnapshot
+	prefix []byte
+	// close inner snapshot when close
+	owned bool
+}
+
+func (s *nsSnapshot) Get(key []byte) ([]byte, error) {
+	return s.ISnapshot.Get(nsKey(s.prefix, key))
+}
+
+func (s *nsSnapshot) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return MultiGet(s.ISnapshot, nsKeys(s.prefix, keys))
+}
+
+func (s *nsSnapshot) NewIterator() IIterator {
+	return newNsIterator(s.ISnapshot, s.prefix, nil)
+}
+
+func (s *nsSnapshot) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return newNsIterator(s.ISnapshot, s.prefix, opts)
+}
+
+func (s *nsSnapshot) Close() {
+	if s.owned {
+		s.ISnapshot.Close()
+	}
+}
+
+// NamespaceTxn return the txn view of namespace keyspace which ops go to txn,
+// txn is from Begin(db), for atomic cross-namespace transaction (eg: smove/lmove between types),
+// Commit/Rollback of the views is the whole txn.
+func NamespaceTxn(db IDB, txn ITxn, name string) ITxn {
+	if n, ok := db.(INamespacer); ok {
+		return n.NamespaceTxn(txn, name)
+	}
+
+	return &nsTxn{ITxn: txn, prefix: NamespacePrefix(name)}
+}
+
+type nsTxn struct {
+	ITxn
+	prefix []byte
+}
+
+func (t *nsTxn) Get(key []byte) ([]byte, error) {
+	return t.ITxn.Get(nsKey(t.prefix, key))
+}
+
+func (t *nsTxn) GetForUpdate(key []byte) ([]byte, error) {
+	return t.ITxn.GetForUpdate(nsKey(t.prefix, key))
+}
+
+func (t *nsTxn) Put(key []byte, value []byte) {
+	t.ITxn.Put(nsKey(t.prefix, key), value)
+}
+
+func (t *nsTxn) Delete(key []byte) {
+	t.ITxn.Delete(nsKey(t.prefix, key))
+}
+
+func (t *nsTxn) NewIterator() IIterator {
+	return newNsIterator(t.ITxn, t.prefix, nil)
+}
+
+// nsIterator strip the namespace prefix of keys, inner iterator is bounded in namespace
+type nsIterator struct {
+	IIterator
+	prefix []byte
+}
+
+func (it *nsIterator) Seek(key []byte) {
+	it.IIterator.Seek(nsKey(it.prefix, key))
+}
+
+func (it *nsIterator) Key() []byte {
+	key := it.IIterator.Key()
+	if len(key) < len(it.prefix) {
+		return nil
+	}
+	return key[len(it.prefix):]
+}
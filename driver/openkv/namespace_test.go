@@ -0,0 +1,97 @@
+package driver_test
+
+import (
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+)
+
+// nsStore open namespace view of memory db
+type nsStore struct {
+	*memkv.Store
+}
+
+type nsTestDB struct {
+	driver.IDB
+	root driver.IDB
+}
+
+func (db *nsTestDB) Close() error {
+	return db.root.Close()
+}
+
+func (s nsStore) Open(path string) (driver.IDB, error) {
+	root, err := s.Store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// keys out of namespace must be invisible
+	root.Put([]byte("out"), []byte("out"))
+	driver.Namespace(root, "other").Put([]byte("other"), []byte("other"))
+
+	return &nsTestDB{IDB: driver.Namespace(root, "ns"), root: root}, nil
+}
+
+func TestNamespaceConformance(t *testing.T) {
+	openkvtest.RunConformance(t, nsStore{memkv.NewStore()})
+}
+
+func TestNamespaceAtomicBatch(t *testing.T) {
+	db, err := memkv.NewStore().Open("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	meta, data := driver.Namespace(db, "meta"), driver.Namespace(db, "data")
+	meta.Put([]byte("k"), []byte("meta0"))
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	driver.NamespaceWriteBatch(db, wb, "meta").Put([]byte("k"), []byte("meta1"))
+	driver.NamespaceWriteBatch(db, wb, "data").Put([]byte("k"), []byte("data1"))
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := meta.Get([]byte("k")); string(v) != "meta1" {
+		t.Errorf("Got %s expected %s", v, "meta1")
+	}
+	if v, _ := data.Get([]byte("k")); string(v) != "data1" {
+		t.Errorf("Got %s expected %s", v, "data1")
+	}
+	if v, _ := driver.NamespaceSnapshot(db, snap, "meta").Get([]byte("k")); string(v) != "meta0" {
+		t.Errorf("Got %s expected %s", v, "meta0")
+	}
+	if v, _ := driver.NamespaceSnapshot(db, snap, "data").Get([]byte("k")); v != nil {
+		t.Errorf("Got %s expected nil", v)
+	}
+
+	// a namespace is not a prefix of another one
+	driver.Namespace(db, "me").Put([]byte("tak"), []byte("x"))
+	it := meta.NewIterator()
+	defer it.Close()
+	if got := iterKeys(it); got != "[k]" {
+		t.Errorf("Got %s expected %s", got, "[k]")
+	}
+
+	driver.DeleteRange(data, nil, nil)
+	if v, _ := meta.Get([]byte("k")); string(v) != "meta1" {
+		t.Errorf("Got %s expected %s", v, "meta1")
+	}
+}
+
+func TestNamespaceBatchMergeUnsupported(t *testing.T) {
+	db, err := memkv.NewStore().Open("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	testBatchMergeUnsupported(t, driver.Namespace(db, "ns"))
+}
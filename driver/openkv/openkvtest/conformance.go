@@ -0,0 +1,687 @@
+// Package openkvtest conformance test suite for openkv engines,
+// engine adapter test just run:
+//
+//	func TestConformance(t *testing.T) {
+//		openkvtest.RunConformance(t, store)
+//	}
+package openkvtest
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+)
+
+// IDestroyer store which can drop the db of path after test
+type IDestroyer interface {
+	Destroy(path string) error
+}
+
+// RunConformance test store against the contracts of openkv interfaces
+func RunConformance(t *testing.T, store driver.IStore) {
+	cases := []struct {
+		name string
+		f    func(t *testing.T, store driver.IStore, path string)
+	}{
+		{"GetPutDelete", testGetPutDelete},
+		{"ValueOwnership", testValueOwnership},
+		{"SyncPutDelete", testSyncPutDelete},
+		{"IteratorOrder", testIteratorOrder},
+		{"IteratorSeek", testIteratorSeek},
+		{"IteratorEdge", testIteratorEdge},
+		{"IteratorEmpty", testIteratorEmpty},
+		{"IteratorOptions", testIteratorOptions},
+		{"SnapshotIsolation", testSnapshotIsolation},
+		{"BatchAtomicity", testBatchAtomicity},
+		{"BatchRollback", testBatchRollback},
+		{"DeleteRange", testDeleteRange},
+		{"BackupRestore", testBackupRestore},
+		{"Checkpoint", testCheckpoint},
+		{"RepairAfterClose", testRepairAfterClose},
+		{"GetSlice", testGetSlice},
+		{"Compact", testCompact},
+		{"MultiGet", testMultiGet},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			path := t.TempDir()
+			if d, ok := store.(IDestroyer); ok {
+				t.Cleanup(func() { d.Destroy(path) })
+			}
+			c.f(t, store, path)
+		})
+	}
+}
+
+func open(t *testing.T, store driver.IStore, path string) driver.IDB {
+	t.Helper()
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatalf("open %s error: %v", path, err)
+	}
+	return db
+}
+
+func openWithCleanup(t *testing.T, store driver.IStore, path string) driver.IDB {
+	t.Helper()
+	db := open(t, store, path)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func checkGet(t *testing.T, db interface {
+	Get(key []byte) ([]byte, error)
+}, key string, want []byte) {
+	t.Helper()
+	v, err := db.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("get %s error: %v", key, err)
+	}
+	if want == nil {
+		if v != nil {
+			t.Errorf("get %s Got %q expected nil", key, v)
+		}
+		return
+	}
+	if v == nil || !bytes.Equal(v, want) {
+		t.Errorf("get %s Got %q expected %q", key, v, want)
+	}
+}
+
+func checkKeys(t *testing.T, it driver.IIterator, want ...string) {
+	t.Helper()
+	keys := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iterator error: %v", err)
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("iterate keys Got %v expected %v", keys, want)
+	}
+}
+
+func checkIter(t *testing.T, it driver.IIterator, want string) {
+	t.Helper()
+	if want == "" {
+		if it.Valid() {
+			t.Errorf("iterator Got %q expected invalid", it.Key())
+		}
+		return
+	}
+	if !it.Valid() {
+		t.Errorf("iterator Got invalid expected %q", want)
+		return
+	}
+	if string(it.Key()) != want {
+		t.Errorf("iterator Got %q expected %q", it.Key(), want)
+	}
+}
+
+func testGetPutDelete(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	checkGet(t, db, "a", nil)
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", []byte("1"))
+	db.Put([]byte("a"), []byte("2"))
+	checkGet(t, db, "a", []byte("2"))
+	if err := db.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", nil)
+	// delete not exists key is ok
+	if err := db.Delete([]byte("not-exists")); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("empty"), []byte{})
+	checkGet(t, db, "empty", []byte{})
+}
+
+func testValueOwnership(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	key, val := []byte("a"), []byte("1")
+	db.Put(key, val)
+	// reuse the put buffers
+	key[0], val[0] = 'b', '2'
+	checkGet(t, db, "a", []byte("1"))
+	checkGet(t, db, "b", nil)
+
+	v, _ := db.Get([]byte("a"))
+	v[0] = '3'
+	checkGet(t, db, "a", []byte("1"))
+}
+
+func testSyncPutDelete(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	if err := db.SyncPut([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", []byte("1"))
+	if err := db.SyncDelete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", nil)
+}
+
+func testIteratorOrder(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	keys := []string{"b", "a", "c\x00", "c", "\x00", "ab", "\xff"}
+	for _, k := range keys {
+		db.Put([]byte(k), []byte(k))
+	}
+	db.Delete([]byte("ab"))
+
+	it := db.NewIterator()
+	defer it.Close()
+	checkKeys(t, it, "\x00", "a", "b", "c", "c\x00", "\xff")
+
+	for it.First(); it.Valid(); it.Next() {
+		if !bytes.Equal(it.Key(), it.Value()) {
+			t.Errorf("key %q Got value %q", it.Key(), it.Value())
+		}
+	}
+
+	rev := []string{}
+	for it.Last(); it.Valid(); it.Prev() {
+		rev = append(rev, string(it.Key()))
+	}
+	if fmt.Sprint(rev) != fmt.Sprint([]string{"\xff", "c\x00", "c", "b", "a", "\x00"}) {
+		t.Errorf("reverse iterate keys Got %q", rev)
+	}
+}
+
+func testIteratorSeek(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	for _, k := range []string{"b", "d", "f"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	it.Seek([]byte("d"))
+	checkIter(t, it, "d")
+	it.Seek([]byte("c"))
+	checkIter(t, it, "d")
+	it.Seek([]byte("a"))
+	checkIter(t, it, "b")
+	it.Seek([]byte("e"))
+	checkIter(t, it, "f")
+	it.Prev()
+	checkIter(t, it, "d")
+	it.Next()
+	checkIter(t, it, "f")
+	// seek past the last key
+	it.Seek([]byte("g"))
+	checkIter(t, it, "")
+	// seek after invalid
+	it.Seek([]byte("b"))
+	checkIter(t, it, "b")
+}
+
+func testIteratorEdge(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	for _, k := range []string{"b", "d", "f"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	// prev after first
+	it.First()
+	checkIter(t, it, "b")
+	it.Prev()
+	checkIter(t, it, "")
+
+	// next after last
+	it.Last()
+	checkIter(t, it, "f")
+	it.Next()
+	checkIter(t, it, "")
+
+	// reposition after invalid
+	it.First()
+	checkIter(t, it, "b")
+	it.Last()
+	checkIter(t, it, "f")
+}
+
+func testIteratorEmpty(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	it.First()
+	checkIter(t, it, "")
+	it.Last()
+	checkIter(t, it, "")
+	it.Seek([]byte("a"))
+	checkIter(t, it, "")
+	if err := it.Error(); err != nil {
+		t.Errorf("iterator error: %v", err)
+	}
+}
+
+func testIteratorOptions(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	for _, k := range []string{"a", "b", "ba", "bb", "b\xff", "c"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	it := driver.NewIteratorWithOptions(db, &driver.IteratorOptions{Prefix: []byte("b")})
+	checkKeys(t, it, "b", "ba", "bb", "b\xff")
+	it.Seek([]byte("a"))
+	checkIter(t, it, "b")
+	it.Last()
+	checkIter(t, it, "b\xff")
+	it.Next()
+	checkIter(t, it, "")
+	it.Close()
+
+	it = driver.NewIteratorWithOptions(db, &driver.IteratorOptions{LowerBound: []byte("az"), UpperBound: []byte("bb")})
+	checkKeys(t, it, "b", "ba")
+	it.First()
+	it.Prev()
+	checkIter(t, it, "")
+	it.Close()
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	it = driver.NewIteratorWithOptions(snap, &driver.IteratorOptions{UpperBound: []byte("bb"), Reverse: true})
+	checkKeys(t, it, "ba", "b", "a")
+	it.Seek([]byte("b0"))
+	checkIter(t, it, "b")
+	it.Close()
+}
+
+func testSnapshotIsolation(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("1"))
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	// later writes
+	db.Put([]byte("a"), []byte("2"))
+	db.Delete([]byte("b"))
+	db.Put([]byte("c"), []byte("2"))
+	wb := db.NewWriteBatch()
+	wb.Put([]byte("d"), []byte("2"))
+	wb.Commit()
+	wb.Close()
+	db.Compact()
+
+	checkGet(t, snap, "a", []byte("1"))
+	checkGet(t, snap, "b", []byte("1"))
+	checkGet(t, snap, "c", nil)
+	checkGet(t, snap, "d", nil)
+
+	it := snap.NewIterator()
+	checkKeys(t, it, "a", "b")
+	it.Close()
+
+	checkGet(t, db, "a", []byte("2"))
+	checkGet(t, db, "b", nil)
+	it = db.NewIterator()
+	checkKeys(t, it, "a", "c", "d")
+	it.Close()
+}
+
+func testBatchAtomicity(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	db.Put([]byte("x"), []byte("0"))
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	for i := 0; i < 10; i++ {
+		wb.Put([]byte(fmt.Sprintf("k%d", i)), []byte("1"))
+	}
+	wb.Delete([]byte("x"))
+	// the later op of same key wins
+	wb.Put([]byte("k0"), []byte("2"))
+	wb.Delete([]byte("k9"))
+
+	// not visible before commit
+	checkGet(t, db, "k1", nil)
+	checkGet(t, db, "x", []byte("0"))
+	before, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer before.Close()
+
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// all visible after commit
+	checkGet(t, db, "k0", []byte("2"))
+	for i := 1; i < 9; i++ {
+		checkGet(t, db, fmt.Sprintf("k%d", i), []byte("1"))
+	}
+	checkGet(t, db, "k9", nil)
+	checkGet(t, db, "x", nil)
+
+	// snapshot before commit sees none of them
+	it := before.NewIterator()
+	checkKeys(t, it, "x")
+	it.Close()
+}
+
+func testBatchRollback(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	wb.Put([]byte("a"), []byte("1"))
+	wb.Put([]byte("b"), []byte("1"))
+	if err := wb.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", nil)
+	checkGet(t, db, "b", nil)
+
+	// batch is reusable after rollback
+	wb.Put([]byte("c"), []byte("1"))
+	if err := wb.SyncCommit(); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "a", nil)
+	checkGet(t, db, "c", []byte("1"))
+
+	wb.Rollback()
+	if len(wb.Data()) != 0 {
+		t.Errorf("batch data Got %q after rollback expected empty", wb.Data())
+	}
+}
+
+func testDeleteRange(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	for _, k := range []string{"a", "b", "ba", "c", "d"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	if err := driver.DeleteRange(db, []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	it := db.NewIterator()
+	checkKeys(t, it, "a", "c", "d")
+	it.Close()
+	// snapshot isolation from range delete
+	checkGet(t, snap, "ba", []byte("ba"))
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	wb.Put([]byte("b"), []byte("b"))
+	if err := driver.BatchDeleteRange(db, wb, []byte("c"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, "c", []byte("c"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	it = db.NewIterator()
+	checkKeys(t, it, "a", "b")
+	it.Close()
+
+	if err := driver.DeleteRange(db, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	it = db.NewIterator()
+	checkKeys(t, it)
+	it.Close()
+}
+
+func fillKeys(db driver.IDB, n int) {
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	for i := 0; i < n; i++ {
+		wb.Put([]byte(fmt.Sprintf("k%04d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	wb.Commit()
+}
+
+func checkSameKeys(t *testing.T, src, dst driver.IIteratorCreator) {
+	t.Helper()
+	sit, dit := src.NewIterator(), dst.NewIterator()
+	defer sit.Close()
+	defer dit.Close()
+
+	dit.First()
+	for sit.First(); sit.Valid(); sit.Next() {
+		if !dit.Valid() {
+			t.Fatalf("key %q not in dst", sit.Key())
+		}
+		if !bytes.Equal(sit.Key(), dit.Key()) || !bytes.Equal(sit.Value(), dit.Value()) {
+			t.Fatalf("Got %q:%q expected %q:%q", dit.Key(), dit.Value(), sit.Key(), sit.Value())
+		}
+		dit.Next()
+	}
+	if dit.Valid() {
+		t.Fatalf("dst has more key %q", dit.Key())
+	}
+}
+
+func tempPath(t *testing.T, store driver.IStore) string {
+	path := filepath.Join(t.TempDir(), "db")
+	if d, ok := store.(IDestroyer); ok {
+		t.Cleanup(func() { d.Destroy(path) })
+	}
+	return path
+}
+
+func testBackupRestore(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	fillKeys(db, 100)
+	db.Put([]byte("empty"), []byte{})
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	buf := &bytes.Buffer{}
+	if err := driver.BackupSnapshot(snap, buf); err != nil {
+		t.Fatal(err)
+	}
+	// writes after snapshot are not in backup
+	db.Put([]byte("later"), []byte("later"))
+
+	dstPath := tempPath(t, store)
+	if err := driver.Restore(store, buf, dstPath); err != nil {
+		t.Fatal(err)
+	}
+	dst := openWithCleanup(t, store, dstPath)
+	checkSameKeys(t, snap, dst)
+	checkGet(t, dst, "later", nil)
+
+	buf.Reset()
+	if err := driver.Backup(db, buf); err != nil {
+		t.Fatal(err)
+	}
+	dstPath = tempPath(t, store)
+	if err := driver.Restore(store, buf, dstPath); err != nil {
+		t.Fatal(err)
+	}
+	dst = openWithCleanup(t, store, dstPath)
+	checkSameKeys(t, db, dst)
+}
+
+func testCheckpoint(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	fillKeys(db, 100)
+
+	dstPath := tempPath(t, store)
+	if err := driver.Checkpoint(db, store, dstPath); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("later"), []byte("later"))
+
+	dst := openWithCleanup(t, store, dstPath)
+	checkGet(t, dst, "k0001", []byte("v1"))
+	checkGet(t, dst, "later", nil)
+	db.Delete([]byte("later"))
+	checkSameKeys(t, db, dst)
+}
+
+func testRepairAfterClose(t *testing.T, store driver.IStore, path string) {
+	db := open(t, store, path)
+	db.Put([]byte("a"), []byte("1"))
+	db.SyncPut([]byte("b"), []byte("1"))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Repair(path); err != nil {
+		t.Fatalf("repair error: %v", err)
+	}
+
+	db = openWithCleanup(t, store, path)
+	checkGet(t, db, "a", []byte("1"))
+	checkGet(t, db, "b", []byte("1"))
+}
+
+func testGetSlice(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	g, ok := db.(driver.ISliceGeter)
+	if !ok {
+		t.Skipf("store %s db is not ISliceGeter", store.Name())
+	}
+
+	s, err := g.GetSlice([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != nil && s.Size() != 0 {
+		t.Errorf("not exists key slice Got %q", s.Data())
+	}
+
+	db.Put([]byte("a"), []byte("1"))
+	s, err = g.GetSlice([]byte("a"))
+	if err != nil || s == nil {
+		t.Fatalf("GetSlice Got %v %v", s, err)
+	}
+	// slice keeps the value until free, even if key is overwritten
+	db.Put([]byte("a"), []byte("2"))
+	db.Delete([]byte("a"))
+	db.Compact()
+	if string(s.Data()) != "1" || s.Size() != 1 {
+		t.Errorf("slice Got %q size %d expected %q", s.Data(), s.Size(), "1")
+	}
+	s.Free()
+}
+
+func testCompact(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+	}
+	for i := 0; i < 100; i += 2 {
+		db.Delete([]byte(fmt.Sprintf("k%03d", i)))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	it := db.NewIterator()
+	defer it.Close()
+	for it.First(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 50 {
+		t.Errorf("Got %d keys after compact expected %d", n, 50)
+	}
+}
+
+func testMultiGet(t *testing.T, store driver.IStore, path string) {
+	db := openWithCleanup(t, store, path)
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("c"), []byte("3"))
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("a")}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	db.Put([]byte("b"), []byte("2"))
+	db.Delete([]byte("c"))
+
+	checkMulti := func(name string, g driver.IGeter, expected []string) {
+		t.Helper()
+		values, errs := driver.MultiGet(g, keys)
+		slices, serrs := driver.MultiGetSlice(g, keys)
+		if len(values) != len(keys) || len(errs) != len(keys) || len(slices) != len(keys) || len(serrs) != len(keys) {
+			t.Fatalf("%s result len Got %d %d %d %d expected %d", name, len(values), len(errs), len(slices), len(serrs), len(keys))
+		}
+		for i := range keys {
+			if errs[i] != nil || serrs[i] != nil {
+				t.Fatalf("%s key %s error %v %v", name, keys[i], errs[i], serrs[i])
+			}
+			if expected[i] == "" {
+				if values[i] != nil {
+					t.Errorf("%s not exists key %s Got %q", name, keys[i], values[i])
+				}
+				if slices[i] != nil && slices[i].Size() != 0 {
+					t.Errorf("%s not exists key %s slice Got %q", name, keys[i], slices[i].Data())
+				}
+				continue
+			}
+			if string(values[i]) != expected[i] {
+				t.Errorf("%s key %s Got %q expected %q", name, keys[i], values[i], expected[i])
+			}
+			if slices[i] == nil || string(slices[i].Data()) != expected[i] {
+				t.Errorf("%s key %s slice Got %v expected %q", name, keys[i], slices[i], expected[i])
+			}
+		}
+		for _, s := range slices {
+			if s != nil {
+				s.Free()
+			}
+		}
+	}
+
+	checkMulti("db", db, []string{"1", "2", "", "1"})
+	checkMulti("snapshot", snap, []string{"1", "", "3", "1"})
+
+	// values are owned by caller
+	values, _ := driver.MultiGet(db, keys[:1])
+	values[0][0] = 'x'
+	checkGet(t, db, "a", []byte("1"))
+
+	values, errs := driver.MultiGet(db, nil)
+	if len(values) != 0 || len(errs) != 0 {
+		t.Errorf("empty keys Got %v %v", values, errs)
+	}
+}
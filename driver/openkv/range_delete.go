@@ -0,0 +1,55 @@
+package driver
+
+// DeleteRangeBatchSize fallback DeleteRange commit once per batch size keys
+var DeleteRangeBatchSize = 1024
+
+// DeleteRange delete keys in [start, end), nil or empty start/end is unbounded.
+// use engine native range delete if db is IRangeDeleter,
+// else iterate the range and delete keys with write batches,
+// fallback is not atomic, commit once per DeleteRangeBatchSize keys.
+func DeleteRange(db IDB, start, end []byte) error {
+	if d, ok := db.(IRangeDeleter); ok {
+		return d.DeleteRange(start, end)
+	}
+
+	it := NewIteratorWithOptions(db, &IteratorOptions{LowerBound: start, UpperBound: end, DontFillCache: true})
+	defer it.Close()
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		wb.Delete(it.Key())
+		if n++; n%DeleteRangeBatchSize == 0 {
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+			wb.Rollback()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return wb.Commit()
+}
+
+// BatchDeleteRange add delete keys in [start, end) to write batch,
+// use batch native range delete if wb is IWriteBatchRangeDeleter,
+// else add delete op for each key which is in the range of db now.
+func BatchDeleteRange(db IDB, wb IWriteBatch, start, end []byte) error {
+	if d, ok := wb.(IWriteBatchRangeDeleter); ok {
+		d.DeleteRange(start, end)
+		return nil
+	}
+
+	it := NewIteratorWithOptions(db, &IteratorOptions{LowerBound: start, UpperBound: end, DontFillCache: true})
+	defer it.Close()
+
+	for it.First(); it.Valid(); it.Next() {
+		wb.Delete(it.Key())
+	}
+
+	return it.Error()
+}
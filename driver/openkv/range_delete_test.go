@@ -0,0 +1,38 @@
+package driver_test
+
+import (
+	"fmt"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+// plainDB hide engine optional interfaces to test generic fallback
+type plainDB struct {
+	driver.IDB
+}
+
+func TestDeleteRangeFallback(t *testing.T) {
+	mdb, err := memkv.NewStore().Open("delrange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close()
+	db := plainDB{mdb}
+
+	defer func(n int) { driver.DeleteRangeBatchSize = n }(driver.DeleteRangeBatchSize)
+	driver.DeleteRangeBatchSize = 3
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+	}
+	if err := driver.DeleteRange(db, []byte("k2"), []byte("k9")); err != nil {
+		t.Fatal(err)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+	if got := iterKeys(it); got != "[k0 k1 k9]" {
+		t.Errorf("Got %s expected %s", got, "[k0 k1 k9]")
+	}
+}
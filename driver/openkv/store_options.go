@@ -0,0 +1,297 @@
+package driver
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/weedge/pkg/option"
+)
+
+// StoreOptions engine tuning options, zero value is engine default,
+// can be unmarshaled from config (configparser.Parser.UnmarshalExact).
+type StoreOptions struct {
+	// CacheSize block cache bytes
+	CacheSize int64 `mapstructure:"cacheSize"`
+	// WriteBufferSize memtable bytes before flush
+	WriteBufferSize int64 `mapstructure:"writeBufferSize"`
+	// BloomBits bloom filter bits per key
+	BloomBits int `mapstructure:"bloomBits"`
+	// SyncPolicy when to sync writes to stable disk, SyncPolicyXXX
+	SyncPolicy string `mapstructure:"syncPolicy"`
+
+	// Extra engine specific options, the other keys of config
+	Extra map[string]interface{} `mapstructure:",remain"`
+}
+
+const (
+	// SyncPolicyNone only SyncPut/SyncDelete/SyncCommit sync
+	SyncPolicyNone = "none"
+	// SyncPolicyAlways sync every write
+	SyncPolicyAlways = "always"
+)
+
+func (o *StoreOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+// Settings return the options which are set, key is the option name
+func (o *StoreOptions) Settings() map[string]interface{} {
+	m := map[string]interface{}{}
+	if o.CacheSize != 0 {
+		m["cacheSize"] = o.CacheSize
+	}
+	if o.WriteBufferSize != 0 {
+		m["writeBufferSize"] = o.WriteBufferSize
+	}
+	if o.BloomBits != 0 {
+		m["bloomBits"] = o.BloomBits
+	}
+	if o.SyncPolicy != "" {
+		m["syncPolicy"] = o.SyncPolicy
+	}
+	for k, v := range o.Extra {
+		m[k] = v
+	}
+
+	return m
+}
+
+// NewStoreOptions apply opts to zero StoreOptions
+func NewStoreOptions(opts ...option.Option) *StoreOptions {
+	o := &StoreOptions{}
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+
+	return o
+}
+
+func WithCacheSize(n int64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		o.CacheSize = n
+	})
+}
+
+func WithWriteBufferSize(n int64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		o.WriteBufferSize = n
+	})
+}
+
+func WithBloomBits(n int) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		o.BloomBits = n
+	})
+}
+
+func WithSyncPolicy(policy string) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		o.SyncPolicy = policy
+	})
+}
+
+// WithExtraOption set engine specific option
+func WithExtraOption(name string, value interface{}) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		if o.Extra == nil {
+			o.Extra = map[string]interface{}{}
+		}
+		o.Extra[name] = value
+	})
+}
+
+// WithStoreOptions use opts, eg: unmarshaled from config
+func WithStoreOptions(opts StoreOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*StoreOptions)
+		if !ok {
+			return
+		}
+		*o = opts
+		o.Extra = make(map[string]interface{}, len(opts.Extra))
+		for k, v := range opts.Extra {
+			o.Extra[k] = v
+		}
+	})
+}
+
+// OptionType the value type of option
+type OptionType string
+
+const (
+	OptionTypeInt    OptionType = "int"
+	OptionTypeString OptionType = "string"
+	OptionTypeBool   OptionType = "bool"
+)
+
+// OptionSpec describe one option which store supports
+type OptionSpec struct {
+	// Name option name, same as config key
+	Name    string
+	Type    OptionType
+	Default interface{}
+	// Enum valid values of string option, empty is any
+	Enum []string
+	// Min/Max valid range of int option, both 0 is any
+	Min, Max int64
+	Desc     string
+}
+
+// validate check value type and range
+func (s *OptionSpec) validate(value interface{}) error {
+	switch s.Type {
+	case OptionTypeInt:
+		v, ok := toInt(value)
+		if !ok {
+			return fmt.Errorf("option %s value %v is not int", s.Name, value)
+		}
+		if (s.Min != 0 || s.Max != 0) && (v < s.Min || v > s.Max) {
+			return fmt.Errorf("option %s value %d is out of range [%d, %d]", s.Name, v, s.Min, s.Max)
+		}
+	case OptionTypeString:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("option %s value %v is not string", s.Name, value)
+		}
+		if len(s.Enum) == 0 {
+			return nil
+		}
+		for _, e := range s.Enum {
+			if v == e {
+				return nil
+			}
+		}
+		return fmt.Errorf("option %s value %q is not in %v", s.Name, v, s.Enum)
+	case OptionTypeBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("option %s value %v is not bool", s.Name, value)
+		}
+	}
+
+	return nil
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+// IOptionsStore interface for store which can open db with options
+type IOptionsStore interface {
+	IStore
+	// OptionSpecs the options which store supports
+	OptionSpecs() []OptionSpec
+	// OpenWithOptions open db of path with options, opts are applied to StoreOptions
+	OpenWithOptions(path string, opts ...option.Option) (IDB, error)
+}
+
+// StoreInfo store name and the options which it supports
+type StoreInfo struct {
+	Name    string
+	Options []OptionSpec
+}
+
+// GetStoreInfo get the info of store
+func GetStoreInfo(store IStore) StoreInfo {
+	info := StoreInfo{Name: store.Name()}
+	if s, ok := store.(IOptionsStore); ok {
+		info.Options = s.OptionSpecs()
+	}
+
+	return info
+}
+
+// ListStoreInfos list the infos of registered stores, order by name
+func ListStoreInfos() []StoreInfo {
+	names := ListStores()
+	sort.Strings(names)
+
+	infos := make([]StoreInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, GetStoreInfo(dbs[name]))
+	}
+
+	return infos
+}
+
+// ValidateStoreOptions check the options which are set are supported by store,
+// and their values are valid, eg: validate config before server start
+func ValidateStoreOptions(store IStore, opts *StoreOptions) error {
+	specs := map[string]*OptionSpec{}
+	info := GetStoreInfo(store)
+	for i := range info.Options {
+		specs[info.Options[i].Name] = &info.Options[i]
+	}
+
+	settings := opts.Settings()
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec, ok := specs[name]
+		if !ok {
+			return fmt.Errorf("store %s doesn't support option %s", info.Name, name)
+		}
+		if err := spec.validate(settings[name]); err != nil {
+			return fmt.Errorf("store %s %w", info.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// OpenWithOptions validate opts and open db of path with them,
+// store without IOptionsStore is opened by Open if no option is set.
+func OpenWithOptions(store IStore, path string, opts ...option.Option) (IDB, error) {
+	if err := ValidateStoreOptions(store, NewStoreOptions(opts...)); err != nil {
+		return nil, err
+	}
+	if s, ok := store.(IOptionsStore); ok {
+		return s.OpenWithOptions(path, opts...)
+	}
+
+	return store.Open(path)
+}
@@ -0,0 +1,105 @@
+package driver_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weedge/pkg/configparser"
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+// noOptionsStore hide IOptionsStore of memory store
+type noOptionsStore struct {
+	driver.IStore
+}
+
+func TestStoreOptionsConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte(`
+store:
+  name: memory
+  options:
+    writeBufferSize: 1024
+    cacheSize: 4096
+    syncPolicy: always
+    compression: zstd
+`), 0644)
+	p, err := configparser.NewParserFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := struct {
+		Store struct {
+			Name    string              `mapstructure:"name"`
+			Options driver.StoreOptions `mapstructure:"options"`
+		} `mapstructure:"store"`
+	}{}
+	if err := p.UnmarshalExact(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := cfg.Store.Options
+	if opts.WriteBufferSize != 1024 || opts.CacheSize != 4096 || opts.SyncPolicy != driver.SyncPolicyAlways || opts.Extra["compression"] != "zstd" {
+		t.Errorf("Got %+v", opts)
+	}
+
+	store, err := driver.GetStore(cfg.Store.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = driver.ValidateStoreOptions(store, &opts)
+	if err == nil || !strings.Contains(err.Error(), "cacheSize") {
+		t.Errorf("Got %v expected unsupported cacheSize", err)
+	}
+
+	opts.CacheSize, opts.SyncPolicy, opts.Extra = 0, "", nil
+	if err := driver.ValidateStoreOptions(store, &opts); err != nil {
+		t.Errorf("Got %v expected nil", err)
+	}
+	opts.WriteBufferSize = -1
+	if err := driver.ValidateStoreOptions(store, &opts); err == nil {
+		t.Errorf("Got nil expected out of range error")
+	}
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	mem := memkv.NewStore()
+	if _, err := driver.OpenWithOptions(mem, "opts", driver.WithBloomBits(10)); err == nil {
+		t.Errorf("Got nil expected unsupported bloomBits")
+	}
+	if _, err := driver.OpenWithOptions(mem, "opts", driver.WithExtraOption("writeBufferSize", "1k")); err == nil {
+		t.Errorf("Got nil expected not int error")
+	}
+
+	db, err := driver.OpenWithOptions(mem, "opts", driver.WithWriteBufferSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	plain := noOptionsStore{mem}
+	if _, err := driver.OpenWithOptions(plain, "opts", driver.WithWriteBufferSize(1)); err == nil {
+		t.Errorf("Got nil expected unsupported writeBufferSize")
+	}
+	db, err = driver.OpenWithOptions(plain, "opts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if info := driver.GetStoreInfo(plain); len(info.Options) != 0 {
+		t.Errorf("Got %v expected no options", info.Options)
+	}
+	found := false
+	for _, info := range driver.ListStoreInfos() {
+		if info.Name == memkv.StoreName {
+			found = len(info.Options) == 1 && info.Options[0].Name == "writeBufferSize"
+		}
+	}
+	if !found {
+		t.Errorf("memory store info Got %v", driver.ListStoreInfos())
+	}
+}
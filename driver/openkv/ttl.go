@@ -0,0 +1,468 @@
+package driver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils/jobutils"
+)
+
+var ErrTTLCorrupted = errors.New("ttl meta or index corrupted")
+
+// ExpiredFunc called when key is expired, add the cleanup ops of key (eg: sub-keys of hash) to wb,
+// wb commits them with the removal of ttl atomically, return error to keep the key,
+// the key is retried by active cycle after RetryDelay.
+type ExpiredFunc func(wb IWriteBatch, key []byte) error
+
+// TTLOptions ttl subsystem options
+type TTLOptions struct {
+	// Namespace the ttl keyspace, meta is Namespace+":meta", index is Namespace+":index"
+	Namespace string `mapstructure:"namespace"`
+	// CycleInterval active expire cycle interval, 0 is disabled (lazy expiry only)
+	CycleInterval time.Duration `mapstructure:"cycleInterval"`
+	// CycleKeys max keys expired in one cycle
+	CycleKeys int `mapstructure:"cycleKeys"`
+	// CycleTime max time of one cycle
+	CycleTime time.Duration `mapstructure:"cycleTime"`
+	// RetryDelay the key which is failed to expire in cycle is retried after it
+	RetryDelay time.Duration `mapstructure:"retryDelay"`
+
+	now func() time.Time
+}
+
+func DefaultTTLOptions() *TTLOptions {
+	return &TTLOptions{
+		Namespace:     "ttl",
+		CycleInterval: 100 * time.Millisecond,
+		CycleKeys:     200,
+		CycleTime:     25 * time.Millisecond,
+		RetryDelay:    time.Second,
+		now:           time.Now,
+	}
+}
+
+func (o *TTLOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithTTLNamespace(name string) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*TTLOptions)
+		if !ok {
+			return
+		}
+		o.Namespace = name
+	})
+}
+
+// WithTTLCycle set active expire cycle interval and budget of one cycle
+func WithTTLCycle(interval time.Duration, keys int, cycleTime time.Duration) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*TTLOptions)
+		if !ok {
+			return
+		}
+		o.CycleInterval, o.CycleKeys, o.CycleTime = interval, keys, cycleTime
+	})
+}
+
+// WithTTLRetryDelay retry the key which is failed to expire in cycle after delay
+func WithTTLRetryDelay(delay time.Duration) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*TTLOptions)
+		if !ok {
+			return
+		}
+		o.RetryDelay = delay
+	})
+}
+
+// WithTTLClock use now as clock, eg: mock clock for test
+func WithTTLClock(now func() time.Time) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*TTLOptions)
+		if !ok {
+			return
+		}
+		o.now = now
+	})
+}
+
+// WithTTLOptions use opts, eg: unmarshaled from config
+func WithTTLOptions(opts TTLOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*TTLOptions)
+		if !ok {
+			return
+		}
+		now := o.now
+		*o = opts
+		o.now = now
+	})
+}
+
+// TTLStats ttl subsystem stats
+type TTLStats struct {
+	// ExpiredKeys expired by active cycles, LazyExpiredKeys expired when read
+	ExpiredKeys, LazyExpiredKeys uint64
+	Cycles                       uint64
+	// BudgetHits cycles which stop by budget with expired keys left
+	BudgetHits uint64
+	// ExpireErrors keys which are failed to expire in cycles and rescheduled
+	ExpireErrors      uint64
+	LastCycleDuration time.Duration
+	LastError         error
+}
+
+// TTL reusable ttl subsystem over IDB for data type layers,
+// expire time (unix ms) of key is in meta keyspace: key -> expireAt | indexAt if rescheduled,
+// and time ordered index keyspace: indexAt(8 bytes big endian) | key -> empty,
+// indexAt is expireAt, or the retry time after the key is failed to expire in cycle.
+// expired keys are removed lazily when read by Expired, and actively by expire cycles in runner,
+// the removal calls ExpiredFunc to cleanup the data of key in the same write batch.
+type TTL struct {
+	db        IDB
+	runner    *jobutils.Runner
+	onExpired ExpiredFunc
+	opts      *TTLOptions
+	metaNs    string
+	indexNs   string
+	meta      IDB
+	index     IDB
+
+	// locks key striped locks, serialize ttl updates and expiry of key
+	locks  [mergeLockStripes]sync.Mutex
+	taskID uint64
+	mu     sync.Mutex
+	stats  TTLStats
+}
+
+func NewTTL(db IDB, runner *jobutils.Runner, onExpired ExpiredFunc, opts ...option.Option) *TTL {
+	o := DefaultTTLOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+
+	t := &TTL{
+		db:        db,
+		runner:    runner,
+		onExpired: onExpired,
+		opts:      o,
+		metaNs:    o.Namespace + ":meta",
+		indexNs:   o.Namespace + ":index",
+	}
+	t.meta, t.index = Namespace(db, t.metaNs), Namespace(db, t.indexNs)
+	return t
+}
+
+// Lock lock key against ttl updates and expiry of key, return the unlock func,
+// hold it while writing key with BatchExpireAt/BatchPersist until wb is committed,
+// so the expiry which has read the old meta doesn't remove the new data.
+// the lock is not reentrant, don't call ExpireAt/Persist/Expired with it held.
+func (t *TTL) Lock(key []byte) func() {
+	mu := &t.locks[stripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (t *TTL) nowMs() int64 {
+	return t.opts.now().UnixMilli()
+}
+
+func ttlIndexKey(when int64, key []byte) []byte {
+	buf := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(buf, uint64(when))
+	copy(buf[8:], key)
+	return buf
+}
+
+func encodeExpireAt(when int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(when))
+}
+
+// expireAt get expire time (unix ms) of key, 0 if no ttl
+func (t *TTL) expireAt(key []byte) (int64, error) {
+	when, _, err := t.getMeta(key)
+	return when, err
+}
+
+// getMeta get expire time and index time of key, 0 if no ttl
+func (t *TTL) getMeta(key []byte) (when, indexAt int64, err error) {
+	v, err := t.meta.Get(key)
+	if err != nil || v == nil {
+		return 0, 0, err
+	}
+	switch len(v) {
+	case 8:
+		when = int64(binary.BigEndian.Uint64(v))
+		return when, when, nil
+	case 16:
+		return int64(binary.BigEndian.Uint64(v)), int64(binary.BigEndian.Uint64(v[8:])), nil
+	}
+
+	return 0, 0, ErrTTLCorrupted
+}
+
+// BatchExpireAt add ops which set expire time (unix ms) of key to wb,
+// eg: commit with the data of key atomically like SET key value PX ms,
+// the caller must hold Lock(key) until wb is committed,
+// the time in the past expires key at next read or cycle.
+func (t *TTL) BatchExpireAt(wb IWriteBatch, key []byte, when int64) error {
+	if when <= 0 {
+		when = 1
+	}
+	_, old, err := t.getMeta(key)
+	if err != nil {
+		return err
+	}
+
+	meta, index := NamespaceWriteBatch(t.db, wb, t.metaNs), NamespaceWriteBatch(t.db, wb, t.indexNs)
+	if old > 0 {
+		index.Delete(ttlIndexKey(old, key))
+	}
+	meta.Put(key, encodeExpireAt(when))
+	index.Put(ttlIndexKey(when, key), nil)
+	return nil
+}
+
+// BatchPersist add ops which remove ttl of key to wb, eg: commit with DEL key atomically,
+// the caller must hold Lock(key) until wb is committed, return false if key has no ttl
+func (t *TTL) BatchPersist(wb IWriteBatch, key []byte) (bool, error) {
+	_, old, err := t.getMeta(key)
+	if err != nil || old == 0 {
+		return false, err
+	}
+
+	NamespaceWriteBatch(t.db, wb, t.indexNs).Delete(ttlIndexKey(old, key))
+	NamespaceWriteBatch(t.db, wb, t.metaNs).Delete(key)
+	return true, nil
+}
+
+// ExpireAt set expire time (unix ms) of key
+func (t *TTL) ExpireAt(key []byte, when int64) error {
+	defer t.Lock(key)()
+
+	wb := t.db.NewWriteBatch()
+	defer wb.Close()
+	if err := t.BatchExpireAt(wb, key, when); err != nil {
+		return err
+	}
+	return wb.Commit()
+}
+
+// Expire set ttl of key
+func (t *TTL) Expire(key []byte, ttl time.Duration) error {
+	return t.ExpireAt(key, t.nowMs()+ttl.Milliseconds())
+}
+
+// Persist remove ttl of key, return false if key has no ttl
+func (t *TTL) Persist(key []byte) (bool, error) {
+	defer t.Lock(key)()
+
+	wb := t.db.NewWriteBatch()
+	defer wb.Close()
+	ok, err := t.BatchPersist(wb, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, wb.Commit()
+}
+
+// ExpireTime return expire time (unix ms) of key, 0 if no ttl
+func (t *TTL) ExpireTime(key []byte) (int64, error) {
+	return t.expireAt(key)
+}
+
+// TTL return the remaining ttl of key, -1 if no ttl, 0 if expired
+func (t *TTL) TTL(key []byte) (time.Duration, error) {
+	when, err := t.expireAt(key)
+	if err != nil || when == 0 {
+		return -1, err
+	}
+	if d := when - t.nowMs(); d > 0 {
+		return time.Duration(d) * time.Millisecond, nil
+	}
+	return 0, nil
+}
+
+// Expired lazy expiry when read key, expire key and return true if it is expired
+func (t *TTL) Expired(key []byte) (bool, error) {
+	when, indexAt, err := t.getMeta(key)
+	if err != nil || when == 0 || when > t.nowMs() {
+		return false, err
+	}
+
+	ok, err := t.expire(key, indexAt)
+	if ok {
+		t.mu.Lock()
+		t.stats.LazyExpiredKeys++
+		t.mu.Unlock()
+	}
+	return ok, err
+}
+
+// expire remove key whose index time is indexAt if it is still expired
+func (t *TTL) expire(key []byte, indexAt int64) (bool, error) {
+	defer t.Lock(key)()
+
+	cur, curIndexAt, err := t.getMeta(key)
+	if err != nil {
+		return false, err
+	}
+
+	wb := t.db.NewWriteBatch()
+	defer wb.Close()
+	// stale index which is updated after scan
+	if cur == 0 || curIndexAt != indexAt {
+		NamespaceWriteBatch(t.db, wb, t.indexNs).Delete(ttlIndexKey(indexAt, key))
+		return false, wb.Commit()
+	}
+	if cur > t.nowMs() {
+		return false, nil
+	}
+
+	NamespaceWriteBatch(t.db, wb, t.indexNs).Delete(ttlIndexKey(indexAt, key))
+	NamespaceWriteBatch(t.db, wb, t.metaNs).Delete(key)
+	if t.onExpired != nil {
+		if err := t.onExpired(wb, key); err != nil {
+			return false, err
+		}
+	}
+	return true, wb.Commit()
+}
+
+// reschedule move index of key from indexAt to retry time, the expire time is kept
+func (t *TTL) reschedule(key []byte, indexAt int64) error {
+	defer t.Lock(key)()
+
+	when, cur, err := t.getMeta(key)
+	if err != nil || when == 0 || cur != indexAt {
+		return err
+	}
+
+	retryAt := t.nowMs() + t.opts.RetryDelay.Milliseconds()
+	if retryAt <= indexAt {
+		retryAt = indexAt + 1
+	}
+	wb := t.db.NewWriteBatch()
+	defer wb.Close()
+	index := NamespaceWriteBatch(t.db, wb, t.indexNs)
+	index.Delete(ttlIndexKey(indexAt, key))
+	index.Put(ttlIndexKey(retryAt, key), nil)
+	NamespaceWriteBatch(t.db, wb, t.metaNs).Put(key, binary.BigEndian.AppendUint64(encodeExpireAt(when), uint64(retryAt)))
+	return wb.Commit()
+}
+
+// ExpireCycle expire the expired keys in time order within the budget of one cycle,
+// the key which is failed to expire is rescheduled after RetryDelay and the cycle continues,
+// return the number of expired keys, true if expired keys are left, and the last error.
+func (t *TTL) ExpireCycle(ctx context.Context) (n int, more bool, err error) {
+	start := time.Now()
+	failed := 0
+	defer func() {
+		t.mu.Lock()
+		t.stats.Cycles++
+		t.stats.ExpiredKeys += uint64(n)
+		t.stats.ExpireErrors += uint64(failed)
+		if more {
+			t.stats.BudgetHits++
+		}
+		t.stats.LastCycleDuration = time.Since(start)
+		t.stats.LastError = err
+		t.mu.Unlock()
+	}()
+
+	now := t.nowMs()
+	lower, upper := []byte(nil), ttlIndexKey(now+1, nil)
+	for {
+		batch, serr := t.scanExpired(lower, upper)
+		if serr != nil {
+			return n, false, serr
+		}
+		if len(batch) == 0 {
+			return n, false, err
+		}
+		for _, k := range batch {
+			if (t.opts.CycleKeys > 0 && n >= t.opts.CycleKeys) ||
+				(t.opts.CycleTime > 0 && time.Since(start) >= t.opts.CycleTime) || ctx.Err() != nil {
+				return n, true, err
+			}
+
+			indexAt := int64(binary.BigEndian.Uint64(k))
+			ok, eerr := t.expire(k[8:], indexAt)
+			if eerr != nil {
+				failed++
+				err = eerr
+				if rerr := t.reschedule(k[8:], indexAt); rerr != nil {
+					err = rerr
+				}
+				continue
+			}
+			if ok {
+				n++
+			}
+		}
+		lower = append(batch[len(batch)-1], 0)
+	}
+}
+
+// ttlScanBatch index keys are read in batch, iterator is not held while expiring
+const ttlScanBatch = 64
+
+func (t *TTL) scanExpired(lower, upper []byte) ([][]byte, error) {
+	it := NewIteratorWithOptions(t.index, &IteratorOptions{LowerBound: lower, UpperBound: upper})
+	defer it.Close()
+
+	keys := [][]byte{}
+	for it.First(); it.Valid() && len(keys) < ttlScanBatch; it.Next() {
+		if len(it.Key()) < 8 {
+			return nil, ErrTTLCorrupted
+		}
+		keys = append(keys, append([]byte{}, it.Key()...))
+	}
+	return keys, it.Error()
+}
+
+// Start run active expire cycles in runner
+func (t *TTL) Start() (err error) {
+	if t.opts.CycleInterval <= 0 {
+		return nil
+	}
+	t.taskID, err = t.runner.RunCancelableTask(t.run)
+	return
+}
+
+// Stop cancel active expire cycles
+func (t *TTL) Stop() error {
+	if t.taskID == 0 {
+		return nil
+	}
+	return t.runner.StopCancelableTask(t.taskID)
+}
+
+// Stats return stats of ttl
+func (t *TTL) Stats() TTLStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.stats
+}
+
+func (t *TTL) run(ctx context.Context) {
+	ticker := time.NewTicker(t.opts.CycleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.ExpireCycle(ctx)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package driver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/utils/jobutils"
+)
+
+// mockClock unix ms clock
+type mockClock struct {
+	ms atomic.Int64
+}
+
+func (c *mockClock) now() time.Time {
+	return time.UnixMilli(c.ms.Load())
+}
+
+func TestTTL(t *testing.T) {
+	db, _ := memkv.NewStore().Open("ttl")
+	defer db.Close()
+	clock := &mockClock{}
+	clock.ms.Store(1000)
+
+	// data type layer: hash key with field sub-keys
+	data := driver.Namespace(db, "data")
+	onExpired := func(wb driver.IWriteBatch, key []byte) error {
+		return driver.BatchDeleteRange(data, driver.NamespaceWriteBatch(db, wb, "data"), key, append(key, 0xff))
+	}
+	ttl := driver.NewTTL(db, nil, onExpired, driver.WithTTLClock(clock.now), driver.WithTTLCycle(0, 2, 0))
+
+	for i := 0; i < 5; i++ {
+		key := []byte(fmt.Sprintf("h%d", i))
+		data.Put(append(key, ":f1"...), []byte("v"))
+		data.Put(append(key, ":f2"...), []byte("v"))
+		if err := ttl.Expire(key, time.Duration(i+1)*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, _ := ttl.TTL([]byte("h1")); d != 2*time.Second {
+		t.Errorf("Got %v expected %v", d, 2*time.Second)
+	}
+	if d, _ := ttl.TTL([]byte("none")); d != -1 {
+		t.Errorf("Got %v expected %v", d, -1)
+	}
+	// update ttl, the old index is stale
+	ttl.ExpireAt([]byte("h4"), 2500)
+	if ok, _ := ttl.Persist([]byte("h3")); !ok {
+		t.Error("Got false expected persist h3")
+	}
+	if ok, _ := ttl.Persist([]byte("h3")); ok {
+		t.Error("Got true expected h3 has no ttl")
+	}
+
+	clock.ms.Store(4500)
+	// lazy expiry
+	if ok, err := ttl.Expired([]byte("h1")); !ok || err != nil {
+		t.Errorf("Got %v %v expected expired", ok, err)
+	}
+	if ok, _ := ttl.Expired([]byte("h3")); ok {
+		t.Error("Got expired expected h3 persisted")
+	}
+
+	// active cycle budget is 2 keys
+	n, more, err := ttl.ExpireCycle(context.Background())
+	if n != 2 || !more || err != nil {
+		t.Errorf("Got %d %v %v expected 2 true nil", n, more, err)
+	}
+	n, more, _ = ttl.ExpireCycle(context.Background())
+	if n != 1 || more {
+		t.Errorf("Got %d %v expected 1 false", n, more)
+	}
+
+	if got := iterKeys(data.NewIterator()); got != "[h3:f1 h3:f2]" {
+		t.Errorf("Got %s expected %s", got, "[h3:f1 h3:f2]")
+	}
+	if got := iterKeys(driver.Namespace(db, "ttl:index").NewIterator()); got != "[]" {
+		t.Errorf("Got index %s expected empty", got)
+	}
+	stats := ttl.Stats()
+	if stats.ExpiredKeys != 3 || stats.LazyExpiredKeys != 1 || stats.BudgetHits != 1 || stats.Cycles != 2 {
+		t.Errorf("Got %+v", stats)
+	}
+}
+
+func TestTTLActiveCycle(t *testing.T) {
+	db, _ := memkv.NewStore().Open("ttl_active")
+	defer db.Close()
+	runner := jobutils.NewRunner()
+	defer runner.Stop()
+
+	expired := make(chan string, 10)
+	ttl := driver.NewTTL(db, runner, func(wb driver.IWriteBatch, key []byte) error {
+		expired <- string(key)
+		return nil
+	}, driver.WithTTLCycle(5*time.Millisecond, 10, 10*time.Millisecond))
+	if err := ttl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ttl.Stop()
+
+	ttl.Expire([]byte("a"), 10*time.Millisecond)
+	ttl.Expire([]byte("b"), time.Hour)
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Errorf("Got %s expected %s", key, "a")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("key is not expired by active cycle")
+	}
+	if when, _ := ttl.ExpireTime([]byte("a")); when != 0 {
+		t.Errorf("Got %d expected 0", when)
+	}
+}
+
+func TestTTLExpireError(t *testing.T) {
+	db, _ := memkv.NewStore().Open("ttl_error")
+	defer db.Close()
+	clock := &mockClock{}
+	clock.ms.Store(1000)
+
+	errKeep := errors.New("keep")
+	fail := atomic.Bool{}
+	fail.Store(true)
+	ttl := driver.NewTTL(db, nil, func(wb driver.IWriteBatch, key []byte) error {
+		if string(key) == "bad" && fail.Load() {
+			return errKeep
+		}
+		return nil
+	}, driver.WithTTLClock(clock.now), driver.WithTTLCycle(0, 10, 0), driver.WithTTLRetryDelay(time.Second))
+
+	for _, k := range []string{"bad", "b", "c"} {
+		ttl.ExpireAt([]byte(k), 1000)
+	}
+
+	// the failed key doesn't block the cycle, it is rescheduled
+	clock.ms.Store(1500)
+	n, more, err := ttl.ExpireCycle(context.Background())
+	if n != 2 || more || !errors.Is(err, errKeep) {
+		t.Errorf("Got %d %v %v expected 2 false %v", n, more, err, errKeep)
+	}
+	if stats := ttl.Stats(); stats.ExpireErrors != 1 || !errors.Is(stats.LastError, errKeep) {
+		t.Errorf("Got %+v", stats)
+	}
+	if when, _ := ttl.ExpireTime([]byte("bad")); when != 1000 {
+		t.Errorf("Got %d expected %d", when, 1000)
+	}
+	if n, _, err := ttl.ExpireCycle(context.Background()); n != 0 || err != nil {
+		t.Errorf("Got %d %v expected 0 nil before retry", n, err)
+	}
+	if ok, err := ttl.Expired([]byte("bad")); ok || !errors.Is(err, errKeep) {
+		t.Errorf("Got %v %v expected lazy expiry error", ok, err)
+	}
+
+	// retried after delay
+	fail.Store(false)
+	clock.ms.Store(2600)
+	if n, _, err := ttl.ExpireCycle(context.Background()); n != 1 || err != nil {
+		t.Errorf("Got %d %v expected 1 nil", n, err)
+	}
+	if got := iterKeys(driver.Namespace(db, "ttl:index").NewIterator()); got != "[]" {
+		t.Errorf("Got index %s expected empty", got)
+	}
+
+	// ttl update of rescheduled key removes the retry index
+	fail.Store(true)
+	ttl.ExpireAt([]byte("bad"), 2600)
+	ttl.ExpireCycle(context.Background())
+	ttl.ExpireAt([]byte("bad"), 10000)
+	if n, _ := driver.ApproximateCount(driver.Namespace(db, "ttl:index"), driver.Range{}); n != 1 {
+		t.Errorf("Got %d index entries expected %d", n, 1)
+	}
+}
+
+func TestTTLLockWithExpiry(t *testing.T) {
+	db, _ := memkv.NewStore().Open("ttl_lock")
+	defer db.Close()
+	clock := &mockClock{}
+	clock.ms.Store(1000)
+
+	data := driver.Namespace(db, "data")
+	entered, release := make(chan struct{}), make(chan struct{})
+	ttl := driver.NewTTL(db, nil, func(wb driver.IWriteBatch, key []byte) error {
+		close(entered)
+		<-release
+		driver.NamespaceWriteBatch(db, wb, "data").Delete(key)
+		return nil
+	}, driver.WithTTLClock(clock.now), driver.WithTTLCycle(0, 10, 0))
+
+	data.Put([]byte("k"), []byte("old"))
+	ttl.ExpireAt([]byte("k"), 1000)
+
+	// the cycle has read the expired meta of k
+	clock.ms.Store(1500)
+	done := make(chan int)
+	go func() {
+		n, _, _ := ttl.ExpireCycle(context.Background())
+		done <- n
+	}()
+	<-entered
+
+	// SET k new PX 10000 concurrently
+	written := make(chan error)
+	go func() {
+		defer ttl.Lock([]byte("k"))()
+		wb := db.NewWriteBatch()
+		defer wb.Close()
+		driver.NamespaceWriteBatch(db, wb, "data").Put([]byte("k"), []byte("new"))
+		if err := ttl.BatchExpireAt(wb, []byte("k"), 11500); err != nil {
+			written <- err
+			return
+		}
+		written <- wb.Commit()
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("Got write %v expected blocked by expiry", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if n := <-done; n != 1 {
+		t.Errorf("Got %d expected %d", n, 1)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := data.Get([]byte("k")); string(v) != "new" {
+		t.Errorf("Got %s expected %s", v, "new")
+	}
+	if when, _ := ttl.ExpireTime([]byte("k")); when != 11500 {
+		t.Errorf("Got %d expected %d", when, 11500)
+	}
+}
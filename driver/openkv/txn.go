@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/weedge/pkg/utils"
+)
+
+var (
+	ErrTxnConflict    = errors.New("txn conflict, keys are written after txn begin")
+	ErrTxnDone        = errors.New("txn is committed or rolled back")
+	ErrTxnUnsupported = errors.New("txn is unsupported, wrap db with WithTransactions")
+)
+
+// ITxn optimistic transaction, reads see the snapshot of Begin and own writes,
+// writes are buffered until Commit, Commit fails with ErrTxnConflict
+// if the written keys or GetForUpdate keys are written by others after Begin.
+// like redis MULTI/EXEC with WATCH (GetForUpdate) keys.
+type ITxn interface {
+	Get(key []byte) ([]byte, error)
+	// GetForUpdate get value and track key for conflict check
+	GetForUpdate(key []byte) ([]byte, error)
+	Put(key []byte, value []byte)
+	Delete(key []byte)
+	// NewIterator iterate the snapshot merged with the writes buffered before it is created
+	NewIterator() IIterator
+	// Commit check conflicts and write atomically,
+	// read only txn with GetForUpdate keys checks conflicts too, like redis EXEC after WATCH
+	Commit() error
+	// Rollback drop the writes
+	Rollback()
+}
+
+// ITxnBeginer interface for engine which supports optimistic transaction
+type ITxnBeginer interface {
+	Begin() (ITxn, error)
+}
+
+// Begin begin a transaction of db, db must be ITxnBeginer,
+// engine without native transaction can be wrapped by WithTransactions.
+func Begin(db IDB) (ITxn, error) {
+	if b, ok := db.(ITxnBeginer); ok {
+		return b.Begin()
+	}
+
+	return nil, ErrTxnUnsupported
+}
+
+// TxnCommitFunc check conflicts of tracked keys (written and GetForUpdate keys)
+// and apply ops (put/delete, order by key) atomically, return ErrTxnConflict if conflict
+type TxnCommitFunc func(tracked [][]byte, ops *utils.BatchOpBuffer) error
+
+// NewTxn new an optimistic transaction for engine implementation,
+// reads from snap, commit by commit, release is called when txn is done
+func NewTxn(snap ISnapshot, commit TxnCommitFunc, release func()) ITxn {
+	return &txn{snap: snap, commit: commit, release: release, writes: map[string]*utils.BatchOp{}, tracked: map[string]struct{}{}}
+}
+
+type txn struct {
+	snap    ISnapshot
+	commit  TxnCommitFunc
+	release func()
+	writes  map[string]*utils.BatchOp
+	tracked map[string]struct{}
+	done    bool
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	if op, ok := t.writes[string(key)]; ok {
+		if op.Type == utils.BatchOpTypeDel {
+			return nil, nil
+		}
+		return append([]byte{}, op.Value...), nil
+	}
+
+	return t.snap.Get(key)
+}
+
+func (t *txn) GetForUpdate(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	t.tracked[string(key)] = struct{}{}
+
+	return t.Get(key)
+}
+
+func (t *txn) Put(key []byte, value []byte) {
+	if t.done {
+		return
+	}
+	t.writes[string(key)] = &utils.BatchOp{Type: utils.BatchOpTypePut, Key: append([]byte{}, key...), Value: append([]byte{}, value...)}
+}
+
+func (t *txn) Delete(key []byte) {
+	if t.done {
+		return
+	}
+	t.writes[string(key)] = &utils.BatchOp{Type: utils.BatchOpTypeDel, Key: append([]byte{}, key...)}
+}
+
+// sortedWrites buffered write ops order by key
+func (t *txn) sortedWrites() []*utils.BatchOp {
+	ops := make([]*utils.BatchOp, 0, len(t.writes))
+	for _, op := range t.writes {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return string(ops[i].Key) < string(ops[j].Key) })
+	return ops
+}
+
+func (t *txn) NewIterator() IIterator {
+	if t.done {
+		return &txnIterator{err: ErrTxnDone}
+	}
+
+	return newTxnIterator(t.snap.NewIterator(), t.sortedWrites())
+}
+
+func (t *txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.finish()
+
+	if len(t.writes) == 0 && len(t.tracked) == 0 {
+		return nil
+	}
+
+	ops := utils.NewBatchOpBuffer()
+	for _, op := range t.sortedWrites() {
+		ops.OpList.PushBack(op)
+		t.tracked[string(op.Key)] = struct{}{}
+	}
+	tracked := make([][]byte, 0, len(t.tracked))
+	for k := range t.tracked {
+		tracked = append(tracked, []byte(k))
+	}
+
+	return t.commit(tracked, ops)
+}
+
+func (t *txn) Rollback() {
+	if !t.done {
+		t.finish()
+	}
+}
+
+func (t *txn) finish() {
+	t.done = true
+	t.writes, t.tracked = nil, nil
+	t.snap.Close()
+	if t.release != nil {
+		t.release()
+	}
+}
@@ -0,0 +1,292 @@
+package driver
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/weedge/pkg/utils"
+)
+
+// txnVersionsPruneSize prune key versions when the table size is more than it
+const txnVersionsPruneSize = 4096
+
+// WithTransactions wrap db to support optimistic transaction for engine without native one,
+// the commit seq of written keys is kept in a key-version table, so all writes of db
+// must go through the wrapped db (writes to the inner db directly are not tracked).
+// writes are serialized to keep the table consistent with the data.
+func WithTransactions(db IDB) IDB {
+	return &txnDB{IDB: db, versions: map[string]uint64{}, active: map[uint64]int{}}
+}
+
+type rangeVersion struct {
+	start, end []byte
+	seq        uint64
+}
+
+type txnDB struct {
+	IDB
+	mu  sync.Mutex
+	seq uint64
+	// key -> last commit seq, range deletes with commit seq,
+	// the versions <= min seq of active txns are pruned
+	versions map[string]uint64
+	ranges   []rangeVersion
+	// active txn begin seq -> ref count
+	active map[uint64]int
+}
+
+func (db *txnDB) Begin() (ITxn, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snap, err := db.IDB.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	seq := db.seq
+	db.active[seq]++
+
+	return NewTxn(snap, func(tracked [][]byte, ops *utils.BatchOpBuffer) error {
+		return db.commit(seq, tracked, ops)
+	}, func() {
+		db.mu.Lock()
+		if db.active[seq]--; db.active[seq] <= 0 {
+			delete(db.active, seq)
+		}
+		db.mu.Unlock()
+	}), nil
+}
+
+// version the last commit seq of key, under lock
+func (db *txnDB) version(key []byte) uint64 {
+	v := db.versions[string(key)]
+	for i := range db.ranges {
+		r := &db.ranges[i]
+		if r.seq > v && bytes.Compare(key, r.start) >= 0 && (len(r.end) == 0 || bytes.Compare(key, r.end) < 0) {
+			v = r.seq
+		}
+	}
+	return v
+}
+
+func (db *txnDB) commit(beginSeq uint64, tracked [][]byte, ops *utils.BatchOpBuffer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, key := range tracked {
+		if db.version(key) > beginSeq {
+			return ErrTxnConflict
+		}
+	}
+	if ops.Len() == 0 {
+		return nil
+	}
+
+	return db.writeLocked(ops, func(wb IWriteBatch) error { return wb.Commit() })
+}
+
+// writeLocked apply ops to inner db and bump the versions of keys with a new seq, under lock
+func (db *txnDB) writeLocked(ops *utils.BatchOpBuffer, commit func(wb IWriteBatch) error) error {
+	wb := db.IDB.NewWriteBatch()
+	defer wb.Close()
+
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		var err error
+		switch op.Type {
+		case utils.BatchOpTypePut:
+			wb.Put(op.Key, op.Value)
+		case utils.BatchOpTypeDel:
+			wb.Delete(op.Key)
+		case utils.BatchOpTypeDelRange:
+			err = BatchDeleteRange(db.IDB, wb, op.Key, op.Value)
+		case utils.BatchOpTypeMerge:
+			err = BatchMerge(wb, op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := commit(wb); err != nil {
+		return err
+	}
+
+	db.seq++
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		if op.Type == utils.BatchOpTypeDelRange {
+			db.ranges = append(db.ranges, rangeVersion{start: op.Key, end: op.Value, seq: db.seq})
+			continue
+		}
+		db.versions[string(op.Key)] = db.seq
+	}
+	db.prune()
+
+	return nil
+}
+
+// prune drop the versions which no active txn can conflict with
+func (db *txnDB) prune() {
+	if len(db.versions)+len(db.ranges) < txnVersionsPruneSize && len(db.active) > 0 {
+		return
+	}
+
+	minSeq := db.seq
+	for seq := range db.active {
+		if seq < minSeq {
+			minSeq = seq
+		}
+	}
+	for k, v := range db.versions {
+		if v <= minSeq {
+			delete(db.versions, k)
+		}
+	}
+	ranges := db.ranges[:0]
+	for _, r := range db.ranges {
+		if r.seq > minSeq {
+			ranges = append(ranges, r)
+		}
+	}
+	db.ranges = ranges
+}
+
+func (db *txnDB) write(op *utils.BatchOp, sync bool) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.OpList.PushBack(op)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.writeLocked(ops, func(wb IWriteBatch) error {
+		if sync {
+			return wb.SyncCommit()
+		}
+		return wb.Commit()
+	})
+}
+
+func (db *txnDB) Put(key []byte, value []byte) error {
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypePut, Key: key, Value: value}, false)
+}
+
+func (db *txnDB) SyncPut(key []byte, value []byte) error {
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypePut, Key: key, Value: value}, true)
+}
+
+func (db *txnDB) Delete(key []byte) error {
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypeDel, Key: key}, false)
+}
+
+func (db *txnDB) SyncDelete(key []byte) error {
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypeDel, Key: key}, true)
+}
+
+func (db *txnDB) DeleteRange(start, end []byte) error {
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypeDelRange, Key: append([]byte{}, start...), Value: append([]byte{}, end...)}, false)
+}
+
+func (db *txnDB) Merge(key, operand []byte) error {
+	if _, ok := db.IDB.(IMerger); !ok {
+		return ErrMergeUnsupported
+	}
+	return db.write(&utils.BatchOp{Type: utils.BatchOpTypeMerge, Key: key, Value: operand}, false)
+}
+
+func (db *txnDB) GetSlice(key []byte) (ISlice, error) {
+	if g, ok := db.IDB.(ISliceGeter); ok {
+		return g.GetSlice(key)
+	}
+
+	v, err := db.IDB.Get(key)
+	if v == nil {
+		return nil, err
+	}
+	return GoSlice(v), nil
+}
+
+func (db *txnDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return MultiGet(db.IDB, keys)
+}
+
+func (db *txnDB) NewIteratorWithOptions(opts *IteratorOptions) IIterator {
+	return NewIteratorWithOptions(db.IDB, opts)
+}
+
+func (db *txnDB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *txnDB) ApproximateTotalSizes(ranges []Range) ([]uint64, error) {
+	return ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *txnDB) ApproximateCount(r Range) (uint64, error) {
+	return ApproximateCount(db.IDB, r)
+}
+
+func (db *txnDB) Property(name string) (string, error) {
+	return Property(db.IDB, name)
+}
+
+func (db *txnDB) CompactRange(start, end []byte) error {
+	return CompactRange(db.IDB, start, end)
+}
+
+func (db *txnDB) NewWriteBatch() IWriteBatch {
+	return &txnWriteBatch{db: db, ops: utils.NewBatchOpBuffer()}
+}
+
+// txnWriteBatch buffer ops, apply them and bump versions when commit.
+// Commit don't reset the batch, use Rollback to reset it.
+type txnWriteBatch struct {
+	db  *txnDB
+	ops *utils.BatchOpBuffer
+}
+
+func (wb *txnWriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (wb *txnWriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+}
+
+func (wb *txnWriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+}
+
+func (wb *txnWriteBatch) Merge(key, operand []byte) {
+	wb.ops.Merge(append([]byte{}, key...), append([]byte{}, operand...))
+}
+
+func (wb *txnWriteBatch) commit(sync bool) error {
+	wb.db.mu.Lock()
+	defer wb.db.mu.Unlock()
+	return wb.db.writeLocked(wb.ops, func(iwb IWriteBatch) error {
+		if sync {
+			return iwb.SyncCommit()
+		}
+		return iwb.Commit()
+	})
+}
+
+func (wb *txnWriteBatch) Commit() error {
+	return wb.commit(false)
+}
+
+func (wb *txnWriteBatch) SyncCommit() error {
+	return wb.commit(true)
+}
+
+func (wb *txnWriteBatch) Rollback() error {
+	wb.ops.Reset()
+	return nil
+}
+
+func (wb *txnWriteBatch) Data() []byte {
+	return wb.ops.Data()
+}
+
+func (wb *txnWriteBatch) Close() {
+	wb.ops.Reset()
+}
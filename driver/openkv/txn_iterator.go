@@ -0,0 +1,203 @@
+package driver
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/weedge/pkg/utils"
+)
+
+// txnIterator merge snapshot iterator with txn writes (order by key),
+// the write of the same key hides the snapshot one, deleted keys are skipped.
+type txnIterator struct {
+	it     IIterator
+	writes []*utils.BatchOp
+	// wi the position in writes, -1 or len(writes) is invalid
+	wi int
+	// forward direction, current is the smaller one of it and writes[wi]
+	forward bool
+	// cur current from writes
+	fromWrites bool
+	valid      bool
+	err        error
+}
+
+func newTxnIterator(it IIterator, writes []*utils.BatchOp) *txnIterator {
+	return &txnIterator{it: it, writes: writes, forward: true}
+}
+
+func (it *txnIterator) writeValid() bool {
+	return it.wi >= 0 && it.wi < len(it.writes)
+}
+
+// settle find current in direction, skip deleted keys
+func (it *txnIterator) settle() {
+	for {
+		itValid, wValid := it.it.Valid(), it.writeValid()
+		if !itValid && !wValid {
+			it.valid = false
+			return
+		}
+
+		cmp := 0
+		switch {
+		case !itValid:
+			cmp = 1
+		case !wValid:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(it.it.Key(), it.writes[it.wi].Key)
+			if !it.forward {
+				cmp = -cmp
+			}
+		}
+
+		if cmp < 0 {
+			it.fromWrites, it.valid = false, true
+			return
+		}
+		// the write hides the snapshot key which is the same
+		if cmp == 0 {
+			it.step(it.it)
+		}
+		if it.writes[it.wi].Type == utils.BatchOpTypeDel {
+			it.stepWrites()
+			continue
+		}
+		it.fromWrites, it.valid = true, true
+		return
+	}
+}
+
+func (it *txnIterator) step(i IIterator) {
+	if it.forward {
+		i.Next()
+	} else {
+		i.Prev()
+	}
+}
+
+func (it *txnIterator) stepWrites() {
+	if it.forward {
+		it.wi++
+	} else {
+		it.wi--
+	}
+}
+
+func (it *txnIterator) First() {
+	if it.err != nil {
+		return
+	}
+	it.forward = true
+	it.it.First()
+	it.wi = 0
+	it.settle()
+}
+
+func (it *txnIterator) Last() {
+	if it.err != nil {
+		return
+	}
+	it.forward = false
+	it.it.Last()
+	it.wi = len(it.writes) - 1
+	it.settle()
+}
+
+func (it *txnIterator) Seek(key []byte) {
+	if it.err != nil {
+		return
+	}
+	it.forward = true
+	it.it.Seek(key)
+	it.wi = sort.Search(len(it.writes), func(i int) bool { return bytes.Compare(it.writes[i].Key, key) >= 0 })
+	it.settle()
+}
+
+func (it *txnIterator) Next() {
+	if !it.valid {
+		return
+	}
+	if !it.forward {
+		// reposition both to the first key > current
+		key := append([]byte{}, it.Key()...)
+		it.Seek(key)
+		if it.valid && bytes.Equal(it.Key(), key) {
+			it.Next()
+		}
+		return
+	}
+
+	if it.fromWrites {
+		it.wi++
+	} else {
+		it.it.Next()
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Prev() {
+	if !it.valid {
+		return
+	}
+	if it.forward {
+		// reposition both to the last key < current
+		key := append([]byte{}, it.Key()...)
+		it.forward = false
+		it.it.Seek(key)
+		if it.it.Valid() {
+			it.it.Prev()
+		} else {
+			it.it.Last()
+		}
+		it.wi = sort.Search(len(it.writes), func(i int) bool { return bytes.Compare(it.writes[i].Key, key) >= 0 }) - 1
+		it.settle()
+		return
+	}
+
+	if it.fromWrites {
+		it.wi--
+	} else {
+		it.it.Prev()
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Valid() bool {
+	return it.err == nil && it.valid && it.it.Error() == nil
+}
+
+func (it *txnIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	if it.fromWrites {
+		return it.writes[it.wi].Key
+	}
+	return it.it.Key()
+}
+
+func (it *txnIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	if it.fromWrites {
+		return it.writes[it.wi].Value
+	}
+	return it.it.Value()
+}
+
+func (it *txnIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Error()
+}
+
+func (it *txnIterator) Close() error {
+	if it.it == nil {
+		return nil
+	}
+	return it.it.Close()
+}
@@ -0,0 +1,274 @@
+package driver_test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+)
+
+func txnDBs(t *testing.T) map[string]driver.IDB {
+	dbs := map[string]driver.IDB{}
+	for _, name := range []string{"native", "generic"} {
+		db, err := memkv.NewStore().Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if name == "generic" {
+			db = driver.WithTransactions(&plainDB{db})
+		}
+		dbs[name] = db
+	}
+	return dbs
+}
+
+func begin(t *testing.T, db driver.IDB) driver.ITxn {
+	t.Helper()
+	txn, err := driver.Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txn
+}
+
+func TestTxnUnsupported(t *testing.T) {
+	db, _ := memkv.NewStore().Open("txn")
+	defer db.Close()
+	if _, err := driver.Begin(&plainDB{db}); !errors.Is(err, driver.ErrTxnUnsupported) {
+		t.Errorf("Got %v expected %v", err, driver.ErrTxnUnsupported)
+	}
+}
+
+func TestTxnIsolationConflict(t *testing.T) {
+	for name, db := range txnDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			db.Put([]byte("a"), []byte("1"))
+			db.Put([]byte("b"), []byte("1"))
+
+			t1 := begin(t, db)
+			t1.Put([]byte("a"), []byte("2"))
+			if v, _ := t1.Get([]byte("a")); string(v) != "2" {
+				t.Errorf("read own write Got %s expected %s", v, "2")
+			}
+			db.Put([]byte("c"), []byte("1"))
+			if v, _ := t1.Get([]byte("c")); v != nil {
+				t.Errorf("snapshot read Got %s expected nil", v)
+			}
+			if v, _ := db.Get([]byte("a")); string(v) != "1" {
+				t.Errorf("buffered write Got %s expected %s", v, "1")
+			}
+			if err := t1.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if err := t1.Commit(); !errors.Is(err, driver.ErrTxnDone) {
+				t.Errorf("Got %v expected %v", err, driver.ErrTxnDone)
+			}
+
+			// write-write conflict
+			t2 := begin(t, db)
+			t2.Put([]byte("a"), []byte("3"))
+			db.Put([]byte("a"), []byte("4"))
+			if err := t2.Commit(); !errors.Is(err, driver.ErrTxnConflict) {
+				t.Errorf("Got %v expected %v", err, driver.ErrTxnConflict)
+			}
+			if v, _ := db.Get([]byte("a")); string(v) != "4" {
+				t.Errorf("Got %s expected %s", v, "4")
+			}
+
+			// watched key is deleted by range, read only txn fails too
+			t3 := begin(t, db)
+			t3.GetForUpdate([]byte("b"))
+			driver.DeleteRange(db, []byte("b"), []byte("c"))
+			if err := t3.Commit(); !errors.Is(err, driver.ErrTxnConflict) {
+				t.Errorf("Got %v expected %v", err, driver.ErrTxnConflict)
+			}
+
+			// read without update and disjoint writes don't conflict
+			t4 := begin(t, db)
+			t4.Get([]byte("a"))
+			t4.Put([]byte("d"), []byte("1"))
+			db.Put([]byte("a"), []byte("5"))
+			wb := db.NewWriteBatch()
+			wb.Put([]byte("e"), []byte("1"))
+			wb.Commit()
+			wb.Close()
+			if err := t4.Commit(); err != nil {
+				t.Errorf("Got %v expected nil", err)
+			}
+
+			// rollback drop writes
+			t5 := begin(t, db)
+			t5.Put([]byte("f"), []byte("1"))
+			t5.Rollback()
+			if v, _ := db.Get([]byte("f")); v != nil {
+				t.Errorf("Got %s expected nil", v)
+			}
+		})
+	}
+}
+
+func TestTxnIterator(t *testing.T) {
+	for name, db := range txnDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{"a", "c", "e", "g"} {
+				db.Put([]byte(k), []byte("db"))
+			}
+			txn := begin(t, db)
+			defer txn.Rollback()
+			txn.Put([]byte("b"), []byte("txn"))
+			txn.Put([]byte("c"), []byte("txn"))
+			txn.Delete([]byte("e"))
+			txn.Delete([]byte("x"))
+			txn.Put([]byte("h"), []byte("txn"))
+
+			it := txn.NewIterator()
+			defer it.Close()
+			got := []string{}
+			for it.First(); it.Valid(); it.Next() {
+				got = append(got, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+			}
+			if fmt.Sprint(got) != "[a=db b=txn c=txn g=db h=txn]" {
+				t.Errorf("forward Got %v", got)
+			}
+
+			got = got[:0]
+			for it.Last(); it.Valid(); it.Prev() {
+				got = append(got, string(it.Key()))
+			}
+			if fmt.Sprint(got) != "[h g c b a]" {
+				t.Errorf("reverse Got %v", got)
+			}
+
+			// change direction
+			it.Seek([]byte("d"))
+			got = got[:0]
+			got = append(got, string(it.Key()))
+			it.Prev()
+			got = append(got, string(it.Key()))
+			it.Prev()
+			got = append(got, string(it.Key()))
+			it.Next()
+			got = append(got, string(it.Key()))
+			it.Next()
+			got = append(got, string(it.Key()))
+			if fmt.Sprint(got) != "[g c b c g]" {
+				t.Errorf("direction Got %v", got)
+			}
+		})
+	}
+}
+
+func TestTxnConcurrentIncr(t *testing.T) {
+	for name, db := range txnDBs(t) {
+		t.Run(name, func(t *testing.T) {
+			incr := func() error {
+				for {
+					txn := begin(t, db)
+					v, err := txn.GetForUpdate([]byte("counter"))
+					if err != nil {
+						txn.Rollback()
+						return err
+					}
+					n, _ := strconv.Atoi(string(v))
+					txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1)))
+					if err = txn.Commit(); !errors.Is(err, driver.ErrTxnConflict) {
+						return err
+					}
+				}
+			}
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						if err := incr(); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if v, _ := db.Get([]byte("counter")); string(v) != "800" {
+				t.Errorf("Got %s expected %s", v, "800")
+			}
+		})
+	}
+}
+
+// cfDB native namespaces which are not the prefix-isolated views, like column families
+type cfDB struct {
+	driver.IDB
+}
+
+func (db cfDB) Begin() (driver.ITxn, error) {
+	return driver.Begin(db.IDB)
+}
+
+func (db cfDB) Namespace(name string) driver.IDB {
+	return driver.Namespace(db.IDB, "cf:"+name)
+}
+
+func (db cfDB) NamespaceWriteBatch(wb driver.IWriteBatch, name string) driver.IWriteBatch {
+	return driver.NamespaceWriteBatch(db.IDB, wb, "cf:"+name)
+}
+
+func (db cfDB) NamespaceSnapshot(snap driver.ISnapshot, name string) driver.ISnapshot {
+	return driver.NamespaceSnapshot(db.IDB, snap, "cf:"+name)
+}
+
+func (db cfDB) NamespaceTxn(txn driver.ITxn, name string) driver.ITxn {
+	return driver.NamespaceTxn(db.IDB, txn, "cf:"+name)
+}
+
+func TestTxnNamespace(t *testing.T) {
+	dbs := txnDBs(t)
+	dbs["namespacer"] = cfDB{dbs["native"]}
+	for name, db := range dbs {
+		t.Run(name, func(t *testing.T) {
+			src, dst := driver.Namespace(db, "set:src"), driver.Namespace(db, "set:dst")
+			src.Put([]byte("m"), []byte{})
+
+			// smove like
+			txn := begin(t, db)
+			s, d := driver.NamespaceTxn(db, txn, "set:src"), driver.NamespaceTxn(db, txn, "set:dst")
+			if v, _ := s.GetForUpdate([]byte("m")); v == nil {
+				t.Fatal("member not found")
+			}
+			s.Delete([]byte("m"))
+			d.Put([]byte("m"), []byte{})
+			it := d.NewIterator()
+			it.First()
+			if !it.Valid() || string(it.Key()) != "m" {
+				t.Errorf("Got %q expected %q", it.Key(), "m")
+			}
+			it.Close()
+			if err := txn.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := src.Get([]byte("m")); v != nil {
+				t.Errorf("src Got %q expected nil", v)
+			}
+			if v, _ := dst.Get([]byte("m")); v == nil {
+				t.Errorf("dst Got nil expected member")
+			}
+
+			nsTxn := begin(t, dst)
+			nsTxn.Put([]byte("n"), []byte("1"))
+			if err := nsTxn.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := dst.Get([]byte("n")); string(v) != "1" {
+				t.Errorf("Got %q expected %q", v, "1")
+			}
+		})
+	}
+}
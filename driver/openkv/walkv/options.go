@@ -0,0 +1,65 @@
+package walkv
+
+import (
+	"fmt"
+
+	"github.com/weedge/pkg/option"
+)
+
+// Options wal options
+type Options struct {
+	// SegmentSize roll to a new segment file after the segment size >= it
+	SegmentSize int64 `mapstructure:"segmentSize"`
+	// SyncAlways sync log for every commit, else only SyncXXX ops sync
+	SyncAlways bool `mapstructure:"syncAlways"`
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		SegmentSize: 64 * 1024 * 1024,
+	}
+}
+
+func (o *Options) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithSegmentSize(n int64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		o.SegmentSize = n
+	})
+}
+
+func WithSyncAlways(always bool) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		o.SyncAlways = always
+	})
+}
+
+// WithOptions use opts, eg: unmarshaled from config
+func WithOptions(opts Options) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*Options)
+		if !ok {
+			return
+		}
+		*o = opts
+	})
+}
+
+func getOptions(opts ...option.Option) *Options {
+	options := DefaultOptions()
+	for _, o := range opts {
+		o.Apply(options)
+	}
+
+	return options
+}
@@ -0,0 +1,441 @@
+package walkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/weedge/pkg/option"
+)
+
+// log segment file: | record | record | ...
+// record: | crc32c u32 LE | len(data) u32 LE | log id u64 LE | data |
+// crc covers len, id and data. segment file name is the first log id of it.
+const (
+	segmentExt       = ".wal"
+	recordHeaderSize = 16
+	maxRecordLen     = 1 << 30
+)
+
+var (
+	ErrClosed    = errors.New("wal is closed")
+	ErrCorrupted = errors.New("wal corrupted")
+	ErrLogPurged = errors.New("wal log is purged")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type segment struct {
+	base uint64
+	path string
+	size int64
+}
+
+// Log segmented write-ahead log, log ids are continuous from 1,
+// Append write record to os buffer, Sync fsync records with group commit:
+// one fsync makes all records appended before it durable for the concurrent writers.
+type Log struct {
+	dir  string
+	opts *Options
+
+	mu     sync.Mutex
+	segs   []*segment
+	f      *os.File
+	lastID uint64
+	closed bool
+
+	syncMu   sync.Mutex
+	syncedID uint64
+	syncs    uint64
+}
+
+// OpenLog open log in dir, recover the log after crash:
+// the torn tail of the last segment is truncated,
+// return ErrCorrupted if the records before the tail are corrupted.
+func OpenLog(dir string, opts ...option.Option) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, opts: getOptions(opts...)}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	atomic.StoreUint64(&l.syncedID, l.lastID)
+
+	return l, nil
+}
+
+func (l *Log) segmentPath(base uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+func (l *Log) recover() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil || base == 0 {
+			continue
+		}
+		l.segs = append(l.segs, &segment{base: base, path: filepath.Join(l.dir, name)})
+	}
+	sort.Slice(l.segs, func(i, j int) bool { return l.segs[i].base < l.segs[j].base })
+
+	if len(l.segs) == 0 {
+		return l.createSegment(1)
+	}
+
+	for i, seg := range l.segs {
+		if i > 0 && seg.base != l.lastID+1 {
+			return fmt.Errorf("%w: segment %s is not continuous with log id %d", ErrCorrupted, seg.path, l.lastID)
+		}
+		l.lastID = seg.base - 1
+		size, err := scanSegment(seg, func(id uint64, data []byte) bool {
+			l.lastID = id
+			return true
+		})
+		if err != nil {
+			if i != len(l.segs)-1 || !errors.Is(err, ErrCorrupted) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			// torn tail of the last segment
+			if err := os.Truncate(seg.path, size); err != nil {
+				return err
+			}
+		}
+		seg.size = size
+	}
+
+	last := l.segs[len(l.segs)-1]
+	l.f, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (l *Log) createSegment(base uint64) error {
+	seg := &segment{base: base, path: l.segmentPath(base)}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	syncDir(l.dir)
+
+	l.f = f
+	l.segs = append(l.segs, seg)
+	return nil
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// scanSegment read the records of segment in [0, seg.size) (whole file if size is 0),
+// return the size of valid records, and ErrCorrupted or io.ErrUnexpectedEOF for bad tail.
+func scanSegment(seg *segment, fn func(id uint64, data []byte) bool) (int64, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrLogPurged
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if seg.size > 0 {
+		r = io.LimitReader(f, seg.size)
+	}
+	br := bufio.NewReader(r)
+
+	var size int64
+	expected := seg.base
+	for {
+		id, data, err := readRecord(br)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+		if id != expected {
+			return size, fmt.Errorf("%w: log id %d expected %d in %s", ErrCorrupted, id, expected, seg.path)
+		}
+		size += int64(recordHeaderSize + len(data))
+		expected++
+		if fn != nil && !fn(id, data) {
+			return size, nil
+		}
+	}
+}
+
+func appendRecord(buf []byte, id uint64, data []byte) []byte {
+	var h [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(h[8:], id)
+	crc := crc32.Update(crc32.Checksum(h[4:], crcTable), crcTable, data)
+	binary.LittleEndian.PutUint32(h[:4], crc)
+
+	buf = append(buf, h[:]...)
+	return append(buf, data...)
+}
+
+// readRecord return io.EOF if no more record, io.ErrUnexpectedEOF if record is torn
+func readRecord(r io.Reader) (id uint64, data []byte, err error) {
+	var h [recordHeaderSize]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	n := binary.LittleEndian.Uint32(h[4:])
+	if n > maxRecordLen {
+		return 0, nil, fmt.Errorf("%w: record len %d", ErrCorrupted, n)
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if crc32.Update(crc32.Checksum(h[4:], crcTable), crcTable, data) != binary.LittleEndian.Uint32(h[:4]) {
+		return 0, nil, fmt.Errorf("%w: record crc mismatch", ErrCorrupted)
+	}
+
+	return binary.LittleEndian.Uint64(h[8:]), data, nil
+}
+
+// Append append data as a new record to os buffer, return its log id,
+// use Sync to make it durable
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	id := l.lastID + 1
+	seg := l.segs[len(l.segs)-1]
+	if seg.size > 0 && seg.size >= l.opts.SegmentSize {
+		if err := l.roll(id); err != nil {
+			return 0, err
+		}
+		seg = l.segs[len(l.segs)-1]
+	}
+
+	n, err := l.f.Write(appendRecord(nil, id, data))
+	if err != nil {
+		if n > 0 {
+			// drop the partial record
+			l.f.Truncate(seg.size)
+		}
+		return 0, err
+	}
+	seg.size += int64(n)
+	l.lastID = id
+
+	return id, nil
+}
+
+// roll sync and close the active segment, create new segment from id
+func (l *Log) roll(id uint64) error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&l.syncs, 1)
+	l.setSynced(l.lastID)
+	l.f.Close()
+
+	return l.createSegment(id)
+}
+
+func (l *Log) setSynced(id uint64) {
+	for {
+		old := atomic.LoadUint64(&l.syncedID)
+		if old >= id || atomic.CompareAndSwapUint64(&l.syncedID, old, id) {
+			return
+		}
+	}
+}
+
+// Sync make the records <= id durable, group commit:
+// the concurrent writers wait for one fsync which covers all their records
+func (l *Log) Sync(id uint64) error {
+	if atomic.LoadUint64(&l.syncedID) >= id {
+		return nil
+	}
+
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if atomic.LoadUint64(&l.syncedID) >= id {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	f, target := l.f, l.lastID
+	l.mu.Unlock()
+
+	// the segment is rolled (synced and closed) after target is got
+	if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	atomic.AddUint64(&l.syncs, 1)
+	l.setSynced(target)
+
+	return nil
+}
+
+// FirstID the first log id which is not purged
+func (l *Log) FirstID() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segs[0].base
+}
+
+// LastID the last appended log id, 0 is empty
+func (l *Log) LastID() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// SyncedID the last durable log id
+func (l *Log) SyncedID() uint64 {
+	return atomic.LoadUint64(&l.syncedID)
+}
+
+// Iterate call fn with the records from log id fromID in order until fn return false,
+// fromID 0 is from the first log, return ErrLogPurged if fromID is purged.
+func (l *Log) Iterate(fromID uint64, fn func(id uint64, data []byte) bool) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	segs := make([]segment, len(l.segs))
+	for i, seg := range l.segs {
+		segs[i] = *seg
+	}
+	l.mu.Unlock()
+
+	if fromID == 0 {
+		fromID = segs[0].base
+	}
+	if fromID < segs[0].base {
+		return ErrLogPurged
+	}
+
+	stop := false
+	for i := range segs {
+		if i+1 < len(segs) && segs[i+1].base <= fromID {
+			continue
+		}
+		if segs[i].size == 0 {
+			continue
+		}
+		if _, err := scanSegment(&segs[i], func(id uint64, data []byte) bool {
+			if id < fromID {
+				return true
+			}
+			stop = !fn(id, data)
+			return !stop
+		}); err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// ReadLogs read records from log id fromID encoded in log record format
+// until the bytes >= maxBytes (at least one record), for replica Sync(ctx, syncLogID),
+// empty buf if fromID > LastID, decode buf by DecodeLogs.
+func (l *Log) ReadLogs(fromID uint64, maxBytes int) (buf []byte, err error) {
+	err = l.Iterate(fromID, func(id uint64, data []byte) bool {
+		buf = appendRecord(buf, id, data)
+		return len(buf) < maxBytes
+	})
+	return
+}
+
+// DecodeLogs call fn with records of buf which is read by ReadLogs in order,
+// until fn return false
+func DecodeLogs(buf []byte, fn func(id uint64, data []byte) bool) error {
+	r := bytes.NewReader(buf)
+	for {
+		id, data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(id, data) {
+			return nil
+		}
+	}
+}
+
+// Purge remove the segments whose records are all < beforeID,
+// the active segment is kept.
+func (l *Log) Purge(beforeID uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	n := 0
+	for n+1 < len(l.segs) && l.segs[n+1].base <= beforeID {
+		if err := os.Remove(l.segs[n].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		n++
+	}
+	l.segs = l.segs[n:]
+
+	return nil
+}
+
+// Close sync and close the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	err := l.f.Sync()
+	l.setSynced(l.lastID)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,413 @@
+// Package walkv put a write-ahead log in front of openkv engine,
+// write batches are appended to the segmented log before applied to the engine,
+// SyncXXX ops fsync the log with group commit, the log is replayed when open,
+// so engine without durable batches (eg: memory engine) is crash safe.
+// the log ids are the replica sync log ids, read logs by Log.ReadLogs.
+package walkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils"
+)
+
+// LogDirName the log dir name in db path
+const LogDirName = "wal"
+
+// DurableFileName the file in log dir which persists the durable log id: | id u64 LE | crc32c u32 LE |
+const DurableFileName = "DURABLE"
+
+var (
+	ErrUnsupportedOp = errors.New("wal unsupported batch op")
+	// ErrApplyFailed the appended log is failed to apply to engine, the db is broken,
+	// writes are rejected, reopen the db to replay the log
+	ErrApplyFailed = errors.New("wal apply failed after log appended")
+)
+
+// Store wrap the inner store, the opened dbs write logs in path/LogDirName
+type Store struct {
+	inner driver.IStore
+	opts  []option.Option
+}
+
+func NewStore(inner driver.IStore, opts ...option.Option) *Store {
+	return &Store{inner: inner, opts: opts}
+}
+
+func (s *Store) String() string {
+	return s.Name()
+}
+
+// Name wal:<inner store name>
+func (s *Store) Name() string {
+	return "wal:" + s.inner.Name()
+}
+
+func (s *Store) Open(path string) (driver.IDB, error) {
+	return s.OpenWithOptions(path)
+}
+
+// OptionSpecs the options which inner store supports
+func (s *Store) OptionSpecs() []driver.OptionSpec {
+	return driver.GetStoreInfo(s.inner).Options
+}
+
+func (s *Store) OpenWithOptions(path string, opts ...option.Option) (driver.IDB, error) {
+	db, err := driver.OpenWithOptions(s.inner, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := Wrap(db, filepath.Join(path, LogDirName), s.opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *Store) Repair(path string) error {
+	return s.inner.Repair(path)
+}
+
+// Wrap open log in dir and replay it to db, all writes of db must go through the wrapped db.
+// the log after the durable id (SetDurable) is replayed in order,
+// replay of put/delete/delete range ops is idempotent,
+// the whole log is replayed if engine data is not durable (eg: memory engine).
+// merge is not supported, wrap it with driver.WithMergeOperator for merge.
+func Wrap(db driver.IDB, dir string, opts ...option.Option) (*DB, error) {
+	log, err := OpenLog(dir, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &DB{IDB: db, log: log}
+	if w.durableID, err = readDurable(dir); err == nil {
+		err = w.replay()
+	}
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+type DB struct {
+	driver.IDB
+	log *Log
+	// keep log order same as apply order
+	mu sync.Mutex
+	// failed ErrApplyFailed after apply failed
+	failed error
+	// appliedID the last log id applied to engine
+	appliedID uint64
+	// durableID the engine data of logs <= it is durable
+	durableID uint64
+}
+
+func readDurable(dir string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(dir, DurableFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 12 || crc32.Checksum(b[:8], crcTable) != binary.LittleEndian.Uint32(b[8:]) {
+		return 0, fmt.Errorf("%w: bad %s", ErrCorrupted, DurableFileName)
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// writeDurable write durable id to tmp file, then rename it to DurableFileName
+func writeDurable(dir string, id uint64) error {
+	b := binary.LittleEndian.AppendUint64(nil, id)
+	b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+
+	path := filepath.Join(dir, DurableFileName)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	syncDir(dir)
+
+	return nil
+}
+
+// replay the logs after durable id
+func (db *DB) replay() error {
+	db.appliedID = db.durableID
+	if db.durableID >= db.log.LastID() {
+		return nil
+	}
+
+	ops := utils.NewBatchOpBuffer()
+	var err error
+	if ierr := db.log.Iterate(db.durableID+1, func(id uint64, data []byte) bool {
+		ops.Reset()
+		if err = ops.Load(data); err == nil {
+			err = db.apply(ops)
+		}
+		if err == nil {
+			db.appliedID = id
+		}
+		return err == nil
+	}); ierr != nil {
+		return ierr
+	}
+
+	return err
+}
+
+// apply ops to engine with a write batch, log is the durable one
+func (db *DB) apply(ops *utils.BatchOpBuffer) error {
+	wb := db.IDB.NewWriteBatch()
+	defer wb.Close()
+
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		op := e.Value.(*utils.BatchOp)
+		switch op.Type {
+		case utils.BatchOpTypePut:
+			wb.Put(op.Key, op.Value)
+		case utils.BatchOpTypeDel:
+			wb.Delete(op.Key)
+		case utils.BatchOpTypeDelRange:
+			if err := driver.BatchDeleteRange(db.IDB, wb, op.Key, op.Value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: type %d", ErrUnsupportedOp, op.Type)
+		}
+	}
+
+	return wb.Commit()
+}
+
+// checkOps check ops are supported before they are appended to log
+func checkOps(ops *utils.BatchOpBuffer) error {
+	for e := ops.FrontElement(); e != nil; e = e.Next() {
+		switch t := e.Value.(*utils.BatchOp).Type; t {
+		case utils.BatchOpTypePut, utils.BatchOpTypeDel, utils.BatchOpTypeDelRange:
+		default:
+			return fmt.Errorf("%w: type %d", ErrUnsupportedOp, t)
+		}
+	}
+	return nil
+}
+
+// write append ops to log and apply them to engine,
+// sync log if sync, the concurrent synced writes share one fsync.
+// the write may be seen by readers before the log is synced,
+// if apply fails after log appended, the write is not rolled back (it is replayed when open),
+// the db is broken and return ErrApplyFailed for all writes.
+func (db *DB) write(ops *utils.BatchOpBuffer, sync bool) error {
+	if ops.Len() == 0 {
+		return nil
+	}
+	if err := checkOps(ops); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	if db.failed != nil {
+		db.mu.Unlock()
+		return db.failed
+	}
+	id, err := db.log.Append(ops.Data())
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if err := db.apply(ops); err != nil {
+		db.failed = fmt.Errorf("%w: log id %d: %v", ErrApplyFailed, id, err)
+		db.mu.Unlock()
+		return db.failed
+	}
+	db.appliedID = id
+	db.mu.Unlock()
+
+	if sync || db.log.opts.SyncAlways {
+		return db.log.Sync(id)
+	}
+	return nil
+}
+
+// Log the write-ahead log of db
+func (db *DB) Log() *Log {
+	return db.log
+}
+
+// AppliedID the last log id which is applied to engine
+func (db *DB) AppliedID() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.appliedID
+}
+
+// DurableID the last log id whose engine data is durable, 0 is none
+func (db *DB) DurableID() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.durableID
+}
+
+// SetDurable persist that the engine data of logs <= id is durable,
+// eg: get AppliedID before the engine is flushed or checkpointed, set it after that,
+// the replay when open starts after it.
+func (db *DB) SetDurable(id uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if id <= db.durableID {
+		return nil
+	}
+	if id > db.appliedID {
+		return fmt.Errorf("durable id %d > applied id %d", id, db.appliedID)
+	}
+	// the log of durable id must be durable too, the ids after it are not reused
+	if err := db.log.Sync(id); err != nil {
+		return err
+	}
+	if err := writeDurable(db.log.dir, id); err != nil {
+		return err
+	}
+	db.durableID = id
+
+	return nil
+}
+
+// Purge remove the log segments whose records are all < beforeID and are durable in engine,
+// the logs after durable id are kept for replay.
+func (db *DB) Purge(beforeID uint64) error {
+	if durable := db.DurableID(); beforeID > durable+1 {
+		beforeID = durable + 1
+	}
+	return db.log.Purge(beforeID)
+}
+
+func (db *DB) Put(key []byte, value []byte) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.Put(key, value)
+	return db.write(ops, false)
+}
+
+func (db *DB) SyncPut(key []byte, value []byte) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.Put(key, value)
+	return db.write(ops, true)
+}
+
+func (db *DB) Delete(key []byte) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.Del(key)
+	return db.write(ops, false)
+}
+
+func (db *DB) SyncDelete(key []byte) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.Del(key)
+	return db.write(ops, true)
+}
+
+// DeleteRange delete keys in [start, end) atomically with one log
+func (db *DB) DeleteRange(start, end []byte) error {
+	ops := utils.NewBatchOpBuffer()
+	ops.DelRange(start, end)
+	return db.write(ops, false)
+}
+
+func (db *DB) ApproximateSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateTotalSizes(ranges []driver.Range) ([]uint64, error) {
+	return driver.ApproximateTotalSizes(db.IDB, ranges...)
+}
+
+func (db *DB) ApproximateCount(r driver.Range) (uint64, error) {
+	return driver.ApproximateCount(db.IDB, r)
+}
+
+func (db *DB) Property(name string) (string, error) {
+	return driver.Property(db.IDB, name)
+}
+
+func (db *DB) CompactRange(start, end []byte) error {
+	return driver.CompactRange(db.IDB, start, end)
+}
+
+func (db *DB) NewWriteBatch() driver.IWriteBatch {
+	return &WriteBatch{db: db, ops: utils.NewBatchOpBuffer()}
+}
+
+// Close close log and engine
+func (db *DB) Close() error {
+	err := db.log.Close()
+	if cerr := db.IDB.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// WriteBatch buffer ops, commit them as one log.
+// Commit don't reset the batch, use Rollback to reset it.
+type WriteBatch struct {
+	db  *DB
+	ops *utils.BatchOpBuffer
+}
+
+func (wb *WriteBatch) Put(key []byte, value []byte) {
+	wb.ops.Put(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (wb *WriteBatch) Delete(key []byte) {
+	wb.ops.Del(append([]byte{}, key...))
+}
+
+// DeleteRange delete keys in [start, end), nil or empty start/end is unbounded
+func (wb *WriteBatch) DeleteRange(start, end []byte) {
+	wb.ops.DelRange(append([]byte{}, start...), append([]byte{}, end...))
+}
+
+func (wb *WriteBatch) Commit() error {
+	return wb.db.write(wb.ops, false)
+}
+
+func (wb *WriteBatch) SyncCommit() error {
+	return wb.db.write(wb.ops, true)
+}
+
+func (wb *WriteBatch) Rollback() error {
+	wb.ops.Reset()
+	return nil
+}
+
+func (wb *WriteBatch) Data() []byte {
+	return wb.ops.Data()
+}
+
+func (wb *WriteBatch) Close() {
+	wb.ops.Reset()
+}
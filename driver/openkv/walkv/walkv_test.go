@@ -0,0 +1,342 @@
+package walkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	driver "github.com/weedge/pkg/driver/openkv"
+	"github.com/weedge/pkg/driver/openkv/memkv"
+	"github.com/weedge/pkg/driver/openkv/openkvtest"
+	"github.com/weedge/pkg/utils"
+)
+
+type testStore struct {
+	*Store
+	mem *memkv.Store
+}
+
+func (s testStore) Destroy(path string) error {
+	return s.mem.Destroy(path)
+}
+
+func TestConformance(t *testing.T) {
+	mem := memkv.NewStore()
+	openkvtest.RunConformance(t, testStore{NewStore(mem, WithSegmentSize(256)), mem})
+}
+
+func TestCrashReplay(t *testing.T) {
+	path := t.TempDir()
+	db, err := NewStore(memkv.NewStore(), WithSegmentSize(128)).Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		db.Put([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprint(i)))
+	}
+	wb := db.NewWriteBatch()
+	driver.BatchDeleteRange(db, wb, []byte("k05"), []byte("k15"))
+	wb.Delete([]byte("k00"))
+	wb.SyncCommit()
+	wb.Close()
+
+	// crash: the memory data is lost, the log is not closed
+	db2, err := NewStore(memkv.NewStore()).Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	it := db2.NewIterator()
+	defer it.Close()
+	keys := []string{}
+	for it.First(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[k01 k02 k03 k04 k15 k16 k17 k18 k19]" {
+		t.Errorf("Got %v", keys)
+	}
+	log := db2.(*DB).Log()
+	if log.LastID() != 21 || len(log.segs) < 2 {
+		t.Errorf("Got last id %d segments %d", log.LastID(), len(log.segs))
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		log.Append([]byte(fmt.Sprint(i)))
+	}
+	log.Close()
+
+	// torn record at tail
+	path := log.segmentPath(1)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(appendRecord(nil, 4, []byte("torn"))[:10])
+	f.Close()
+
+	log, err = OpenLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.LastID() != 3 {
+		t.Errorf("Got %d expected %d", log.LastID(), 3)
+	}
+	if id, err := log.Append([]byte("3")); err != nil || id != 4 {
+		t.Errorf("Got %d %v expected %d", id, err, 4)
+	}
+	got := []string{}
+	log.Iterate(0, func(id uint64, data []byte) bool {
+		got = append(got, fmt.Sprintf("%d:%s", id, data))
+		return true
+	})
+	if fmt.Sprint(got) != "[1:0 2:1 3:2 4:3]" {
+		t.Errorf("Got %v", got)
+	}
+	log.Close()
+}
+
+func TestCorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := OpenLog(dir, WithSegmentSize(1))
+	for i := 0; i < 3; i++ {
+		log.Append([]byte("data"))
+	}
+	log.Close()
+
+	// flip a byte of the first (not last) segment
+	path := log.segmentPath(1)
+	b, _ := os.ReadFile(path)
+	b[len(b)-1] ^= 0xff
+	os.WriteFile(path, b, 0644)
+
+	if _, err := OpenLog(dir); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Got %v expected %v", err, ErrCorrupted)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	db, err := Wrap(func() driver.IDB { db, _ := memkv.NewStore().Open("group"); return db }(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n := int64(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := db.SyncPut([]byte(fmt.Sprintf("%d-%d", i, j)), []byte("v")); err != nil {
+					t.Error(err)
+				}
+				atomic.AddInt64(&n, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	log := db.Log()
+	if log.SyncedID() != uint64(n) || log.LastID() != uint64(n) {
+		t.Errorf("Got synced %d last %d expected %d", log.SyncedID(), log.LastID(), n)
+	}
+	if syncs := atomic.LoadUint64(&log.syncs); syncs == 0 || syncs > uint64(n) {
+		t.Errorf("Got syncs %d expected (0, %d]", syncs, n)
+	}
+}
+
+func TestReadLogsPurge(t *testing.T) {
+	log, err := OpenLog(t.TempDir(), WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	for i := 1; i <= 10; i++ {
+		ops := utils.NewBatchOpBuffer()
+		ops.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+		log.Append(ops.Data())
+	}
+
+	buf, err := log.ReadLogs(4, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []uint64{}
+	DecodeLogs(buf, func(id uint64, data []byte) bool {
+		ops := utils.NewBatchOpBuffer()
+		if err := ops.Load(data); err != nil || string(ops.FrontElement().Value.(*utils.BatchOp).Key) != fmt.Sprintf("k%d", id) {
+			t.Errorf("log %d Got %v", id, err)
+		}
+		ids = append(ids, id)
+		return true
+	})
+	if fmt.Sprint(ids) != "[4 5]" {
+		t.Errorf("Got %v expected [4 5]", ids)
+	}
+	if buf, _ := log.ReadLogs(11, 60); len(buf) != 0 {
+		t.Errorf("Got %d bytes expected empty", len(buf))
+	}
+
+	if err := log.Purge(7); err != nil {
+		t.Fatal(err)
+	}
+	first := log.FirstID()
+	if first > 7 || first == 1 {
+		t.Errorf("Got first id %d expected (1, 7]", first)
+	}
+	if _, err := log.ReadLogs(first-1, 60); !errors.Is(err, ErrLogPurged) {
+		t.Errorf("Got %v expected %v", err, ErrLogPurged)
+	}
+	if files, _ := filepath.Glob(filepath.Join(log.dir, "*"+segmentExt)); len(files) != len(log.segs) {
+		t.Errorf("Got %d files expected %d", len(files), len(log.segs))
+	}
+}
+
+func TestUnsupportedOp(t *testing.T) {
+	path := t.TempDir()
+	db, err := NewStore(memkv.NewStore()).Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := utils.NewBatchOpBuffer()
+	ops.Put([]byte("a"), []byte("1"))
+	ops.Merge([]byte("a"), []byte("2"))
+	if err := db.(*DB).write(ops, false); !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("Got %v expected %v", err, ErrUnsupportedOp)
+	}
+	if id := db.(*DB).Log().LastID(); id != 0 {
+		t.Errorf("Got last id %d expected %d", id, 0)
+	}
+
+	// merge op in log is not dropped when replay
+	db.(*DB).Log().Append(ops.Data())
+	db.Close()
+	if _, err := NewStore(memkv.NewStore()).Open(path); !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("Got %v expected %v", err, ErrUnsupportedOp)
+	}
+}
+
+// failDB commit of write batch fails if fail is set
+type failDB struct {
+	driver.IDB
+	fail    bool
+	commits int
+}
+
+type failBatch struct {
+	driver.IWriteBatch
+	db *failDB
+}
+
+func (db *failDB) NewWriteBatch() driver.IWriteBatch {
+	return &failBatch{IWriteBatch: db.IDB.NewWriteBatch(), db: db}
+}
+
+func (wb *failBatch) Commit() error {
+	if wb.db.fail {
+		return errors.New("commit failed")
+	}
+	wb.db.commits++
+	return wb.IWriteBatch.Commit()
+}
+
+func TestApplyFailed(t *testing.T) {
+	dir := t.TempDir()
+	mem, _ := memkv.NewStore().Open("apply")
+	inner := &failDB{IDB: mem}
+	db, err := Wrap(inner, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("a"), []byte("1"))
+
+	inner.fail = true
+	if err := db.Put([]byte("b"), []byte("2")); !errors.Is(err, ErrApplyFailed) {
+		t.Errorf("Got %v expected %v", err, ErrApplyFailed)
+	}
+	// broken db rejects writes
+	inner.fail = false
+	if err := db.Put([]byte("c"), []byte("3")); !errors.Is(err, ErrApplyFailed) {
+		t.Errorf("Got %v expected %v", err, ErrApplyFailed)
+	}
+	if id := db.Log().LastID(); id != 2 {
+		t.Errorf("Got last id %d expected %d", id, 2)
+	}
+	db.Close()
+
+	// the appended log is applied when replay
+	mem2, _ := memkv.NewStore().Open("apply")
+	db2, err := Wrap(mem2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	if v, _ := db2.Get([]byte("b")); string(v) != "2" {
+		t.Errorf("Got %q expected %q", v, "2")
+	}
+	if err := db2.Put([]byte("c"), []byte("3")); err != nil {
+		t.Errorf("Got %v expected nil", err)
+	}
+}
+
+func TestDurableReplay(t *testing.T) {
+	dir := t.TempDir()
+	// the engine data is kept after the wal db is reopened
+	mem, _ := memkv.NewStore().Open("durable")
+	defer mem.Close()
+	db, err := Wrap(mem, dir, WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+	}
+	if err := db.SetDurable(db.AppliedID() + 1); err == nil {
+		t.Errorf("Got nil expected error of durable id > applied id")
+	}
+	if err := db.SetDurable(db.AppliedID()); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("k10"), []byte("v"))
+	db.Delete([]byte("k0"))
+
+	// logs after durable id are kept
+	if err := db.Purge(db.Log().LastID() + 1); err != nil {
+		t.Fatal(err)
+	}
+	if first := db.Log().FirstID(); first > 11 {
+		t.Errorf("Got first id %d expected <= %d", first, 11)
+	}
+	db.Log().Close()
+
+	inner := &failDB{IDB: mem}
+	db2, err := Wrap(inner, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Log().Close()
+	if inner.commits != 2 || db2.DurableID() != 10 || db2.AppliedID() != 12 {
+		t.Errorf("Got replayed %d durable %d applied %d expected 2 10 12", inner.commits, db2.DurableID(), db2.AppliedID())
+	}
+	if v, _ := db2.Get([]byte("k10")); string(v) != "v" {
+		t.Errorf("Got %q expected %q", v, "v")
+	}
+
+	// bad durable file
+	db2.Log().Close()
+	os.WriteFile(filepath.Join(dir, DurableFileName), []byte("bad"), 0644)
+	if _, err := Wrap(mem, dir); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Got %v expected %v", err, ErrCorrupted)
+	}
+}
@@ -0,0 +1,681 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/utils"
+)
+
+var (
+	ErrPubSubClientClosed      = errors.New("pubsub client closed")
+	ErrPubSubOutputBufferLimit = errors.New("pubsub client output buffer limit reached, disconnected")
+	ErrPubSubCrossSlot         = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+)
+
+const (
+	PubSubKindMessage      = "message"
+	PubSubKindPMessage     = "pmessage"
+	PubSubKindSMessage     = "smessage"
+	PubSubKindSubscribe    = "subscribe"
+	PubSubKindUnsubscribe  = "unsubscribe"
+	PubSubKindPSubscribe   = "psubscribe"
+	PubSubKindPUnsubscribe = "punsubscribe"
+	PubSubKindSSubscribe   = "ssubscribe"
+	PubSubKindSUnsubscribe = "sunsubscribe"
+)
+
+// ClusterSlots slots count of redis cluster
+const ClusterSlots = 16384
+
+// KeyHashSlot redis cluster hash slot of key, only the {hashtag} is hashed if exists
+func KeyHashSlot(key []byte) uint64 {
+	if s := bytes.IndexByte(key, '{'); s >= 0 {
+		if e := bytes.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return uint64(utils.Crc16(key)) % ClusterSlots
+}
+
+// PubSubOptions pub/sub hub options, output buffer limits are like redis client-output-buffer-limit pubsub
+type PubSubOptions struct {
+	// OutputBufferHardLimit disconnect client if queued bytes exceed it, 0 is unlimited
+	OutputBufferHardLimit int `mapstructure:"outputBufferHardLimit"`
+	// OutputBufferSoftLimit disconnect client if queued bytes exceed it for OutputBufferSoftDuration, 0 is unlimited
+	OutputBufferSoftLimit    int           `mapstructure:"outputBufferSoftLimit"`
+	OutputBufferSoftDuration time.Duration `mapstructure:"outputBufferSoftDuration"`
+
+	slot func(channel []byte) uint64
+}
+
+func DefaultPubSubOptions() *PubSubOptions {
+	return &PubSubOptions{
+		OutputBufferHardLimit:    32 << 20,
+		OutputBufferSoftLimit:    8 << 20,
+		OutputBufferSoftDuration: 60 * time.Second,
+		slot:                     KeyHashSlot,
+	}
+}
+
+func (o *PubSubOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithPubSubOutputBufferLimit(hard, soft int, softDuration time.Duration) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*PubSubOptions)
+		if !ok {
+			return
+		}
+		o.OutputBufferHardLimit, o.OutputBufferSoftLimit, o.OutputBufferSoftDuration = hard, soft, softDuration
+	})
+}
+
+// WithPubSubSlot use slot to map shard channel to slot, default is KeyHashSlot
+func WithPubSubSlot(slot func(channel []byte) uint64) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*PubSubOptions)
+		if !ok {
+			return
+		}
+		o.slot = slot
+	})
+}
+
+// WithPubSubOptions use opts, eg: unmarshaled from config
+func WithPubSubOptions(opts PubSubOptions) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*PubSubOptions)
+		if !ok {
+			return
+		}
+		slot := o.slot
+		*o = opts
+		o.slot = slot
+	})
+}
+
+// PubSubMessage message pushed to subscriber,
+// Count is the subscriptions count of client for (un)subscribe kinds
+type PubSubMessage struct {
+	Kind    string
+	Pattern []byte
+	Channel []byte
+	Payload []byte
+	Count   int64
+}
+
+// pubSubMessageOverhead resp protocol bytes of message
+const pubSubMessageOverhead = 32
+
+func (m *PubSubMessage) size() int {
+	return len(m.Kind) + len(m.Pattern) + len(m.Channel) + len(m.Payload) + pubSubMessageOverhead
+}
+
+// Reply resp array reply of message
+func (m *PubSubMessage) Reply() []interface{} {
+	switch m.Kind {
+	case PubSubKindMessage, PubSubKindSMessage:
+		return []interface{}{[]byte(m.Kind), m.Channel, m.Payload}
+	case PubSubKindPMessage:
+		return []interface{}{[]byte(m.Kind), m.Pattern, m.Channel, m.Payload}
+	}
+	return []interface{}{[]byte(m.Kind), m.Channel, m.Count}
+}
+
+type pubSubClients map[*PubSubClient]struct{}
+
+type pubSubPattern struct {
+	re      *regexp.Regexp
+	clients pubSubClients
+}
+
+// PubSubStats pub/sub hub stats
+type PubSubStats struct {
+	Channels      int
+	Patterns      int
+	ShardChannels int
+	Clients       int64
+	Published     uint64
+	// Disconnected clients which are disconnected by output buffer limits
+	Disconnected uint64
+}
+
+// PubSub pub/sub hub of channels, patterns and shard channels of slots,
+// publishers never block, messages are queued to the outbound queue of subscriber client,
+// the client which is over output buffer limits is disconnected.
+type PubSub struct {
+	opts *PubSubOptions
+
+	mu       sync.RWMutex
+	channels map[string]pubSubClients
+	patterns map[string]*pubSubPattern
+	shards   map[uint64]map[string]pubSubClients
+
+	clients      atomic.Int64
+	published    atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+func NewPubSub(opts ...option.Option) *PubSub {
+	o := DefaultPubSubOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+
+	return &PubSub{
+		opts:     o,
+		channels: map[string]pubSubClients{},
+		patterns: map[string]*pubSubPattern{},
+		shards:   map[uint64]map[string]pubSubClients{},
+	}
+}
+
+// NewClient new subscriber client of conn, conn is closed if client is over output buffer limits
+func (h *PubSub) NewClient(conn IRespConn) *PubSubClient {
+	h.clients.Add(1)
+	return &PubSubClient{
+		hub:           h,
+		conn:          conn,
+		channels:      map[string]struct{}{},
+		patterns:      map[string]struct{}{},
+		shardChannels: map[string]struct{}{},
+		notify:        make(chan struct{}, 1),
+	}
+}
+
+// Publish publish payload to subscribers of channel and matched patterns, return receivers count
+func (h *PubSub) Publish(channel, payload []byte) int64 {
+	channel, payload = append([]byte{}, channel...), append([]byte{}, payload...)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := int64(0)
+	for c := range h.channels[string(channel)] {
+		c.push(&PubSubMessage{Kind: PubSubKindMessage, Channel: channel, Payload: payload})
+		n++
+	}
+	for p, pat := range h.patterns {
+		if !pat.re.Match(channel) {
+			continue
+		}
+		for c := range pat.clients {
+			c.push(&PubSubMessage{Kind: PubSubKindPMessage, Pattern: []byte(p), Channel: channel, Payload: payload})
+			n++
+		}
+	}
+	h.published.Add(1)
+	return n
+}
+
+// SPublish publish payload to subscribers of shard channel, return receivers count
+func (h *PubSub) SPublish(channel, payload []byte) int64 {
+	channel, payload = append([]byte{}, channel...), append([]byte{}, payload...)
+	slot := h.opts.slot(channel)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := int64(0)
+	for c := range h.shards[slot][string(channel)] {
+		c.push(&PubSubMessage{Kind: PubSubKindSMessage, Channel: channel, Payload: payload})
+		n++
+	}
+	h.published.Add(1)
+	return n
+}
+
+// Slot shard channel slot
+func (h *PubSub) Slot(channel []byte) uint64 {
+	return h.opts.slot(channel)
+}
+
+func matchChannels(pattern []byte, channels []string) ([][]byte, error) {
+	var re *regexp.Regexp
+	if pattern != nil {
+		var err error
+		if re, err = utils.BuildMatchRegexp(utils.GlobToRegexp(string(pattern))); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(channels)
+	res := [][]byte{}
+	for _, ch := range channels {
+		if re == nil || re.MatchString(ch) {
+			res = append(res, []byte(ch))
+		}
+	}
+	return res, nil
+}
+
+// Channels PUBSUB CHANNELS [pattern], active channels which match glob pattern, all if pattern is nil
+func (h *PubSub) Channels(pattern []byte) ([][]byte, error) {
+	h.mu.RLock()
+	channels := make([]string, 0, len(h.channels))
+	for ch := range h.channels {
+		channels = append(channels, ch)
+	}
+	h.mu.RUnlock()
+	return matchChannels(pattern, channels)
+}
+
+// NumSub PUBSUB NUMSUB [channel ...], subscribers count of channels (not include patterns)
+func (h *PubSub) NumSub(channels ...[]byte) []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	res := make([]int64, len(channels))
+	for i, ch := range channels {
+		res[i] = int64(len(h.channels[string(ch)]))
+	}
+	return res
+}
+
+// NumPat PUBSUB NUMPAT, unique patterns count
+func (h *PubSub) NumPat() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return int64(len(h.patterns))
+}
+
+// ShardChannels PUBSUB SHARDCHANNELS [pattern], active shard channels which match glob pattern
+func (h *PubSub) ShardChannels(pattern []byte) ([][]byte, error) {
+	h.mu.RLock()
+	channels := []string{}
+	for _, chs := range h.shards {
+		for ch := range chs {
+			channels = append(channels, ch)
+		}
+	}
+	h.mu.RUnlock()
+	return matchChannels(pattern, channels)
+}
+
+// ShardNumSub PUBSUB SHARDNUMSUB [shardchannel ...], subscribers count of shard channels
+func (h *PubSub) ShardNumSub(channels ...[]byte) []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	res := make([]int64, len(channels))
+	for i, ch := range channels {
+		res[i] = int64(len(h.shards[h.opts.slot(ch)][string(ch)]))
+	}
+	return res
+}
+
+// DropSlot unsubscribe all shard channels of slot, eg: slot is migrated to other node,
+// subscribers receive sunsubscribe, return dropped channels count
+func (h *PubSub) DropSlot(slot uint64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	chs := h.shards[slot]
+	for ch, clients := range chs {
+		for c := range clients {
+			delete(c.shardChannels, ch)
+			c.push(&PubSubMessage{Kind: PubSubKindSUnsubscribe, Channel: []byte(ch), Count: int64(len(c.shardChannels))})
+		}
+	}
+	delete(h.shards, slot)
+	return len(chs)
+}
+
+// Stats return stats of hub
+func (h *PubSub) Stats() PubSubStats {
+	h.mu.RLock()
+	shardChannels := 0
+	for _, chs := range h.shards {
+		shardChannels += len(chs)
+	}
+	st := PubSubStats{Channels: len(h.channels), Patterns: len(h.patterns), ShardChannels: shardChannels}
+	h.mu.RUnlock()
+
+	st.Clients = h.clients.Load()
+	st.Published = h.published.Load()
+	st.Disconnected = h.disconnected.Load()
+	return st
+}
+
+// PubSubClient subscriber client of conn, it has subscriptions and the outbound queue,
+// the server pushes the messages from Next to conn.
+type PubSubClient struct {
+	hub  *PubSub
+	conn IRespConn
+
+	// subscriptions guarded by hub.mu
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+
+	mu        sync.Mutex
+	queue     []*PubSubMessage
+	queued    int
+	softSince time.Time
+	closed    bool
+	err       error
+	notify    chan struct{}
+}
+
+// push queue message, disconnect client if it is over output buffer limits, called with hub.mu held
+func (c *PubSubClient) push(m *PubSubMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+
+	c.queue = append(c.queue, m)
+	c.queued += m.size()
+	o := c.hub.opts
+	over := o.OutputBufferHardLimit > 0 && c.queued > o.OutputBufferHardLimit
+	if o.OutputBufferSoftLimit > 0 && c.queued > o.OutputBufferSoftLimit {
+		if now := time.Now(); c.softSince.IsZero() {
+			c.softSince = now
+		} else if now.Sub(c.softSince) >= o.OutputBufferSoftDuration {
+			over = true
+		}
+	} else {
+		c.softSince = time.Time{}
+	}
+	if over {
+		c.closeLocked(ErrPubSubOutputBufferLimit)
+		c.hub.disconnected.Add(1)
+		go c.disconnect()
+	}
+	utils.AsyncNoBlockSend(c.notify)
+}
+
+func (c *PubSubClient) closeLocked(err error) {
+	if !c.closed {
+		c.hub.clients.Add(-1)
+	}
+	c.closed, c.err = true, err
+	c.queue, c.queued = nil, 0
+	utils.AsyncNoBlockSend(c.notify)
+}
+
+func (c *PubSubClient) disconnect() {
+	c.unsubscribeAll()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *PubSubClient) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *PubSubClient) count() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+
+// Subscriptions subscriptions count of channels, patterns and shard channels,
+// conn is in subscribed mode if > 0
+func (c *PubSubClient) Subscriptions() int {
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	return len(c.channels) + len(c.patterns) + len(c.shardChannels)
+}
+
+// Subscribe SUBSCRIBE channel [channel ...], confirmations are pushed to outbound queue
+func (c *PubSubClient) Subscribe(channels ...[]byte) error {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+
+	for _, ch := range channels {
+		if _, ok := c.channels[string(ch)]; !ok {
+			c.channels[string(ch)] = struct{}{}
+			if h.channels[string(ch)] == nil {
+				h.channels[string(ch)] = pubSubClients{}
+			}
+			h.channels[string(ch)][c] = struct{}{}
+		}
+		c.push(&PubSubMessage{Kind: PubSubKindSubscribe, Channel: append([]byte{}, ch...), Count: c.count()})
+	}
+	return nil
+}
+
+// PSubscribe PSUBSCRIBE pattern [pattern ...], pattern is glob-style
+func (c *PubSubClient) PSubscribe(patterns ...[]byte) error {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := utils.BuildMatchRegexp(utils.GlobToRegexp(string(p)))
+		if err != nil {
+			return err
+		}
+		res[i] = re
+	}
+
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+
+	for i, p := range patterns {
+		if _, ok := c.patterns[string(p)]; !ok {
+			c.patterns[string(p)] = struct{}{}
+			if h.patterns[string(p)] == nil {
+				h.patterns[string(p)] = &pubSubPattern{re: res[i], clients: pubSubClients{}}
+			}
+			h.patterns[string(p)].clients[c] = struct{}{}
+		}
+		c.push(&PubSubMessage{Kind: PubSubKindPSubscribe, Channel: append([]byte{}, p...), Count: c.count()})
+	}
+	return nil
+}
+
+// SSubscribe SSUBSCRIBE shardchannel [shardchannel ...],
+// return ErrPubSubCrossSlot if channels are not in the same slot
+func (c *PubSubClient) SSubscribe(channels ...[]byte) error {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+	for i := 1; i < len(channels); i++ {
+		if h.opts.slot(channels[i]) != h.opts.slot(channels[0]) {
+			return ErrPubSubCrossSlot
+		}
+	}
+
+	for _, ch := range channels {
+		if _, ok := c.shardChannels[string(ch)]; !ok {
+			c.shardChannels[string(ch)] = struct{}{}
+			slot := h.opts.slot(ch)
+			if h.shards[slot] == nil {
+				h.shards[slot] = map[string]pubSubClients{}
+			}
+			if h.shards[slot][string(ch)] == nil {
+				h.shards[slot][string(ch)] = pubSubClients{}
+			}
+			h.shards[slot][string(ch)][c] = struct{}{}
+		}
+		c.push(&PubSubMessage{Kind: PubSubKindSSubscribe, Channel: append([]byte{}, ch...), Count: int64(len(c.shardChannels))})
+	}
+	return nil
+}
+
+func subscribedKeys(subs map[string]struct{}, keys [][]byte) [][]byte {
+	if len(keys) > 0 {
+		return keys
+	}
+	for k := range subs {
+		keys = append(keys, []byte(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// Unsubscribe UNSUBSCRIBE [channel [channel ...]], all channels if no channel
+func (c *PubSubClient) Unsubscribe(channels ...[]byte) error {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+
+	channels = subscribedKeys(c.channels, channels)
+	for _, ch := range channels {
+		c.unsubscribeLocked(string(ch))
+		c.push(&PubSubMessage{Kind: PubSubKindUnsubscribe, Channel: append([]byte{}, ch...), Count: c.count()})
+	}
+	if len(channels) == 0 {
+		c.push(&PubSubMessage{Kind: PubSubKindUnsubscribe, Count: c.count()})
+	}
+	return nil
+}
+
+// PUnsubscribe PUNSUBSCRIBE [pattern [pattern ...]], all patterns if no pattern
+func (c *PubSubClient) PUnsubscribe(patterns ...[]byte) error {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+
+	patterns = subscribedKeys(c.patterns, patterns)
+	for _, p := range patterns {
+		c.punsubscribeLocked(string(p))
+		c.push(&PubSubMessage{Kind: PubSubKindPUnsubscribe, Channel: append([]byte{}, p...), Count: c.count()})
+	}
+	if len(patterns) == 0 {
+		c.push(&PubSubMessage{Kind: PubSubKindPUnsubscribe, Count: c.count()})
+	}
+	return nil
+}
+
+// SUnsubscribe SUNSUBSCRIBE [shardchannel [shardchannel ...]], all shard channels if no channel
+func (c *PubSubClient) SUnsubscribe(channels ...[]byte) error {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+
+	channels = subscribedKeys(c.shardChannels, channels)
+	for _, ch := range channels {
+		c.sunsubscribeLocked(string(ch))
+		c.push(&PubSubMessage{Kind: PubSubKindSUnsubscribe, Channel: append([]byte{}, ch...), Count: int64(len(c.shardChannels))})
+	}
+	if len(channels) == 0 {
+		c.push(&PubSubMessage{Kind: PubSubKindSUnsubscribe})
+	}
+	return nil
+}
+
+func (c *PubSubClient) unsubscribeLocked(ch string) {
+	h := c.hub
+	delete(c.channels, ch)
+	if clients := h.channels[ch]; clients != nil {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.channels, ch)
+		}
+	}
+}
+
+func (c *PubSubClient) punsubscribeLocked(p string) {
+	h := c.hub
+	delete(c.patterns, p)
+	if pat := h.patterns[p]; pat != nil {
+		delete(pat.clients, c)
+		if len(pat.clients) == 0 {
+			delete(h.patterns, p)
+		}
+	}
+}
+
+func (c *PubSubClient) sunsubscribeLocked(ch string) {
+	h := c.hub
+	delete(c.shardChannels, ch)
+	slot := h.opts.slot([]byte(ch))
+	if clients := h.shards[slot][ch]; clients != nil {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.shards[slot], ch)
+		}
+		if len(h.shards[slot]) == 0 {
+			delete(h.shards, slot)
+		}
+	}
+}
+
+func (c *PubSubClient) unsubscribeAll() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	for ch := range c.channels {
+		c.unsubscribeLocked(ch)
+	}
+	for p := range c.patterns {
+		c.punsubscribeLocked(p)
+	}
+	for ch := range c.shardChannels {
+		c.sunsubscribeLocked(ch)
+	}
+}
+
+// Next pop the next message of outbound queue, wait until a message is queued,
+// return the close reason if client is closed, eg: ErrPubSubOutputBufferLimit
+func (c *PubSubClient) Next(ctx context.Context) (*PubSubMessage, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			m := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.queued -= m.size()
+			c.mu.Unlock()
+			return m, nil
+		}
+		err := c.err
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.notify:
+		}
+	}
+}
+
+// Pending return queued messages count and bytes of outbound queue
+func (c *PubSubClient) Pending() (n int, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue), c.queued
+}
+
+// Close unsubscribe all and close client, eg: conn is closed
+func (c *PubSubClient) Close() error {
+	c.unsubscribeAll()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closeLocked(ErrPubSubClientClosed)
+	}
+	return nil
+}
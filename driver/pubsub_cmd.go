@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrPubSubConnUnsupported = errors.New("ERR conn doesn't support pub/sub")
+
+func errPubSubArgs(cmd string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
+}
+
+func pubSubClient(c IRespConn) (*PubSubClient, error) {
+	pc, ok := c.(IRespPubSubConn)
+	if !ok || pc.PubSubClient() == nil {
+		return nil, ErrPubSubConnUnsupported
+	}
+	return pc.PubSubClient(), nil
+}
+
+// pubSubSubscribeHandle (un)subscribe cmd handle, the confirmations are pushed to the outbound queue of conn,
+// so the reply is nil and should not be written to conn
+func pubSubSubscribeHandle(cmd string, minArgs int, fn func(c *PubSubClient, args ...[]byte) error) CmdHandle {
+	return func(ctx context.Context, c IRespConn, cmdParams [][]byte) (interface{}, error) {
+		if len(cmdParams) < minArgs {
+			return nil, errPubSubArgs(cmd)
+		}
+		client, err := pubSubClient(c)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(client, cmdParams...)
+	}
+}
+
+func numSubReply(channels [][]byte, counts []int64) []interface{} {
+	res := make([]interface{}, 0, 2*len(channels))
+	for i, ch := range channels {
+		res = append(res, ch, counts[i])
+	}
+	return res
+}
+
+// RegisterPubSubCmds register pub/sub cmds of hub to CmdTypePubSub,
+// the conn must be IRespPubSubConn for (un)subscribe cmds
+func RegisterPubSubCmds(hub *PubSub) {
+	RegisterCmd(CmdTypePubSub, "subscribe", pubSubSubscribeHandle("subscribe", 1, (*PubSubClient).Subscribe))
+	RegisterCmd(CmdTypePubSub, "psubscribe", pubSubSubscribeHandle("psubscribe", 1, (*PubSubClient).PSubscribe))
+	RegisterCmd(CmdTypePubSub, "ssubscribe", pubSubSubscribeHandle("ssubscribe", 1, (*PubSubClient).SSubscribe))
+	RegisterCmd(CmdTypePubSub, "unsubscribe", pubSubSubscribeHandle("unsubscribe", 0, (*PubSubClient).Unsubscribe))
+	RegisterCmd(CmdTypePubSub, "punsubscribe", pubSubSubscribeHandle("punsubscribe", 0, (*PubSubClient).PUnsubscribe))
+	RegisterCmd(CmdTypePubSub, "sunsubscribe", pubSubSubscribeHandle("sunsubscribe", 0, (*PubSubClient).SUnsubscribe))
+
+	RegisterCmd(CmdTypePubSub, "publish", func(ctx context.Context, c IRespConn, cmdParams [][]byte) (interface{}, error) {
+		if len(cmdParams) != 2 {
+			return nil, errPubSubArgs("publish")
+		}
+		return hub.Publish(cmdParams[0], cmdParams[1]), nil
+	})
+	RegisterCmd(CmdTypePubSub, "spublish", func(ctx context.Context, c IRespConn, cmdParams [][]byte) (interface{}, error) {
+		if len(cmdParams) != 2 {
+			return nil, errPubSubArgs("spublish")
+		}
+		return hub.SPublish(cmdParams[0], cmdParams[1]), nil
+	})
+	RegisterCmd(CmdTypePubSub, "pubsub", func(ctx context.Context, c IRespConn, cmdParams [][]byte) (interface{}, error) {
+		if len(cmdParams) < 1 {
+			return nil, errPubSubArgs("pubsub")
+		}
+		sub, args := strings.ToLower(string(cmdParams[0])), cmdParams[1:]
+		switch {
+		case (sub == "channels" || sub == "shardchannels") && len(args) <= 1:
+			var pattern []byte
+			if len(args) == 1 {
+				pattern = args[0]
+			}
+			if sub == "channels" {
+				return hub.Channels(pattern)
+			}
+			return hub.ShardChannels(pattern)
+		case sub == "numsub":
+			return numSubReply(args, hub.NumSub(args...)), nil
+		case sub == "shardnumsub":
+			return numSubReply(args, hub.ShardNumSub(args...)), nil
+		case sub == "numpat" && len(args) == 0:
+			return hub.NumPat(), nil
+		}
+		return nil, fmt.Errorf("ERR unknown subcommand or wrong number of arguments for '%s'", sub)
+	})
+}
@@ -0,0 +1,218 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weedge/pkg/utils"
+)
+
+type pubSubConn struct {
+	RespConnBase
+	client *PubSubClient
+	closed atomic.Bool
+}
+
+func (c *pubSubConn) PubSubClient() *PubSubClient { return c.client }
+func (c *pubSubConn) Close() error {
+	c.closed.Store(true)
+	return c.client.Close()
+}
+
+func newPubSubConn(hub *PubSub) *pubSubConn {
+	c := &pubSubConn{}
+	c.client = hub.NewClient(c)
+	return c
+}
+
+// drain pop queued messages as replies
+func drain(t *testing.T, c *PubSubClient) string {
+	t.Helper()
+	s := ""
+	for {
+		if n, _ := c.Pending(); n == 0 {
+			return s
+		}
+		m, err := c.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s += fmt.Sprintf("%s;", m.Reply())
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	hub := NewPubSub()
+	c1, c2 := hub.NewClient(nil), hub.NewClient(nil)
+
+	c1.Subscribe([]byte("news.tech"), []byte("news.art"))
+	c2.PSubscribe([]byte("news.*"), []byte("h?llo"))
+	if got, expected := drain(t, c1), "[subscribe news.tech %!s(int64=1)];[subscribe news.art %!s(int64=2)];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	drain(t, c2)
+
+	if n := hub.Publish([]byte("news.tech"), []byte("go")); n != 2 {
+		t.Errorf("Got %d expected %d", n, 2)
+	}
+	if n := hub.Publish([]byte("hello"), []byte("world")); n != 1 {
+		t.Errorf("Got %d expected %d", n, 1)
+	}
+	if got, expected := drain(t, c1), "[message news.tech go];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	if got, expected := drain(t, c2), "[pmessage news.* news.tech go];[pmessage h?llo hello world];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+
+	channels, _ := hub.Channels([]byte("news.t*"))
+	if fmt.Sprintf("%s", channels) != "[news.tech]" {
+		t.Errorf("Got %s", channels)
+	}
+	if got := hub.NumSub([]byte("news.art"), []byte("none")); fmt.Sprint(got) != "[1 0]" {
+		t.Errorf("Got %v", got)
+	}
+	if n := hub.NumPat(); n != 2 {
+		t.Errorf("Got %d expected %d", n, 2)
+	}
+
+	c1.Unsubscribe()
+	if got, expected := drain(t, c1), "[unsubscribe news.art %!s(int64=1)];[unsubscribe news.tech %!s(int64=0)];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	c1.Unsubscribe()
+	if got, expected := drain(t, c1), "[unsubscribe  %!s(int64=0)];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	c2.Close()
+	if _, err := c2.Next(context.Background()); err != ErrPubSubClientClosed {
+		t.Errorf("Got %v expected %v", err, ErrPubSubClientClosed)
+	}
+	if st := hub.Stats(); st.Channels != 0 || st.Patterns != 0 || st.Clients != 1 || st.Published != 2 {
+		t.Errorf("Got %+v", st)
+	}
+}
+
+func TestPubSubShard(t *testing.T) {
+	// redis: CLUSTER KEYSLOT foo
+	if slot := KeyHashSlot([]byte("foo")); slot != 12182 {
+		t.Errorf("Got %d expected %d", slot, 12182)
+	}
+	if KeyHashSlot([]byte("{user1000}.following")) != KeyHashSlot([]byte("user1000")) ||
+		KeyHashSlot([]byte("foo{}{bar}")) != uint64(utils.Crc16([]byte("foo{}{bar}")))%ClusterSlots {
+		t.Errorf("hashtag slot mismatch")
+	}
+
+	hub := NewPubSub()
+	c := hub.NewClient(nil)
+	if err := c.SSubscribe([]byte("{user1}.a"), []byte("other")); err != ErrPubSubCrossSlot {
+		t.Errorf("Got %v expected %v", err, ErrPubSubCrossSlot)
+	}
+	c.SSubscribe([]byte("{user1}.a"), []byte("{user1}.b"))
+	c.SSubscribe([]byte("other"))
+	drain(t, c)
+	if n := hub.SPublish([]byte("{user1}.a"), []byte("v")); n != 1 {
+		t.Errorf("Got %d expected %d", n, 1)
+	}
+	if n := hub.Publish([]byte("{user1}.a"), []byte("v")); n != 0 {
+		t.Errorf("Got %d expected %d", n, 0)
+	}
+	if got, expected := drain(t, c), "[smessage {user1}.a v];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+
+	if n := hub.DropSlot(hub.Slot([]byte("user1"))); n != 2 {
+		t.Errorf("Got %d expected %d", n, 2)
+	}
+	drain(t, c)
+	channels, _ := hub.ShardChannels(nil)
+	if fmt.Sprintf("%s", channels) != "[other]" {
+		t.Errorf("Got %s", channels)
+	}
+	if got := hub.ShardNumSub([]byte("other"), []byte("{user1}.a")); fmt.Sprint(got) != "[1 0]" {
+		t.Errorf("Got %v", got)
+	}
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	hub := NewPubSub(WithPubSubOutputBufferLimit(1024, 0, 0))
+	slow, fast := newPubSubConn(hub), newPubSubConn(hub)
+	slow.client.Subscribe([]byte("ch"))
+	fast.client.Subscribe([]byte("ch"))
+
+	payload := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		hub.Publish([]byte("ch"), payload)
+		drain(t, fast.client)
+	}
+	if _, err := slow.client.Next(context.Background()); err != ErrPubSubOutputBufferLimit {
+		t.Errorf("Got %v expected %v", err, ErrPubSubOutputBufferLimit)
+	}
+	for i := 0; i < 100 && !slow.closed.Load(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !slow.closed.Load() || fast.closed.Load() {
+		t.Errorf("Got slow closed %v fast closed %v", slow.closed.Load(), fast.closed.Load())
+	}
+	if got := hub.NumSub([]byte("ch")); got[0] != 1 {
+		t.Errorf("Got %d expected %d", got[0], 1)
+	}
+	if st := hub.Stats(); st.Disconnected != 1 {
+		t.Errorf("Got %d expected %d", st.Disconnected, 1)
+	}
+
+	// soft limit
+	hub = NewPubSub(WithPubSubOutputBufferLimit(0, 200, 10*time.Millisecond))
+	c := hub.NewClient(nil)
+	c.Subscribe([]byte("ch"))
+	hub.Publish([]byte("ch"), payload)
+	hub.Publish([]byte("ch"), payload)
+	if _, err := c.Next(context.Background()); err != nil {
+		t.Errorf("Got %v before soft duration", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	hub.Publish([]byte("ch"), payload)
+	if _, err := c.Next(context.Background()); err != ErrPubSubOutputBufferLimit {
+		t.Errorf("Got %v expected %v", err, ErrPubSubOutputBufferLimit)
+	}
+}
+
+// cmdPubSubHub cmds are registered once with the hub
+var cmdPubSubHub = NewPubSub()
+
+func TestPubSubCmds(t *testing.T) {
+	ctx := context.Background()
+	hub := cmdPubSubHub
+	RegisterPubSubCmds(hub)
+	conn := newPubSubConn(hub)
+	defer conn.Close()
+	do := func(c IRespConn, cmd string, params [][]byte) (interface{}, error) {
+		return RegisteredCmdHandles[cmd](ctx, c, params)
+	}
+
+	if _, err := do(conn, "subscribe", [][]byte{[]byte("ch")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := do(conn, "subscribe", nil); err == nil {
+		t.Errorf("Got nil expected wrong number of arguments")
+	}
+	if _, err := do(&RespConnBase{}, "subscribe", [][]byte{[]byte("ch")}); err != ErrPubSubConnUnsupported {
+		t.Errorf("Got %v expected %v", err, ErrPubSubConnUnsupported)
+	}
+	if n, _ := do(conn, "publish", [][]byte{[]byte("ch"), []byte("hi")}); n != int64(1) {
+		t.Errorf("Got %v expected %v", n, 1)
+	}
+	if got, expected := drain(t, conn.client), "[subscribe ch %!s(int64=1)];[message ch hi];"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	res, _ := do(conn, "pubsub", [][]byte{[]byte("NUMSUB"), []byte("ch")})
+	if fmt.Sprintf("%s", res) != "[ch %!s(int64=1)]" {
+		t.Errorf("Got %s", res)
+	}
+	if _, err := do(conn, "pubsub", [][]byte{[]byte("numpat"), []byte("x")}); err == nil {
+		t.Errorf("Got nil expected error")
+	}
+}
@@ -0,0 +1,496 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/weedge/pkg/option"
+	"github.com/weedge/pkg/rdb"
+	"github.com/weedge/pkg/rdb/structure"
+	"github.com/weedge/pkg/rdb/types"
+)
+
+var (
+	ErrSnapshotUnsupported = errors.New("db snapshot is unsupported, db must be IDBSnapshoter")
+	ErrRDBCorrupted        = errors.New("rdb file corrupted")
+	ErrRDBUnsupported      = errors.New("rdb value unsupported")
+)
+
+// IDBSnapshoter db which can walk a consistent snapshot of its keys, eg: for RDB export
+type IDBSnapshoter interface {
+	Snapshot(ctx context.Context) (IDBSnapshot, error)
+}
+
+// IDBSnapshot consistent read view of db, usually over the openkv ISnapshot of storager
+type IDBSnapshot interface {
+	// Walk call fn for each key, dataType is CmdTypeString/List/Hash/Set/Zset,
+	// expireAt is unix ms, 0 is no ttl, stop walk if fn return error
+	Walk(ctx context.Context, fn func(key []byte, dataType string, expireAt int64) error) error
+
+	String(ctx context.Context, key []byte) ([]byte, error)
+	List(ctx context.Context, key []byte) ([][]byte, error)
+	Hash(ctx context.Context, key []byte) ([]FVPair, error)
+	Set(ctx context.Context, key []byte) ([][]byte, error)
+	ZSet(ctx context.Context, key []byte) ([]ScorePair, error)
+
+	Close()
+}
+
+// rdbMaxExactScore the max abs int64 zset score which is exact in double
+const rdbMaxExactScore = 1 << 53
+
+// RDBDatabases the db indexes [0, RDBDatabases) are exported if not specified
+var RDBDatabases = 16
+
+// RDBStats keys of RDB export or import
+type RDBStats struct {
+	Keys    uint64
+	Expires uint64
+	// Skipped expired keys, and unsupported values if import with SkipUnsupported
+	Skipped uint64
+}
+
+// ExportRDB walk the snapshot of dbs (default [0, RDBDatabases)) of storager,
+// and write a complete redis RDB file to w, the expired keys are skipped,
+// zset int64 scores are exported as double, return ErrRDBUnsupported
+// if a score is out of [-2^53, 2^53] which loses precision in double.
+func ExportRDB(ctx context.Context, s IStorager, w io.Writer, dbIndexes ...int) (stats RDBStats, err error) {
+	if len(dbIndexes) == 0 {
+		for i := 0; i < RDBDatabases; i++ {
+			dbIndexes = append(dbIndexes, i)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := rdb.NewEncoder(bw)
+	if err = enc.EncodeHeader(); err != nil {
+		return
+	}
+	for _, index := range dbIndexes {
+		if err = exportDB(ctx, s, enc, index, &stats); err != nil {
+			return
+		}
+	}
+	if err = enc.EncodeFooter(); err != nil {
+		return
+	}
+
+	return stats, bw.Flush()
+}
+
+func exportDB(ctx context.Context, s IStorager, enc *rdb.Encoder, index int, stats *RDBStats) error {
+	db, err := s.Select(ctx, index)
+	if err != nil {
+		return err
+	}
+	snapshoter, ok := db.(IDBSnapshoter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	snap, err := snapshoter.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	now := time.Now().UnixMilli()
+	selected := false
+	return snap.Walk(ctx, func(key []byte, dataType string, expireAt int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if expireAt > 0 && expireAt <= now {
+			stats.Skipped++
+			return nil
+		}
+		if !selected {
+			if err := enc.EncodeDatabase(index); err != nil {
+				return err
+			}
+			selected = true
+		}
+		if expireAt > 0 {
+			if err := enc.EncodeExpiry(uint64(expireAt)); err != nil {
+				return err
+			}
+			stats.Expires++
+		}
+		stats.Keys++
+
+		return exportValue(ctx, snap, enc, key, dataType)
+	})
+}
+
+func exportValue(ctx context.Context, snap IDBSnapshot, enc *rdb.Encoder, key []byte, dataType string) error {
+	switch dataType {
+	case CmdTypeString:
+		v, err := snap.String(ctx, key)
+		if err != nil {
+			return err
+		}
+		enc.EncodeType(types.RDBTypeString)
+		enc.EncodeString(key)
+		return enc.EncodeString(v)
+	case CmdTypeList:
+		l, err := snap.List(ctx, key)
+		if err != nil {
+			return err
+		}
+		enc.EncodeType(types.RDBTypeList)
+		enc.EncodeString(key)
+		return encodeStrings(enc, l)
+	case CmdTypeSet:
+		members, err := snap.Set(ctx, key)
+		if err != nil {
+			return err
+		}
+		enc.EncodeType(types.RDBTypeSet)
+		enc.EncodeString(key)
+		return encodeStrings(enc, members)
+	case CmdTypeHash:
+		fvs, err := snap.Hash(ctx, key)
+		if err != nil {
+			return err
+		}
+		enc.EncodeType(types.RDBTypeHash)
+		enc.EncodeString(key)
+		enc.EncodeLength(uint32(len(fvs)))
+		for _, fv := range fvs {
+			enc.EncodeString(fv.Field)
+			if err := enc.EncodeString(fv.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case CmdTypeZset:
+		pairs, err := snap.ZSet(ctx, key)
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			if p.Score > rdbMaxExactScore || p.Score < -rdbMaxExactScore {
+				return fmt.Errorf("%w: zset score %d of key %q is not exact in double", ErrRDBUnsupported, p.Score, key)
+			}
+		}
+		enc.EncodeType(types.RDBTypeZSet)
+		enc.EncodeString(key)
+		enc.EncodeLength(uint32(len(pairs)))
+		for _, p := range pairs {
+			enc.EncodeString(p.Member)
+			if err := enc.EncodeFloat(float64(p.Score)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: data type %s of key %q", ErrRDBUnsupported, dataType, key)
+}
+
+func encodeStrings(enc *rdb.Encoder, l [][]byte) error {
+	if err := enc.EncodeLength(uint32(len(l))); err != nil {
+		return err
+	}
+	for _, v := range l {
+		if err := enc.EncodeString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RDBImportOptions RDB import options
+type RDBImportOptions struct {
+	// BatchSize max elements of one command when import list/hash/set/zset
+	BatchSize int `mapstructure:"batchSize"`
+	// SkipUnsupported skip stream/module values and zset non integer scores, else return error
+	SkipUnsupported bool `mapstructure:"skipUnsupported"`
+}
+
+func DefaultRDBImportOptions() *RDBImportOptions {
+	return &RDBImportOptions{BatchSize: 512}
+}
+
+func (o *RDBImportOptions) String() string {
+	return fmt.Sprintf("%+v", *o)
+}
+
+func WithRDBImportBatchSize(n int) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*RDBImportOptions)
+		if !ok {
+			return
+		}
+		o.BatchSize = n
+	})
+}
+
+func WithRDBSkipUnsupported(skip bool) option.Option {
+	return option.NewOpt(func(op option.OptPrinter) {
+		o, ok := op.(*RDBImportOptions)
+		if !ok {
+			return
+		}
+		o.SkipUnsupported = skip
+	})
+}
+
+// rdbReadError the read error of rdb reader, structure readers of rdb don't return error,
+// so rdbReader panic it and ImportRDB recover it, the other panics are not recovered
+type rdbReadError struct {
+	err error
+}
+
+// rdbReader checksum the read bytes until EOF opcode
+type rdbReader struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+}
+
+func (r *rdbReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	if err != nil {
+		panic(rdbReadError{err})
+	}
+	return n, nil
+}
+
+func (r *rdbReader) readFull(n int) []byte {
+	buf := make([]byte, n)
+	io.ReadFull(r, buf)
+	return buf
+}
+
+// ImportRDB load a redis RDB (version <= 10) file from r to storager by IDB command interfaces,
+// the keys which are expired are skipped, expire time is rounded up to seconds,
+// the existing keys are overwritten by string, merged by list/hash/set/zset, flush dbs before if need.
+func ImportRDB(ctx context.Context, s IStorager, r io.Reader, opts ...option.Option) (stats RDBStats, err error) {
+	o := DefaultRDBImportOptions()
+	for _, opt := range opts {
+		opt.Apply(o)
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultRDBImportOptions().BatchSize
+	}
+
+	im := &rdbImporter{s: s, opts: o, r: &rdbReader{r: bufio.NewReader(r), crc: rdb.NewCrc64()}}
+	defer func() {
+		stats = im.stats
+		if e := recover(); e != nil {
+			re, ok := e.(rdbReadError)
+			if !ok {
+				panic(e)
+			}
+			err = fmt.Errorf("%w: %s", ErrRDBCorrupted, re.err)
+		}
+	}()
+
+	return im.stats, im.load(ctx)
+}
+
+type rdbImporter struct {
+	s     IStorager
+	opts  *RDBImportOptions
+	r     *rdbReader
+	db    IDB
+	stats RDBStats
+}
+
+const (
+	rdbFlagFunction2    = 0xf5
+	rdbFlagModuleAux    = 0xf7
+	rdbFlagIdle         = 0xf8
+	rdbFlagFreq         = 0xf9
+	rdbMaxVersion       = 10
+	rdbChecksumVersion  = 5
+	rdbHeaderMagic      = "REDIS"
+	rdbHeaderVersionLen = 4
+)
+
+func (im *rdbImporter) load(ctx context.Context) error {
+	head := im.r.readFull(len(rdbHeaderMagic) + rdbHeaderVersionLen)
+	if !bytes.HasPrefix(head, []byte(rdbHeaderMagic)) {
+		return fmt.Errorf("%w: bad magic", ErrRDBCorrupted)
+	}
+	version, err := strconv.Atoi(string(head[len(rdbHeaderMagic):]))
+	if err != nil || version < 1 || version > rdbMaxVersion {
+		return fmt.Errorf("%w: version %s", ErrRDBUnsupported, head[len(rdbHeaderMagic):])
+	}
+
+	var expireAt int64
+	now := time.Now().UnixMilli()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		flag := structure.ReadByte(im.r)
+		switch flag {
+		case rdb.RDBFlagAux:
+			structure.ReadString(im.r)
+			structure.ReadString(im.r)
+		case rdb.RDBFlagResizeDB:
+			structure.ReadLength(im.r)
+			structure.ReadLength(im.r)
+		case rdb.RDBFlagExpiryMS:
+			expireAt = int64(binary.LittleEndian.Uint64(im.r.readFull(8)))
+		case rdb.RDBFlagExpiry:
+			expireAt = int64(binary.LittleEndian.Uint32(im.r.readFull(4))) * 1000
+		case rdb.RDBFlagSelectDB:
+			if im.db, err = im.s.Select(ctx, int(structure.ReadLength(im.r))); err != nil {
+				return err
+			}
+		case rdbFlagIdle:
+			structure.ReadLength(im.r)
+		case rdbFlagFreq:
+			structure.ReadByte(im.r)
+		case rdbFlagModuleAux, rdbFlagFunction2:
+			return fmt.Errorf("%w: opcode %#x", ErrRDBUnsupported, flag)
+		case rdb.RDBFlagEOF:
+			return im.checksum(version)
+		default:
+			key := structure.ReadString(im.r)
+			obj := types.ParseObject(im.r, flag, key)
+			if obj == nil {
+				return fmt.Errorf("%w: type %d of key %q", ErrRDBCorrupted, flag, key)
+			}
+			if expireAt > 0 && expireAt <= now {
+				im.stats.Skipped++
+			} else if err := im.restore(ctx, []byte(key), obj, expireAt); err != nil {
+				return err
+			}
+			expireAt = 0
+		}
+	}
+}
+
+func (im *rdbImporter) checksum(version int) error {
+	if version < rdbChecksumVersion {
+		return nil
+	}
+	sum := im.r.crc.Sum64()
+	expected := binary.LittleEndian.Uint64(im.r.readFull(8))
+	// 0 is checksum disabled
+	if expected != 0 && expected != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrRDBCorrupted)
+	}
+	return nil
+}
+
+// restore write obj of key by commands, then set expire time
+func (im *rdbImporter) restore(ctx context.Context, key []byte, obj types.RedisObject, expireAt int64) error {
+	if im.db == nil {
+		var err error
+		if im.db, err = im.s.Select(ctx, 0); err != nil {
+			return err
+		}
+	}
+
+	var common ICommonCmd
+	var err error
+	switch o := obj.(type) {
+	case *types.StringObject:
+		common = im.db.DBString()
+		err = im.db.DBString().Set(ctx, key, []byte(o.Value))
+	case *types.ListObject:
+		common = im.db.DBList()
+		err = im.batch(len(o.Elements), func(i, j int) error {
+			_, err := im.db.DBList().RPush(ctx, key, stringsToBytes(o.Elements[i:j])...)
+			return err
+		})
+	case *types.SetObject:
+		common = im.db.DBSet()
+		err = im.batch(len(o.Elements), func(i, j int) error {
+			_, err := im.db.DBSet().SAdd(ctx, key, stringsToBytes(o.Elements[i:j])...)
+			return err
+		})
+	case *types.HashObject:
+		common = im.db.DBHash()
+		fvs := make([]FVPair, 0, len(o.Value))
+		for f, v := range o.Value {
+			fvs = append(fvs, FVPair{Field: []byte(f), Value: []byte(v)})
+		}
+		err = im.batch(len(fvs), func(i, j int) error {
+			return im.db.DBHash().HMset(ctx, key, fvs[i:j]...)
+		})
+	case *types.ZsetObject:
+		common = im.db.DBZSet()
+		pairs := make([]ScorePair, len(o.Elements))
+		for i, e := range o.Elements {
+			if pairs[i].Score, err = rdbZSetScore(e.Score); err != nil {
+				return im.unsupported(key, err)
+			}
+			pairs[i].Member = []byte(e.Member)
+		}
+		err = im.batch(len(pairs), func(i, j int) error {
+			_, err := im.db.DBZSet().ZAdd(ctx, key, pairs[i:j]...)
+			return err
+		})
+	default:
+		return im.unsupported(key, fmt.Errorf("%w: %T", ErrRDBUnsupported, obj))
+	}
+	if err != nil {
+		return err
+	}
+
+	im.stats.Keys++
+	if expireAt > 0 {
+		im.stats.Expires++
+		if _, err := common.ExpireAt(ctx, key, (expireAt+999)/1000); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *rdbImporter) unsupported(key []byte, err error) error {
+	if im.opts.SkipUnsupported {
+		im.stats.Skipped++
+		return nil
+	}
+	return fmt.Errorf("key %q: %w", key, err)
+}
+
+// batch call fn with [i, j) of n elements in BatchSize
+func (im *rdbImporter) batch(n int, fn func(i, j int) error) error {
+	for i := 0; i < n; i += im.opts.BatchSize {
+		j := i + im.opts.BatchSize
+		if j > n {
+			j = n
+		}
+		if err := fn(i, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rdbZSetScore zset score of rdb to int64 score, must be integer
+func rdbZSetScore(s string) (int64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: zset score %s", ErrRDBCorrupted, s)
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: zset score %s is not int64", ErrRDBUnsupported, s)
+	}
+	return int64(f), nil
+}
+
+func stringsToBytes(ss []string) [][]byte {
+	bs := make([][]byte, len(ss))
+	for i := range ss {
+		bs[i] = []byte(ss[i])
+	}
+	return bs
+}
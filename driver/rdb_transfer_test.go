@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cupcake/rdb"
+	"github.com/cupcake/rdb/nopdecoder"
+)
+
+// keyCounter count keys by an independent RDB decoder
+type keyCounter struct {
+	nopdecoder.NopDecoder
+	keys map[int]int
+	db   int
+}
+
+func (c *keyCounter) StartDatabase(n int) { c.db = n }
+func (c *keyCounter) count()              { c.keys[c.db]++ }
+
+func (c *keyCounter) Set(key, value []byte, expiry int64)             { c.count() }
+func (c *keyCounter) StartHash(key []byte, length, expiry int64)      { c.count() }
+func (c *keyCounter) StartSet(key []byte, cardinality, expiry int64)  { c.count() }
+func (c *keyCounter) StartList(key []byte, length, expiry int64)      { c.count() }
+func (c *keyCounter) StartZSet(key []byte, cardinality, expiry int64) { c.count() }
+
+func TestRDBExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newMemStorager()
+	db0, _ := src.Select(ctx, 0)
+	db3, _ := src.Select(ctx, 3)
+
+	expireAt := (time.Now().Unix() + 3600) * 1000
+	db0.DBString().Set(ctx, []byte("str"), []byte("value"))
+	db0.DBString().Set(ctx, []byte("int"), []byte("12345"))
+	db0.DBString().ExpireAt(ctx, []byte("int"), expireAt/1000)
+	db0.DBString().Set(ctx, []byte("expired"), []byte("v"))
+	db0.DBString().ExpireAt(ctx, []byte("expired"), 1)
+	db0.DBList().RPush(ctx, []byte("list"), []byte("a"), []byte("b"), []byte("c"))
+	db3.DBHash().HMset(ctx, []byte("hash"), FVPair{[]byte("f1"), []byte("v1")}, FVPair{[]byte("f2"), []byte("2")})
+	db3.DBSet().SAdd(ctx, []byte("set"), []byte("m1"), []byte("m2"))
+	db3.DBZSet().ZAdd(ctx, []byte("zset"), ScorePair{-10, []byte("m1")}, ScorePair{1 << 40, []byte("m2")})
+
+	buf := &bytes.Buffer{}
+	stats, err := ExportRDB(ctx, src, buf, 0, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (RDBStats{Keys: 6, Expires: 1, Skipped: 1}) {
+		t.Errorf("export Got %+v", stats)
+	}
+
+	counter := &keyCounter{keys: map[int]int{}}
+	if err := rdb.Decode(bytes.NewReader(buf.Bytes()), counter); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(counter.keys) != "map[0:3 3:3]" {
+		t.Errorf("Got %v expected %v", counter.keys, "map[0:3 3:3]")
+	}
+
+	dst := newMemStorager()
+	stats, err = ImportRDB(ctx, dst, bytes.NewReader(buf.Bytes()), WithRDBImportBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (RDBStats{Keys: 6, Expires: 1}) {
+		t.Errorf("import Got %+v", stats)
+	}
+	delete(src.dbs[0].data, "expired")
+	for _, i := range []int{0, 3} {
+		if got, expected := dst.dbs[i].dump(), src.dbs[i].dump(); got != expected {
+			t.Errorf("db %d Got %s expected %s", i, got, expected)
+		}
+	}
+}
+
+func TestRDBExportInexactScore(t *testing.T) {
+	ctx := context.Background()
+	src := newMemStorager()
+	db, _ := src.Select(ctx, 0)
+	db.DBZSet().ZAdd(ctx, []byte("zset"), ScorePair{-1 << 53, []byte("m1")}, ScorePair{1 << 53, []byte("m2")})
+	if _, err := ExportRDB(ctx, src, &bytes.Buffer{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	db.DBZSet().ZAdd(ctx, []byte("zset"), ScorePair{1<<53 + 1, []byte("m3")})
+	if _, err := ExportRDB(ctx, src, &bytes.Buffer{}, 0); !errors.Is(err, ErrRDBUnsupported) {
+		t.Errorf("Got %v expected %v", err, ErrRDBUnsupported)
+	}
+}
+
+// panicStorager panic when select db, the panic is not a read error of rdb
+type panicStorager struct {
+	*memStorager
+}
+
+func (s panicStorager) Select(ctx context.Context, index int) (IDB, error) {
+	panic("select")
+}
+
+func TestRDBImportPanic(t *testing.T) {
+	ctx := context.Background()
+	src := newMemStorager()
+	db, _ := src.Select(ctx, 0)
+	db.DBString().Set(ctx, []byte("key"), []byte("value"))
+	buf := &bytes.Buffer{}
+	if _, err := ExportRDB(ctx, src, buf, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if e := recover(); e != "select" {
+			t.Errorf("Got %v expected %v", e, "select")
+		}
+	}()
+	ImportRDB(ctx, panicStorager{newMemStorager()}, buf)
+}
+
+func TestRDBImportCorrupted(t *testing.T) {
+	ctx := context.Background()
+	src := newMemStorager()
+	db, _ := src.Select(ctx, 0)
+	db.DBString().Set(ctx, []byte("key"), []byte("value"))
+	buf := &bytes.Buffer{}
+	if _, err := ExportRDB(ctx, src, buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if _, err := ImportRDB(ctx, newMemStorager(), bytes.NewReader(data[:len(data)-12])); !errors.Is(err, ErrRDBCorrupted) {
+		t.Errorf("truncated Got %v expected %v", err, ErrRDBCorrupted)
+	}
+	data[len(data)-12] ^= 0xff
+	if _, err := ImportRDB(ctx, newMemStorager(), bytes.NewReader(data)); !errors.Is(err, ErrRDBCorrupted) {
+		t.Errorf("checksum Got %v expected %v", err, ErrRDBCorrupted)
+	}
+	if _, err := ImportRDB(ctx, newMemStorager(), bytes.NewReader([]byte("REDIS0099"))); !errors.Is(err, ErrRDBUnsupported) {
+		t.Errorf("version Got %v expected %v", err, ErrRDBUnsupported)
+	}
+}
@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/weedge/pkg/utils"
+)
+
+var ErrInvalidScanCursor = errors.New("ERR invalid cursor")
+
+// DefaultScanCount default COUNT of scan like redis
+const DefaultScanCount = 10
+
+// ScanTypes data types scanned by ScanDB in order
+var ScanTypes = []string{CmdTypeString, CmdTypeList, CmdTypeHash, CmdTypeSet, CmdTypeZset, CmdTypeStream}
+
+// ScanMatcher return the matcher of glob-style pattern, empty pattern matches all
+func ScanMatcher(match string) (func(b []byte) bool, error) {
+	if match == "" || match == "*" {
+		return func(b []byte) bool { return true }, nil
+	}
+	re, err := utils.BuildMatchRegexp(utils.GlobToRegexp(match))
+	if err != nil {
+		return nil, err
+	}
+	return re.Match, nil
+}
+
+func scanCmd(db IDB, dataType string) ICommonCmd {
+	var cmd ICommonCmd
+	switch dataType {
+	case CmdTypeString:
+		cmd = db.DBString()
+	case CmdTypeList:
+		cmd = db.DBList()
+	case CmdTypeHash:
+		cmd = db.DBHash()
+	case CmdTypeSet:
+		cmd = db.DBSet()
+	case CmdTypeZset:
+		cmd = db.DBZSet()
+	case CmdTypeStream:
+		cmd = db.DBStream()
+	}
+	if cmd == nil || utils.IsNil(cmd) {
+		return nil
+	}
+	return cmd
+}
+
+// ScanDB SCAN keys of all data types (ScanTypes) of db, or the type of args.Type,
+// the cursor is | index of type in ScanTypes (1 byte) | last examined key of type |,
+// nil is from start and the next cursor is nil if scan is done.
+// one call scans one type at most, so at most args.Count keys are examined,
+// the next cursor is the start of next type if the type is done.
+// return ErrInvalidScanCursor if the type of cursor with last key is not the type to scan.
+func ScanDB(ctx context.Context, db IDB, cursor []byte, args ScanArgs) (next []byte, keys [][]byte, err error) {
+	if args.Count <= 0 {
+		args.Count = DefaultScanCount
+	}
+	i, last := 0, []byte(nil)
+	if len(cursor) > 0 {
+		if int(cursor[0]) >= len(ScanTypes) {
+			return nil, nil, ErrInvalidScanCursor
+		}
+		i = int(cursor[0])
+		if len(cursor) > 1 {
+			last = cursor[1:]
+		}
+	}
+
+	keys = [][]byte{}
+	start := i
+	i, cmd := scanNextCmd(db, args.Type, i)
+	// the last key is of the cursor type, mismatch if args.Type is changed
+	if last != nil && (cmd == nil || i != start) {
+		return nil, nil, ErrInvalidScanCursor
+	}
+	if cmd == nil {
+		return nil, keys, nil
+	}
+	n, ks, err := cmd.Scan(ctx, last, ScanArgs{Match: args.Match, Count: args.Count})
+	if err != nil {
+		return nil, nil, err
+	}
+	keys = append(keys, ks...)
+	if n != nil {
+		return append([]byte{byte(i)}, n...), keys, nil
+	}
+	if i, cmd = scanNextCmd(db, args.Type, i+1); cmd == nil {
+		return nil, keys, nil
+	}
+	return []byte{byte(i)}, keys, nil
+}
+
+// scanNextCmd return the first type from ScanTypes[i] which db supports and matches dataType (empty is all)
+func scanNextCmd(db IDB, dataType string, i int) (int, ICommonCmd) {
+	for ; i < len(ScanTypes); i++ {
+		if dataType != "" && dataType != ScanTypes[i] {
+			continue
+		}
+		if cmd := scanCmd(db, ScanTypes[i]); cmd != nil {
+			return i, cmd
+		}
+	}
+	return i, nil
+}
+
+// ScanCursors map raw cursors to numeric cursors for redis clients which parse cursor as integer,
+// the latest size cursors are kept, the evicted cursor is invalid.
+type ScanCursors struct {
+	mu      sync.Mutex
+	size    int
+	id      uint64
+	cursors map[uint64][]byte
+	ring    []uint64
+}
+
+func NewScanCursors(size int) *ScanCursors {
+	if size <= 0 {
+		size = 1
+	}
+	return &ScanCursors{size: size, cursors: map[uint64][]byte{}, ring: make([]uint64, size)}
+}
+
+// Encode return numeric cursor of raw cursor, "0" if raw is nil (scan is done)
+func (s *ScanCursors) Encode(raw []byte) []byte {
+	if raw == nil {
+		return []byte("0")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id++
+	pos := s.id % uint64(s.size)
+	delete(s.cursors, s.ring[pos])
+	s.ring[pos] = s.id
+	s.cursors[s.id] = append([]byte{}, raw...)
+	return strconv.AppendUint(nil, s.id, 10)
+}
+
+// Decode return raw cursor of numeric cursor, nil if cursor is "0" (from start)
+func (s *ScanCursors) Decode(cursor []byte) ([]byte, error) {
+	id, err := strconv.ParseUint(string(cursor), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidScanCursor
+	}
+	if id == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.cursors[id]
+	if !ok {
+		return nil, ErrInvalidScanCursor
+	}
+	return raw, nil
+}
@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func scanAll(t *testing.T, db IDB, args ScanArgs, write func(i int)) string {
+	t.Helper()
+	keys, cursor := [][]byte{}, []byte(nil)
+	for i := 0; ; i++ {
+		next, ks, err := ScanDB(context.Background(), db, cursor, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, ks...)
+		if next == nil {
+			break
+		}
+		if write != nil {
+			write(i)
+		}
+		cursor = next
+	}
+	return fmt.Sprintf("%s", keys)
+}
+
+func TestScanDB(t *testing.T) {
+	ctx := context.Background()
+	db := &memDB{data: map[string]*memValue{}}
+	for i := 0; i < 25; i++ {
+		db.DBString().Set(ctx, []byte(fmt.Sprintf("s%02d", i)), nil)
+	}
+	db.DBHash().HMset(ctx, []byte("h1"), FVPair{[]byte("f"), []byte("v")})
+	db.DBSet().SAdd(ctx, []byte("set:1"), []byte("m"))
+	db.DBSet().SAdd(ctx, []byte("set:2"), []byte("m"))
+	db.DBZSet().ZAdd(ctx, []byte("z1"), ScorePair{1, []byte("m")})
+
+	expected := [][]byte{}
+	for i := 0; i < 25; i++ {
+		expected = append(expected, []byte(fmt.Sprintf("s%02d", i)))
+	}
+	expected = append(expected, []byte("h1"), []byte("set:1"), []byte("set:2"), []byte("z1"))
+	if got := scanAll(t, db, ScanArgs{Count: 7}, nil); got != fmt.Sprintf("%s", expected) {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	if got, expected := scanAll(t, db, ScanArgs{Type: CmdTypeSet, Count: 1}, nil), "[set:1 set:2]"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	if got, expected := scanAll(t, db, ScanArgs{Match: "s1?", Count: 3}, nil), "[s10 s11 s12 s13 s14 s15 s16 s17 s18 s19]"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+	if got, expected := scanAll(t, db, ScanArgs{Type: "none"}, nil), "[]"; got != expected {
+		t.Errorf("Got %s expected %s", got, expected)
+	}
+
+	// keys exist during the whole scan are returned exactly once with concurrent writes
+	got := scanAll(t, db, ScanArgs{Type: CmdTypeString, Count: 5}, func(i int) {
+		db.DBString().Set(ctx, []byte(fmt.Sprintf("s%02d+", i*5)), nil)
+		delete(db.data, fmt.Sprintf("s%02d", 24-i))
+	})
+	for i := 0; i < 20; i++ {
+		if n := bytes.Count([]byte(got), []byte(fmt.Sprintf("s%02d ", i))); n != 1 {
+			t.Errorf("s%02d Got %d times in %s", i, n, got)
+			break
+		}
+	}
+
+	// the examined keys of one call are limited by count even if none matches
+	next, keys, err := ScanDB(ctx, db, nil, ScanArgs{Match: "z*", Count: 30})
+	if err != nil || len(keys) != 0 || fmt.Sprint(next) != fmt.Sprint([]byte{1}) {
+		t.Errorf("Got %v %s, %v expected next type with no keys", next, keys, err)
+	}
+
+	if _, _, err := ScanDB(ctx, db, []byte{byte(len(ScanTypes))}, ScanArgs{}); err != ErrInvalidScanCursor {
+		t.Errorf("Got %v expected %v", err, ErrInvalidScanCursor)
+	}
+	// cursor of list keys is invalid for string type
+	if _, _, err := ScanDB(ctx, db, []byte{1, 'h'}, ScanArgs{Type: CmdTypeString}); err != ErrInvalidScanCursor {
+		t.Errorf("Got %v expected %v", err, ErrInvalidScanCursor)
+	}
+}
+
+func TestScanCursors(t *testing.T) {
+	s := NewScanCursors(2)
+	if c := s.Encode(nil); string(c) != "0" {
+		t.Errorf("Got %s expected 0", c)
+	}
+	c1, c2, c3 := s.Encode([]byte("k1")), s.Encode([]byte("k2")), s.Encode([]byte("k3"))
+	if raw, err := s.Decode(c3); err != nil || string(raw) != "k3" {
+		t.Errorf("Got %s, %v expected k3", raw, err)
+	}
+	if raw, err := s.Decode(c2); err != nil || string(raw) != "k2" {
+		t.Errorf("Got %s, %v expected k2", raw, err)
+	}
+	if _, err := s.Decode(c1); err != ErrInvalidScanCursor {
+		t.Errorf("evicted Got %v expected %v", err, ErrInvalidScanCursor)
+	}
+	if raw, err := s.Decode([]byte("0")); err != nil || raw != nil {
+		t.Errorf("Got %s, %v expected nil", raw, err)
+	}
+	if _, err := s.Decode([]byte("x")); err != ErrInvalidScanCursor {
+		t.Errorf("Got %v expected %v", err, ErrInvalidScanCursor)
+	}
+}
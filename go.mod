@@ -6,7 +6,9 @@ require (
 	github.com/apache/thrift v0.13.0
 	github.com/cloudwego/kitex v0.5.2
 	github.com/cupcake/rdb v0.0.0-20161107195141-43ba34106c76
+	github.com/golang/snappy v0.0.4
 	github.com/kitex-contrib/obs-opentelemetry/logging/zap v0.0.0-20230512024524-5f5a227105f7
+	github.com/klauspost/compress v1.16.7
 	github.com/redis/go-redis/v9 v9.0.5
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.15.0
@@ -1,6 +1,12 @@
 package utils
 
-import "container/list"
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrBatchOpDataCorrupted = errors.New("batch op data corrupted")
 
 type BatchOpBuffer struct {
 	// use list to batch once commit batch put one i/o syscall
@@ -13,6 +19,10 @@ const (
 	BatchOpTypeUnkonw byte = iota
 	BatchOpTypePut
 	BatchOpTypeDel
+	// delete keys in [Key, Value) range
+	BatchOpTypeDelRange
+	// merge operand Value to Key by merge operator
+	BatchOpTypeMerge
 )
 
 type BatchOp struct {
@@ -32,6 +42,16 @@ func (bt *BatchOpBuffer) Del(key []byte) {
 	bt.OpList.PushBack(&BatchOp{Key: key, Type: BatchOpTypeDel})
 }
 
+// DelRange delete keys in [start, end), nil or empty end is unbounded
+func (bt *BatchOpBuffer) DelRange(start, end []byte) {
+	bt.OpList.PushBack(&BatchOp{Key: start, Value: end, Type: BatchOpTypeDelRange})
+}
+
+// Merge merge operand to key
+func (bt *BatchOpBuffer) Merge(key, operand []byte) {
+	bt.OpList.PushBack(&BatchOp{Key: key, Value: operand, Type: BatchOpTypeMerge})
+}
+
 func (bt *BatchOpBuffer) FrontElement() *list.Element {
 	return bt.OpList.Front()
 }
@@ -43,3 +63,56 @@ func (bt *BatchOpBuffer) Reset() {
 func (bt *BatchOpBuffer) Len() int {
 	return bt.OpList.Len()
 }
+
+// Data encode batch ops to bytes
+// | op type | uvarint len(key) | key | uvarint len(value) | value |.....|
+func (bt *BatchOpBuffer) Data() []byte {
+	size := 0
+	for e := bt.OpList.Front(); e != nil; e = e.Next() {
+		op := e.Value.(*BatchOp)
+		size += 1 + 2*binary.MaxVarintLen32 + len(op.Key) + len(op.Value)
+	}
+
+	buf := make([]byte, 0, size)
+	for e := bt.OpList.Front(); e != nil; e = e.Next() {
+		op := e.Value.(*BatchOp)
+		buf = append(buf, op.Type)
+		buf = binary.AppendUvarint(buf, uint64(len(op.Key)))
+		buf = append(buf, op.Key...)
+		buf = binary.AppendUvarint(buf, uint64(len(op.Value)))
+		buf = append(buf, op.Value...)
+	}
+
+	return buf
+}
+
+// Load decode batch ops from Data() bytes, append to op list
+func (bt *BatchOpBuffer) Load(data []byte) error {
+	for len(data) > 0 {
+		op := &BatchOp{Type: data[0]}
+		data = data[1:]
+
+		var err error
+		if op.Key, data, err = readUvarintBytes(data); err != nil {
+			return err
+		}
+		if op.Value, data, err = readUvarintBytes(data); err != nil {
+			return err
+		}
+		bt.OpList.PushBack(op)
+	}
+
+	return nil
+}
+
+func readUvarintBytes(data []byte) (b []byte, rest []byte, err error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < l {
+		return nil, nil, ErrBatchOpDataCorrupted
+	}
+	if l > 0 {
+		b = data[n : n+int(l)]
+	}
+
+	return b, data[n+int(l):], nil
+}
@@ -0,0 +1,17 @@
+package utils
+
+// Crc16 crc16 xmodem (poly 0x1021, init 0), same as redis cluster key hash slot
+func Crc16(buf []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range buf {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
@@ -27,6 +28,84 @@ func BuildMatchRegexp(match string) (*regexp.Regexp, error) {
 	return r, nil
 }
 
+// GlobToRegexp convert redis glob-style pattern (eg: KEYS, SCAN MATCH, PSUBSCRIBE) to regexp,
+// supports * ? [abc] [^a] [a-z] and \\ escape, use BuildMatchRegexp to compile it.
+func GlobToRegexp(glob string) string {
+	buf := &strings.Builder{}
+	buf.WriteString("^(?s:")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := i + 1
+			for ; end < len(glob) && glob[end] != ']'; end++ {
+				if glob[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(glob) {
+				buf.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+				break
+			}
+			buf.WriteString(globClassToRegexp(glob[i+1 : end]))
+			i = end
+		default:
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	buf.WriteString(")$")
+	return buf.String()
+}
+
+// globClassToRegexp convert [class] without brackets, escaped chars are literal, reversed range is swapped like redis
+func globClassToRegexp(class string) string {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+	if class == "" {
+		if negate {
+			return "."
+		}
+		// empty class matches nothing
+		return "[^\\x00-\\x{10ffff}]"
+	}
+
+	buf := &strings.Builder{}
+	buf.WriteString("[")
+	if negate {
+		buf.WriteString("^")
+	}
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+		if c == '\\' && i+1 < len(class) {
+			i++
+			c = class[i]
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := c, class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			fmt.Fprintf(buf, "\\x{%x}-\\x{%x}", lo, hi)
+			i += 2
+			continue
+		}
+		fmt.Fprintf(buf, "\\x{%x}", c)
+	}
+	buf.WriteString("]")
+	return buf.String()
+}
+
 // AsyncNoBlockSend async no block send notify channel.
 func AsyncNoBlockSend(ch chan<- struct{}) {
 	select {
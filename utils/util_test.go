@@ -34,3 +34,46 @@ func TestConcatBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		match []string
+		not   []string
+	}{
+		{"*", []string{"", "abc", "a\nb"}, nil},
+		{"h?llo", []string{"hello", "hallo"}, []string{"hllo", "heello"}},
+		{"h*llo", []string{"hllo", "heeeello"}, []string{"hello!"}},
+		{"h[ae]llo", []string{"hello", "hallo"}, []string{"hillo"}},
+		{"h[^e]llo", []string{"hallo", "hbllo"}, []string{"hello"}},
+		{"h[a-b]llo", []string{"hallo", "hbllo"}, []string{"hcllo"}},
+		{"h[b-a]llo", []string{"hallo", "hbllo"}, []string{"hcllo"}},
+		{"h[\\]]llo", []string{"h]llo"}, []string{"hallo"}},
+		{"a.b+c\\*", []string{"a.b+c*"}, []string{"axb+c*", "a.b+cx"}},
+		{"news.[", []string{"news.["}, []string{"news.a"}},
+		{"[]", nil, []string{"", "a"}},
+	}
+	for _, tt := range tests {
+		re, err := BuildMatchRegexp(GlobToRegexp(tt.glob))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.glob, err)
+		}
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("%q should match %q", tt.glob, s)
+			}
+		}
+		for _, s := range tt.not {
+			if re.MatchString(s) {
+				t.Errorf("%q should not match %q", tt.glob, s)
+			}
+		}
+	}
+}
+
+func TestCrc16(t *testing.T) {
+	// redis cluster spec: crc16("123456789") = 0x31C3
+	if got := Crc16([]byte("123456789")); got != 0x31c3 {
+		t.Errorf("Crc16() = %x, want %x", got, 0x31c3)
+	}
+}